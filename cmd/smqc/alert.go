@@ -0,0 +1,131 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "strings"
+        "time"
+)
+
+/*
+Webhook alerts for the PGA ratio check. With -alert-webhook every non blacklisted station
+whose ratioDiff ratio is above -ratio-alert-threshold is sent in a single POST per run as
+
+        {"text": "...", "alerts": [{"station": ..., "ratio": ..., "max_vertical": ...,
+                "max_horizontal": ..., "timestamp": ..., "status": ...}]}
+
+The text makes it usable as a Slack incoming webhook as it is. status, with -run-diff, is
+new or persistent for whether the station was over the threshold last run too. Alerting is
+best effort, a failure is logged and doesn't fail the check.
+
+A run where checks failed posts them to the same webhook as well, so a check that keeps
+failing isn't only in the log, as
+
+        {"text": "...", "failures": [{"check": ..., "error": ...}]}
+*/
+
+type ratioAlert struct {
+        Station string `json:"station"`
+        Ratio float64 `json:"ratio"`
+        MaxVertical float64 `json:"max_vertical"`
+        MaxHorizontal float64 `json:"max_horizontal"`
+        Timestamp string `json:"timestamp"`
+        Status string `json:"status,omitempty"`
+}
+
+type ratioAlertPayload struct {
+        Text string `json:"text"`
+        Alerts []ratioAlert `json:"alerts"`
+}
+
+type checkFailure struct {
+        Check string `json:"check"`
+        Error string `json:"error"`
+}
+
+type checkFailurePayload struct {
+        Text string `json:"text"`
+        Source string `json:"source,omitempty"`
+        Failures []checkFailure `json:"failures"`
+}
+
+func sendRatioAlerts(alerts []ratioAlert) {
+        if len(alerts) == 0 {
+                return
+        }
+
+        if err := postRatioAlerts(alerts); err != nil {
+                trace.Printf("WARNING: sending %d ratio alerts: %s", len(alerts), err)
+                return
+        }
+        trace.Printf("Sent %d ratio alerts to -alert-webhook", len(alerts))
+}
+
+func postRatioAlerts(alerts []ratioAlert) error {
+        var lines []string
+        for _, a := range alerts {
+                line := fmt.Sprintf("%s ratio %s (vertical %s, horizontal %s)",
+                        a.Station, formatFloat(a.Ratio), formatFloat(a.MaxVertical), formatFloat(a.MaxHorizontal))
+                if a.Status == "new" {
+                        line = "NEW " + line
+                }
+                lines = append(lines, line)
+        }
+
+        b, err := json.Marshal(ratioAlertPayload{
+                Text: fmt.Sprintf("Strong Motion PGA ratio above %s for %d stations: %s",
+                        formatFloat(ratioAlertThreshold), len(alerts), strings.Join(lines, "; ")),
+                Alerts: alerts,
+        })
+        if err != nil {
+                return err
+        }
+
+        return postAlert(b)
+}
+
+// sendFailureAlert posts the run's failed checks to -alert-webhook.
+func sendFailureAlert(failures []checkFailure) {
+        if len(failures) == 0 {
+                return
+        }
+
+        var names []string
+        for _, f := range failures {
+                names = append(names, f.Check + " (" + f.Error + ")")
+        }
+
+        text := fmt.Sprintf("Strong Motion checks failed in the %s run: %s", runStart.Format(time.RFC3339), strings.Join(names, "; "))
+        if currentSource != "" {
+                text = "Source " + currentSource + ": " + text
+        }
+
+        b, err := json.Marshal(checkFailurePayload{Text: text, Source: currentSource, Failures: failures})
+        if err == nil {
+                err = postAlert(b)
+        }
+        if err != nil {
+                trace.Printf("WARNING: sending the %d check failures to -alert-webhook: %s", len(failures), err)
+                return
+        }
+        trace.Printf("Sent %d check failures to -alert-webhook", len(failures))
+}
+
+// postAlert POSTs the JSON body to -alert-webhook.
+func postAlert(b []byte) error {
+        client := &http.Client{Timeout: 10 * time.Second}
+
+        res, err := client.Post(alertWebhook, "application/json", bytes.NewReader(b))
+        if err != nil {
+                return err
+        }
+        defer res.Body.Close()
+
+        if res.StatusCode < 200 || res.StatusCode > 299 {
+                return fmt.Errorf("webhook returned %s", res.Status)
+        }
+
+        return nil
+}
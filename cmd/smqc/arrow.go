@@ -0,0 +1,112 @@
+package main
+
+import (
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "strings"
+        "sync"
+        "time"
+
+        "github.com/apache/arrow-go/v18/arrow"
+        "github.com/apache/arrow-go/v18/arrow/array"
+        "github.com/apache/arrow-go/v18/arrow/ipc"
+        "github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+/*
+Arrow IPC output for loading straight into columnar analytics without parsing CSV.
+
+With -arrow DIR each check's rows for the run are written as an Arrow IPC stream to
+DIR/<check>-<run time>.arrows, one record batch with a typed column per output column.
+With -arrow - the streams are written to stdout one after the other, each starting with
+its own schema, so a reader should keep opening streams until it reaches the end of input.
+*/
+
+var arrowStdoutMu sync.Mutex
+
+func arrowType(k columnKind) arrow.DataType {
+        switch k {
+        case intColumn:
+                return arrow.PrimitiveTypes.Int64
+        case floatColumn:
+                return arrow.PrimitiveTypes.Float64
+        }
+        return arrow.BinaryTypes.String
+}
+
+// arrowRecord is the run's rows as a record batch with a typed column per output column.
+func arrowRecord(o *checkOutput) (arrow.Record, error) {
+        fields := make([]arrow.Field, len(o.columns))
+        for i, c := range o.columns {
+                fields[i] = arrow.Field{Name: c.name, Type: arrowType(c.kind)}
+        }
+        schema := arrow.NewSchema(fields, nil)
+
+        b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+        defer b.Release()
+
+        for _, r := range o.rows {
+                if len(r.fields) != len(o.columns) {
+                        return nil, fmt.Errorf("%s row has %d fields, expected %d", o.name, len(r.fields), len(o.columns))
+                }
+
+                for i, v := range r.fields {
+                        switch fb := b.Field(i).(type) {
+                        case *array.Int64Builder:
+                                n, ok := v.(int)
+                                if !ok {
+                                        return nil, fmt.Errorf("%s column %s: %T is not an int", o.name, o.columns[i].name, v)
+                                }
+                                fb.Append(int64(n))
+                        case *array.Float64Builder:
+                                f, ok := v.(float64)
+                                if !ok {
+                                        return nil, fmt.Errorf("%s column %s: %T is not a float64", o.name, o.columns[i].name, v)
+                                }
+                                fb.Append(f)
+                        case *array.StringBuilder:
+                                fb.Append(formatField(v))
+                        }
+                }
+        }
+
+        return b.NewRecord(), nil
+}
+
+func writeArrow(o *checkOutput) error {
+        if len(o.columns) == 0 {
+                return nil
+        }
+
+        rec, err := arrowRecord(o)
+        if err != nil {
+                return err
+        }
+        defer rec.Release()
+
+        var w io.Writer = os.Stdout
+        if arrowOut == "-" {
+                // The checks run at the same time, one stream has to finish before the next.
+                arrowStdoutMu.Lock()
+                defer arrowStdoutMu.Unlock()
+        } else {
+                name := fmt.Sprintf("%s-%s.arrows", o.name, strings.ReplaceAll(runStart.Format(time.RFC3339), ":", ""))
+
+                f, err := os.Create(filepath.Join(arrowOut, name))
+                if err != nil {
+                        return err
+                }
+                defer f.Close()
+                w = f
+        }
+
+        iw := ipc.NewWriter(w, ipc.WithSchema(rec.Schema()), ipc.WithAllocator(memory.DefaultAllocator))
+        if err := iw.Write(rec); err != nil {
+                iw.Close()
+                return err
+        }
+
+        return iw.Close()
+}
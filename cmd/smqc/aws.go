@@ -0,0 +1,425 @@
+package main
+
+import (
+        "bytes"
+        "context"
+        "crypto/hmac"
+        "crypto/sha256"
+        "database/sql/driver"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "io"
+        "net"
+        "net/http"
+        "net/url"
+        "os"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/lib/pq"
+)
+
+/*
+Hazard database credentials from AWS instead of HAZARD_PASSWD in the cron job's environment.
+
+With -db-secret the password is read from that AWS Secrets Manager secret, either the
+password on its own or the JSON RDS secret format, whose host, port, dbname and username
+are used too when it has them. With -db-iam-auth there's no password at all, each
+connection gets a fresh 15 minute RDS IAM authentication token for -db-user.
+
+AWS credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN,
+or the container or EC2 instance role. The region is -aws-region, AWS_REGION, or taken from
+an RDS -db-host. Requests are signed with Signature Version 4 by hand rather than pulling
+in the AWS SDK for a few calls. AWS_ENDPOINT_URL_SECRETS_MANAGER overrides the Secrets
+Manager endpoint as it does for the SDKs, e.g. for a VPC endpoint.
+*/
+
+type awsCredentials struct {
+        AccessKeyID string `json:"AccessKeyId"`
+        SecretAccessKey string `json:"SecretAccessKey"`
+        Token string `json:"Token"`
+}
+
+var awsClient = &http.Client{Timeout: 10 * time.Second}
+
+// loadAWSCredentials looks in the environment, then the ECS container and EC2 instance
+// metadata endpoints.
+func loadAWSCredentials() (awsCredentials, error) {
+        if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+                return awsCredentials{id, os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}, nil
+        }
+
+        if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+                var c awsCredentials
+                b, err := awsGet("http://169.254.170.2" + uri, nil)
+                if err == nil {
+                        err = json.Unmarshal(b, &c)
+                }
+                if err != nil {
+                        return c, fmt.Errorf("container credentials: %w", err)
+                }
+                return c, nil
+        }
+
+        c, err := instanceCredentials()
+        if err != nil {
+                return c, fmt.Errorf("no AWS credentials in the environment and none from the instance role: %w", err)
+        }
+        return c, nil
+}
+
+func instanceCredentials() (awsCredentials, error) {
+        var c awsCredentials
+
+        const imds = "http://169.254.169.254/latest"
+
+        req, err := http.NewRequest(http.MethodPut, imds + "/api/token", nil)
+        if err != nil {
+                return c, err
+        }
+        req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+        client := &http.Client{Timeout: 2 * time.Second}
+        res, err := client.Do(req)
+        if err != nil {
+                return c, err
+        }
+        token, err := io.ReadAll(res.Body)
+        res.Body.Close()
+        if err != nil {
+                return c, err
+        }
+
+        header := http.Header{"X-aws-ec2-metadata-token": {string(token)}}
+
+        role, err := awsGet(imds + "/meta-data/iam/security-credentials/", header)
+        if err != nil {
+                return c, err
+        }
+
+        b, err := awsGet(imds + "/meta-data/iam/security-credentials/" + strings.TrimSpace(string(role)), header)
+        if err != nil {
+                return c, err
+        }
+
+        return c, json.Unmarshal(b, &c)
+}
+
+func awsGet(u string, header http.Header) ([]byte, error) {
+        req, err := http.NewRequest(http.MethodGet, u, nil)
+        if err != nil {
+                return nil, err
+        }
+        for k, v := range header {
+                req.Header[k] = v
+        }
+
+        res, err := awsClient.Do(req)
+        if err != nil {
+                return nil, err
+        }
+        defer res.Body.Close()
+
+        b, err := io.ReadAll(res.Body)
+        if err != nil {
+                return nil, err
+        }
+        if res.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("%s returned %s", u, res.Status)
+        }
+        return b, nil
+}
+
+// awsRegionFor gives -aws-region, or the region in an RDS host name.
+func awsRegionFor(host string) (string, error) {
+        if awsRegion != "" {
+                return awsRegion, nil
+        }
+
+        // e.g. geonet-api-ng-read.ccuclj9uvil4.ap-southeast-2.rds.amazonaws.com
+        parts := strings.Split(host, ".")
+        if n := len(parts); n >= 4 && parts[n-3] == "rds" && parts[n-2] == "amazonaws" {
+                return parts[n-4], nil
+        }
+
+        return "", fmt.Errorf("no -aws-region or AWS_REGION and it can't be told from -db-host %q", host)
+}
+
+// hazardSecret is the JSON RDS secret format, anything it leaves out keeps the flag's value.
+type hazardSecret struct {
+        Username string `json:"username"`
+        Password string `json:"password"`
+        Host string `json:"host"`
+        Port json.Number `json:"port"`
+        DBName string `json:"dbname"`
+}
+
+// applySecret fills in c from the Secrets Manager secret id.
+func applySecret(c *dbConfig, id string) error {
+        region, err := awsRegionFor(c.host)
+        if err != nil {
+                return err
+        }
+        creds, err := loadAWSCredentials()
+        if err != nil {
+                return err
+        }
+
+        secret, err := getSecretValue(creds, region, id)
+        if err != nil {
+                return fmt.Errorf("reading secret %s: %w", id, err)
+        }
+
+        var s hazardSecret
+        if !strings.HasPrefix(strings.TrimSpace(secret), "{") {
+                c.password = secret
+                return nil
+        }
+        if err := json.Unmarshal([]byte(secret), &s); err != nil {
+                return fmt.Errorf("reading secret %s: %w", id, err)
+        }
+
+        for _, f := range []struct {
+                dst *string
+                v string
+        }{{&c.user, s.Username}, {&c.password, s.Password}, {&c.host, s.Host}, {&c.port, s.Port.String()}, {&c.name, s.DBName}} {
+                if f.v != "" {
+                        *f.dst = f.v
+                }
+        }
+        return nil
+}
+
+func getSecretValue(creds awsCredentials, region, id string) (string, error) {
+        body, err := json.Marshal(struct {
+                SecretID string `json:"SecretId"`
+        }{id})
+        if err != nil {
+                return "", err
+        }
+
+        endpoint := envOr("AWS_ENDPOINT_URL_SECRETS_MANAGER", "https://secretsmanager." + region + ".amazonaws.com")
+        req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/") + "/", bytes.NewReader(body))
+        if err != nil {
+                return "", err
+        }
+        req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+        req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+        signRequest(req, body, creds, region, "secretsmanager", time.Now())
+
+        res, err := awsClient.Do(req)
+        if err != nil {
+                return "", err
+        }
+        defer res.Body.Close()
+
+        b, err := io.ReadAll(res.Body)
+        if err != nil {
+                return "", err
+        }
+        if res.StatusCode != http.StatusOK {
+                return "", fmt.Errorf("secrets manager returned %s: %s", res.Status, strings.TrimSpace(string(b)))
+        }
+
+        var v struct {
+                SecretString string `json:"SecretString"`
+        }
+        if err := json.Unmarshal(b, &v); err != nil {
+                return "", err
+        }
+        if v.SecretString == "" {
+                return "", fmt.Errorf("secret has no SecretString")
+        }
+        return v.SecretString, nil
+}
+
+// signRequest adds Signature Version 4 headers to req.
+func signRequest(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+        amzDate := now.UTC().Format("20060102T150405Z")
+        req.Header.Set("X-Amz-Date", amzDate)
+        if creds.Token != "" {
+                req.Header.Set("X-Amz-Security-Token", creds.Token)
+        }
+
+        var names []string
+        headers := map[string]string{"host": req.URL.Host}
+        for k, v := range req.Header {
+                headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+        }
+        for k := range headers {
+                names = append(names, k)
+        }
+        sort.Strings(names)
+
+        var canonicalHeaders strings.Builder
+        for _, k := range names {
+                canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+        }
+        signedHeaders := strings.Join(names, ";")
+
+        canonicalURI := req.URL.EscapedPath()
+        if canonicalURI == "" {
+                canonicalURI = "/"
+        }
+
+        canonical := strings.Join([]string{
+                req.Method,
+                canonicalURI,
+                "",
+                canonicalHeaders.String(),
+                signedHeaders,
+                sha256Hex(body),
+        }, "\n")
+
+        scope, signature := awsSignature(creds, region, service, amzDate, canonical)
+
+        req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+                creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// rdsAuthToken is a presigned rds-db:connect URL, used as the password for IAM
+// authentication.
+func rdsAuthToken(creds awsCredentials, region, endpoint, user string, now time.Time) string {
+        q := presignQuery(creds, region, "rds-db", endpoint, "/", map[string]string{"Action": "connect", "DBUser": user}, 900, sha256Hex(nil), now)
+        return endpoint + "/?" + q
+}
+
+// presignQuery is the signed query string of a presigned GET of path on host, with params
+// and valid for expires seconds. payload is the hashed payload the service expects, RDS
+// the hash of an empty body and S3 UNSIGNED-PAYLOAD.
+func presignQuery(creds awsCredentials, region, service, host, path string, params map[string]string, expires int, payload string, now time.Time) string {
+        amzDate := now.UTC().Format("20060102T150405Z")
+        date := amzDate[:8]
+
+        q := map[string]string{
+                "X-Amz-Algorithm": "AWS4-HMAC-SHA256",
+                "X-Amz-Credential": creds.AccessKeyID + "/" + date + "/" + region + "/" + service + "/aws4_request",
+                "X-Amz-Date": amzDate,
+                "X-Amz-Expires": strconv.Itoa(expires),
+                "X-Amz-SignedHeaders": "host",
+        }
+        for k, v := range params {
+                q[k] = v
+        }
+        if creds.Token != "" {
+                q["X-Amz-Security-Token"] = creds.Token
+        }
+
+        var keys []string
+        for k := range q {
+                keys = append(keys, k)
+        }
+        sort.Strings(keys)
+
+        var pairs []string
+        for _, k := range keys {
+                pairs = append(pairs, awsEscape(k) + "=" + awsEscape(q[k]))
+        }
+        query := strings.Join(pairs, "&")
+
+        canonical := strings.Join([]string{
+                http.MethodGet,
+                path,
+                query,
+                "host:" + host + "\n",
+                "host",
+                payload,
+        }, "\n")
+
+        _, signature := awsSignature(creds, region, service, amzDate, canonical)
+
+        return query + "&X-Amz-Signature=" + signature
+}
+
+// awsSignature gives the credential scope and signature of a canonical request.
+func awsSignature(creds awsCredentials, region, service, amzDate, canonical string) (string, string) {
+        date := amzDate[:8]
+        scope := date + "/" + region + "/" + service + "/aws4_request"
+
+        toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+
+        key := []byte("AWS4" + creds.SecretAccessKey)
+        for _, part := range []string{date, region, service, "aws4_request"} {
+                key = hmacSHA256(key, part)
+        }
+
+        return scope, hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+        h := hmac.New(sha256.New, key)
+        h.Write([]byte(s))
+        return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+        h := sha256.Sum256(b)
+        return hex.EncodeToString(h[:])
+}
+
+// awsEscape is the URI encoding Signature Version 4 wants, spaces as %20.
+func awsEscape(s string) string {
+        return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// hazardIAMConnector is the hazard database with -db-iam-auth.
+func hazardIAMConnector() (driver.Connector, error) {
+        if dsnFile != "" || dbSecret != "" || passwordFile != "" {
+                return nil, fmt.Errorf("-db-iam-auth can't be used with -dsn-file, -db-secret or -password-file")
+        }
+
+        c := hazardDB
+        if c.sslMode == "disable" {
+                return nil, fmt.Errorf("-db-iam-auth needs -sslmode require or stricter, RDS only accepts IAM tokens over TLS")
+        }
+
+        region, err := awsRegionFor(c.host)
+        if err != nil {
+                return nil, err
+        }
+        creds, err := loadAWSCredentials()
+        if err != nil {
+                return nil, err
+        }
+
+        // A token up front so a missing setting fails here as it would for a password.
+        c.password = rdsAuthToken(creds, region, net.JoinHostPort(c.host, c.port), c.user, time.Now())
+        if err := c.validate(); err != nil {
+                return nil, err
+        }
+
+        return &iamConnector{config: hazardDB, region: region}, nil
+}
+
+// iamConnector connects to the hazard database with a new IAM token each time, a pooled
+// connection can outlive the 15 minutes one lasts.
+type iamConnector struct {
+        config dbConfig
+        region string
+}
+
+func (c *iamConnector) Connect(ctx context.Context) (driver.Conn, error) {
+        creds, err := loadAWSCredentials()
+        if err != nil {
+                return nil, err
+        }
+
+        config := c.config
+        config.password = rdsAuthToken(creds, c.region, net.JoinHostPort(config.host, config.port), config.user, time.Now())
+
+        connector, err := pq.NewConnector(config.dsn())
+        if err != nil {
+                return nil, err
+        }
+        connector.Dialer(&keepAliveDialer{net.Dialer{KeepAlive: keepAlive}})
+
+        return connector.Connect(ctx)
+}
+
+func (c *iamConnector) Driver() driver.Driver {
+        return &pq.Driver{}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+        "fmt"
+        "math"
+        "sort"
+        "time"
+)
+
+/*
+A station that slowly gets noisier can go weeks without crossing -noise-count-min or
+-ratio-alert-threshold. With -baseline each station's noise count and PGA ratio this run
+are compared with its own history over -baseline-window, and up to -limit of those more
+than -baseline-sigma robust standard deviations from their median are appended to
+baseline.csv as
+
+        timestamp,station,blacklist,check,value,median,sigma,deviation
+
+largest deviation first, the deviation in sigmas and negative for a value below the
+median. The sigma is 1.4826 times the median absolute deviation, the standard deviation for
+normally distributed values, but at least a tenth of the median so a station whose count
+has never moved isn't flagged for every small change. The station's value for a run is its
+largest, the noisiest component for noiseCount. A station needs -baseline-min-runs earlier
+runs in the history before it's compared.
+
+The history only has the rows the checks wrote, at most -limit stations a run and for
+noiseCount only PGA counts over -noise-count-min, so a lower -noise-count-min and higher
+-limit give quieter stations a baseline too.
+*/
+
+var baselineColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"check", textColumn},
+        {"value", floatColumn},
+        {"median", floatColumn},
+        {"sigma", floatColumn},
+        {"deviation", floatColumn},
+}
+
+// baselineMetrics are the checks' columns that get a baseline.
+var baselineMetrics = []struct {
+        check string
+        column string
+}{
+        {"noiseCount", "noise_count"},
+        {"ratioDiff", "ratio"},
+}
+
+type baselineRow struct {
+        station string
+        blacklist string
+        check string
+        value float64
+        median float64
+        sigma float64
+        deviation float64
+}
+
+// median of values, which it sorts.
+func median(values []float64) float64 {
+        sort.Float64s(values)
+        n := len(values)
+        if n == 0 {
+                return 0
+        }
+        if n%2 == 0 {
+                return (values[n/2-1] + values[n/2]) / 2
+        }
+        return values[n/2]
+}
+
+// robustSigma is the station's median and sigma over its earlier runs.
+func robustSigma(values []float64) (float64, float64) {
+        m := median(values)
+
+        deviations := make([]float64, len(values))
+        for i, v := range values {
+                deviations[i] = math.Abs(v - m)
+        }
+        sigma := 1.4826 * median(deviations)
+
+        return m, math.Max(sigma, math.Abs(m) / 10)
+}
+
+// stationRuns is each station's value for each run in the history, the largest when a run
+// wrote more than one row for it.
+type stationRuns map[string]map[time.Time]float64
+
+func (s stationRuns) add(station string, at time.Time, value float64) {
+        if s[station] == nil {
+                s[station] = map[time.Time]float64{}
+        }
+        if v, ok := s[station][at]; !ok || value > v {
+                s[station][at] = value
+        }
+}
+
+// baselineRows compares this run's values for a check with each station's history.
+func baselineRows(check, column string) ([]baselineRow, error) {
+        values, err := readHistoryValues(dir, check, column, runStart.Add(-baselineWindow))
+        if err != nil {
+                return nil, err
+        }
+
+        // This run's rows are already in the history, with a timestamp from the database
+        // that can be a little before or after runStart.
+        cutoff := runStart.Add(-5 * time.Minute)
+
+        current, earlier := stationRuns{}, stationRuns{}
+        blacklists := map[string]string{}
+        for _, v := range values {
+                if v.at.Before(cutoff) {
+                        earlier.add(v.station, v.at, v.value)
+                        continue
+                }
+                current.add(v.station, v.at, v.value)
+                blacklists[v.station] = v.blacklist
+        }
+
+        var rows []baselineRow
+        for station, runs := range current {
+                if len(earlier[station]) < baselineMinRuns {
+                        continue
+                }
+
+                var value float64
+                for _, v := range runs {
+                        value = math.Max(value, v)
+                }
+                var history []float64
+                for _, v := range earlier[station] {
+                        history = append(history, v)
+                }
+
+                // Only a history of nothing but zeroes has no spread, there's nothing to
+                // compare with.
+                m, sigma := robustSigma(history)
+                if sigma == 0 {
+                        continue
+                }
+                deviation := (value - m) / sigma
+                if math.Abs(deviation) <= baselineSigma {
+                        continue
+                }
+
+                rows = append(rows, baselineRow{station, blacklists[station], check, value, m, sigma, deviation})
+        }
+
+        return rows, nil
+}
+
+func baseline(db querier, tr *checkTrace) (checkResult, error) {
+        var rows []baselineRow
+        for _, m := range baselineMetrics {
+                r, err := baselineRows(m.check, m.column)
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("reading %s history: %w", m.check, err)
+                }
+                rows = append(rows, r...)
+        }
+
+        sort.Slice(rows, func(i, j int) bool {
+                a, b := math.Abs(rows[i].deviation), math.Abs(rows[j].deviation)
+                if a != b {
+                        return a > b
+                }
+                return rows[i].station < rows[j].station
+        })
+        if len(rows) > limit {
+                rows = rows[:limit]
+        }
+
+        done := tr.writing()
+        defer done()
+
+        out := newCheckOutput("baseline", baselineColumns...)
+        defer out.Close()
+
+        timestamp := runStart.Format(time.RFC3339)
+
+        var concerns int
+        for _, r := range rows {
+                tr.row()
+                flagStation("baseline", r.station, r.blacklist)
+                if r.blacklist != "true" {
+                        concerns++
+                        overThreshold("baseline", r.station)
+                }
+
+                err := out.write(r.station, math.Abs(r.deviation), timestamp, r.station, r.blacklist, r.check, r.value, r.median, r.sigma, r.deviation)
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
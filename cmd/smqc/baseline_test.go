@@ -0,0 +1,28 @@
+package main
+
+import (
+        "math"
+        "testing"
+)
+
+func TestRobustSigma(t *testing.T) {
+        for _, c := range []struct {
+                name string
+                values []float64
+                median float64
+                sigma float64
+        }{
+                // The outlier moves neither the median nor the MAD.
+                {"outlier", []float64{4, 1, 100, 3, 2}, 3, 1.4826},
+                {"even", []float64{-20, -10}, -15, 7.413},
+                // A station that always reports the same has a tenth of its median as sigma.
+                {"constant", []float64{10, 10, 10, 10}, 10, 1},
+                {"zero", []float64{0, 0, 0}, 0, 0},
+                {"empty", nil, 0, 0},
+        } {
+                m, sigma := robustSigma(c.values)
+                if math.Abs(m - c.median) > 1e-9 || math.Abs(sigma - c.sigma) > 1e-9 {
+                        t.Errorf("%s: expected %g and %g, got %g and %g", c.name, c.median, c.sigma, m, sigma)
+                }
+        }
+}
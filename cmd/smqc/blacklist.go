@@ -0,0 +1,289 @@
+package main
+
+import (
+        "encoding/csv"
+        "encoding/json"
+        "flag"
+        "fmt"
+        "os"
+        "os/user"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "sync"
+        "time"
+)
+
+/*
+Blacklisting stations from the same tool instead of by hand in psql.
+
+        smqc blacklist add [-reason "..."] [-db] STATION...
+        smqc blacklist remove [-reason "..."] [-db] STATION...
+        smqc blacklist list [-db]
+
+By default add and remove record an override in blacklistOverrides.json in the output
+directory, which every run reads and which takes precedence over impact.source's
+blacklist flag for the station, so a station removed there isn't blacklisted even when
+the database says it is. With -db they set impact.source's flag instead, through the
+hazard database flags, so -db-user needs to be a user that can update it. Either way
+each change is appended to blacklistAudit.csv as
+
+        timestamp,action,station,target,user,reason
+
+with a target of file or db. list prints the overrides, and with -db the stations
+impact.source has blacklisted as well.
+*/
+
+const (
+        blacklistFile = "blacklistOverrides.json"
+        blacklistAuditFile = "blacklistAudit.csv"
+)
+
+type blacklistOverride struct {
+        Blacklist bool `json:"blacklist"`
+        Reason string `json:"reason,omitempty"`
+        By string `json:"by"`
+        At time.Time `json:"at"`
+}
+
+// blacklistOverrides are the overrides read at the start of the run.
+var blacklistOverrides = struct {
+        sync.Mutex
+        stations map[string]blacklistOverride
+}{}
+
+// loadBlacklistOverrides is best effort, a file that can't be read keeps the last run's.
+func loadBlacklistOverrides() {
+        overrides, err := readBlacklistOverrides()
+        if err != nil {
+                trace.Printf("WARNING: reading %s: %s", blacklistFile, err)
+                return
+        }
+
+        blacklistOverrides.Lock()
+        defer blacklistOverrides.Unlock()
+        blacklistOverrides.stations = overrides
+}
+
+func readBlacklistOverrides() (map[string]blacklistOverride, error) {
+        overrides := map[string]blacklistOverride{}
+
+        b, err := os.ReadFile(filepath.Join(dir, blacklistFile))
+        if os.IsNotExist(err) {
+                return overrides, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+
+        if err := json.Unmarshal(b, &overrides); err != nil {
+                return nil, err
+        }
+        return overrides, nil
+}
+
+// overrideBlacklist is the blacklist flag a check should use for a station, blacklist being
+// impact.source's.
+func overrideBlacklist(station, blacklist string) string {
+        blacklistOverrides.Lock()
+        defer blacklistOverrides.Unlock()
+
+        if o, ok := blacklistOverrides.stations[station]; ok {
+                return strconv.FormatBool(o.Blacklist)
+        }
+        return blacklist
+}
+
+// blacklistFlags are the overrides' flags by station, for the smqc package's checks.
+func blacklistFlags() map[string]bool {
+        blacklistOverrides.Lock()
+        defer blacklistOverrides.Unlock()
+
+        flags := map[string]bool{}
+        for station, o := range blacklistOverrides.stations {
+                flags[station] = o.Blacklist
+        }
+        return flags
+}
+
+func blacklistCommand(args []string) error {
+        usage := fmt.Errorf("usage: blacklist add|remove [-reason REASON] [-db] STATION... or blacklist list [-db]")
+        if len(args) == 0 {
+                return usage
+        }
+
+        action := args[0]
+        fs := flag.NewFlagSet("blacklist "+action, flag.ContinueOnError)
+        reason := fs.String("reason", "", "why the station is being blacklisted or removed, for the audit trail")
+        inDB := fs.Bool("db", false, "change impact.source's blacklist flag instead of the local override")
+        if err := fs.Parse(args[1:]); err != nil {
+                return err
+        }
+
+        if *inDB && dumpDir != "" {
+                return fmt.Errorf("-db can't be used with -dump-dir")
+        }
+
+        switch action {
+        case "add", "remove":
+                if fs.NArg() == 0 {
+                        return usage
+                }
+                if *inDB {
+                        return blacklistInDB(action, fs.Args(), *reason)
+                }
+                return blacklistInFile(action, fs.Args(), *reason)
+        case "list":
+                return listBlacklist(*inDB)
+        }
+
+        return usage
+}
+
+func blacklistInFile(action string, stations []string, reason string) error {
+        overrides, err := readBlacklistOverrides()
+        if err != nil {
+                return err
+        }
+
+        by, now := operator(), time.Now().UTC()
+        for _, station := range stations {
+                overrides[station] = blacklistOverride{Blacklist: action == "add", Reason: reason, By: by, At: now}
+        }
+
+        b, err := json.MarshalIndent(overrides, "", "  ")
+        if err != nil {
+                return err
+        }
+
+        path := filepath.Join(dir, blacklistFile)
+        tmp := path + ".tmp"
+        if err := os.WriteFile(tmp, b, 0644); err != nil {
+                return err
+        }
+        if err := os.Rename(tmp, path); err != nil {
+                return err
+        }
+
+        return auditBlacklist(action, stations, "file", by, reason)
+}
+
+func blacklistInDB(action string, stations []string, reason string) error {
+        db := openHazard()
+        defer db.Close()
+
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        // All or none of them, so the audit trail matches the table.
+        tx, err := db.BeginTx(ctx, nil)
+        if err != nil {
+                return queryError(ctx, err)
+        }
+        defer tx.Rollback()
+
+        for _, station := range stations {
+                res, err := tx.ExecContext(ctx, `UPDATE impact.source SET blacklist = $1 WHERE station = $2`, action == "add", station)
+                if err != nil {
+                        return queryError(ctx, err)
+                }
+                if n, err := res.RowsAffected(); err == nil && n == 0 {
+                        return fmt.Errorf("station %s isn't in impact.source", station)
+                }
+        }
+
+        if err := tx.Commit(); err != nil {
+                return queryError(ctx, err)
+        }
+
+        return auditBlacklist(action, stations, "db", operator(), reason)
+}
+
+func auditBlacklist(action string, stations []string, target, by, reason string) error {
+        f, err := os.OpenFile(filepath.Join(dir, blacklistAuditFile), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+
+        w := csv.NewWriter(f)
+        timestamp := time.Now().UTC().Format(time.RFC3339)
+
+        for _, station := range stations {
+                w.Write([]string{timestamp, action, station, target, by, reason})
+                trace.Printf("Blacklist %s %s in %s by %s", action, station, target, by)
+        }
+
+        w.Flush()
+        return w.Error()
+}
+
+// operator is who's making a change, for the audit trail.
+func operator() string {
+        if u, err := user.Current(); err == nil && u.Username != "" {
+                return u.Username
+        }
+        if u := os.Getenv("USER"); u != "" {
+                return u
+        }
+        return "unknown"
+}
+
+func listBlacklist(inDB bool) error {
+        overrides, err := readBlacklistOverrides()
+        if err != nil {
+                return err
+        }
+
+        w := csv.NewWriter(os.Stdout)
+        w.Write([]string{"station", "blacklist", "source", "reason", "by", "at"})
+
+        var stations []string
+        for station := range overrides {
+                stations = append(stations, station)
+        }
+        sort.Strings(stations)
+
+        for _, station := range stations {
+                o := overrides[station]
+                w.Write([]string{station, strconv.FormatBool(o.Blacklist), "file", o.Reason, o.By, o.At.Format(time.RFC3339)})
+        }
+
+        if inDB {
+                blacklisted, err := dbBlacklist()
+                if err != nil {
+                        return err
+                }
+                for _, station := range blacklisted {
+                        w.Write([]string{station, "true", "db", "", "", ""})
+                }
+        }
+
+        w.Flush()
+        return w.Error()
+}
+
+// dbBlacklist are the stations impact.source has blacklisted.
+func dbBlacklist() ([]string, error) {
+        db := openHazard()
+        defer db.Close()
+
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        rows, err := db.QueryContext(ctx, `SELECT station FROM impact.source WHERE blacklist ORDER BY station`)
+        if err != nil {
+                return nil, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var stations []string
+        for rows.Next() {
+                var s string
+                if err := rows.Scan(&s); err != nil {
+                        return nil, err
+                }
+                stations = append(stations, s)
+        }
+        return stations, queryError(ctx, rows.Err())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+)
+
+/*
+The checks main runs are the ones in checkRegistry that their flags enable, narrowed down by
+-checks and -skip-checks. A new check is a file with its query and run function plus an
+entry here, main doesn't need to know about it.
+*/
+
+// check is one of the Strong Motion noise checks. Run queries the database and writes the
+// check's rows through a checkOutput named after it.
+type check interface {
+        Name() string
+        // Description is logged as the check starts.
+        Description() string
+        // After is for checks that read what the others wrote or flagged this run, they're
+        // run one at a time once the rest have finished.
+        After() bool
+        // Setup is run once before any check, for state that has to be read before this
+        // run's checks write anything.
+        Setup() error
+        Run(db querier, tr *checkTrace) (checkResult, error)
+}
+
+// checkFunc is a check that's a single function, which all of them are so far.
+type checkFunc struct {
+        name string
+        msg string
+        run func(querier, *checkTrace) (checkResult, error)
+        after bool
+        setup func() error
+}
+
+func (c checkFunc) Name() string { return c.name }
+func (c checkFunc) Description() string { return c.msg }
+func (c checkFunc) After() bool { return c.after }
+
+func (c checkFunc) Setup() error {
+        if c.setup == nil {
+                return nil
+        }
+        return c.setup()
+}
+
+func (c checkFunc) Run(db querier, tr *checkTrace) (checkResult, error) {
+        return c.run(db, tr)
+}
+
+// failedSetup is a check whose Setup failed, it fails when it's run so the run's other
+// checks still run and it's reported with them.
+type failedSetup struct {
+        check
+        err error
+}
+
+func (c failedSetup) Run(db querier, tr *checkTrace) (checkResult, error) {
+        return checkResult{}, fmt.Errorf("setup: %w", c.err)
+}
+
+// setupChecks runs each check's Setup, replacing those that fail with a failedSetup.
+func setupChecks(checks []check) []check {
+        ready := make([]check, len(checks))
+        for i, c := range checks {
+                ready[i] = c
+                if err := c.Setup(); err != nil {
+                        trace.Error("check setup failed", "check", c.Name(), "error", err)
+                        ready[i] = failedSetup{c, err}
+                }
+        }
+        return ready
+}
+
+// checkRegistry is every check in the order they're run. enabled is nil for a check that
+// always runs, otherwise it says whether the check's own flags ask for it.
+var checkRegistry = []struct {
+        check check
+        enabled func() bool
+}{
+        {checkFunc{name: "noiseCount", msg: "Getting top noise counts for Strong Motion", run: noiseCount}, nil},
+        {checkFunc{name: "ratioDiff", msg: "Getting PGV ratio difference for Strong Motion", run: ratioDiff}, nil},
+        {checkFunc{name: "pgvRatio", msg: "Getting PGV vertical versus horizontal ratio for Strong Motion", run: pgvRatio}, nil},
+        {checkFunc{name: "mmiCheck", msg: "Getting constant or implausible MMI for Strong Motion", run: mmiCheck}, nil},
+        {checkFunc{name: "mmiFelt", msg: "Looking for felt MMI without an earthquake to explain it", run: mmiFelt}, func() bool { return mmiFeltCheck }},
+        {checkFunc{name: "flatline", msg: "Looking for flatlined Strong Motion channels", run: flatline}, func() bool { return flatlineCheck }},
+        {checkFunc{name: "dataGap", msg: "Looking for Strong Motion stations that have gone quiet", run: dataGap}, func() bool { return dataGapCheck }},
+        {checkFunc{name: "spike", msg: "Looking for physically implausible Strong Motion spikes", run: spike}, func() bool { return spikeCheck }},
+        {checkFunc{name: "colocatedNoise", msg: "Comparing noise counts for colocated Strong Motion stations", run: colocatedNoise}, func() bool { return len(colocatedPairs) > 0 }},
+        {checkFunc{name: "latency", msg: "Measuring how far behind each Strong Motion station's data is", run: latency}, func() bool { return latencyThreshold > 0 }},
+        {checkFunc{name: "fdsnStations", msg: "Comparing Strong Motion stations with the FDSN station metadata", run: fdsnStations}, func() bool { return fdsnStationsCheck }},
+
+        // After the other checks have finished so this run's rows are part of the history.
+        {checkFunc{name: "blacklistFlapping", msg: "Looking for Strong Motion stations flapping in and out of the blacklist", run: blacklistFlapping, after: true}, func() bool { return flapping }},
+
+        {checkFunc{name: "baseline", msg: "Comparing Strong Motion stations with their own history", run: baseline, after: true}, func() bool { return baselineReport }},
+
+        {checkFunc{name: "healthScore", msg: "Scoring Strong Motion stations across the checks", run: healthScore, after: true}, func() bool { return healthScoreReport }},
+
+        {checkFunc{name: "deepCheck", msg: "Measuring waveform noise for the highest scoring Strong Motion stations", run: deepCheck, after: true}, func() bool { return deepCheckStations > 0 }},
+
+        {checkFunc{name: "groupSummary", msg: "Summarising the Strong Motion results by network or region", run: groupSummary, after: true}, func() bool { return groupBy != "" }},
+
+        // Last, it looks at what every other check flagged.
+        {checkFunc{name: "newStations", msg: "Looking for Strong Motion stations flagged for the first time", run: newStations, after: true, setup: loadSeenStations}, func() bool { return newStationsFeed }},
+}
+
+// selectChecks gives the enabled checks, only those in the comma separated only if it's
+// set and leaving out those in skip.
+func selectChecks(only, skip string) ([]check, error) {
+        known := map[string]bool{}
+        for _, r := range checkRegistry {
+                known[r.check.Name()] = true
+        }
+
+        parse := func(flagName, s string) (map[string]bool, error) {
+                names := map[string]bool{}
+                for _, name := range strings.Split(s, ",") {
+                        name = strings.TrimSpace(name)
+                        if name == "" {
+                                continue
+                        }
+                        if !known[name] {
+                                return nil, fmt.Errorf("unknown check %q in %s", name, flagName)
+                        }
+                        names[name] = true
+                }
+                return names, nil
+        }
+
+        onlyNames, err := parse("-checks", only)
+        if err != nil {
+                return nil, err
+        }
+        skipNames, err := parse("-skip-checks", skip)
+        if err != nil {
+                return nil, err
+        }
+
+        var checks []check
+        for _, r := range checkRegistry {
+                name := r.check.Name()
+                if skipNames[name] || (len(onlyNames) > 0 && !onlyNames[name]) {
+                        continue
+                }
+                if r.enabled != nil && !r.enabled() {
+                        if onlyNames[name] {
+                                trace.Printf("WARNING: %s is in -checks but its own flags don't enable it, skipping", name)
+                        }
+                        continue
+                }
+                checks = append(checks, r.check)
+        }
+
+        if len(checks) == 0 {
+                return nil, fmt.Errorf("no checks to run")
+        }
+
+        return checks, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+        "context"
+        "encoding/json"
+        "errors"
+        "net/http"
+        "net/http/httptest"
+        "os"
+        "path/filepath"
+        "strings"
+        "testing"
+        "time"
+
+        "github.com/DATA-DOG/go-sqlmock"
+        "github.com/mabznz/smqc/smqc"
+)
+
+// The columns each check's query returns, for sqlmock.NewRows.
+var (
+        ratioRowColumns = []string{"current_timestamp", "station", "blacklist", "ratio", "max_vertical", "max_horizontal"}
+        countRowColumns = []string{"current_timestamp", "station", "blacklist", "pga_count", "pgv_count"}
+        spikeRowColumns = []string{"current_timestamp", "station", "blacklist", "max_pga", "max_pgv"}
+        latencyRowColumns = []string{"current_timestamp", "station", "blacklist", "latest_pga", "latest_pgv"}
+)
+
+func TestPGVRatio(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        mock.ExpectQuery(smqc.PGVRatioQuery(queryParams("pgvRatio")).SQL).
+                WithArgs(10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows(ratioRowColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", 12.5, 0.25, 0.02).
+                        AddRow("2024-01-02 03:00:00", "TFSS", "false", 1.25, 0.01, 0.0125).
+                        AddRow("2024-01-02 03:00:00", "SNZO", "false", nil, 0.01, 0))
+
+        // SNZO's zero horizontal maximum leaves it without a ratio.
+        result, err := pgvRatio(db, newCheckTrace("pgvRatio"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := mock.ExpectationsWereMet(); err != nil {
+                t.Error(err)
+        }
+
+        if result != (checkResult{rows: 2, concerns: 1}) {
+                t.Errorf("expected 2 rows and 1 concern, got %+v", result)
+        }
+
+        expected := `timestamp,station,blacklist,ratio,max_vertical,max_horizontal,schema_version
+2024-01-02T03:00:00Z,WEL,false,12.5000,0.2500,0.0200,1
+2024-01-02T03:00:00Z,TFSS,false,1.2500,0.0100,0.0125,1
+`
+        if got := files[filepath.Join(dir, "pgvRatio.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestDataGap(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        // WEL2's two source rows count as one station, the blacklisted station isn't expected
+        // to report.
+        mock.ExpectQuery(dataGapSQL).
+                WithArgs("", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows(countRowColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", 60, 60).
+                        AddRow("2024-01-02 03:00:00", "WEL2", "false", 30, 30).
+                        AddRow("2024-01-02 03:00:00", "WEL2", "false", 30, 30).
+                        AddRow("2024-01-02 03:00:00", "TFSS", "false", 5, 0).
+                        AddRow("2024-01-02 03:00:00", "SNZO", "false", 0, 0).
+                        AddRow("2024-01-02 03:00:00", "OLD", "true", 0, 0))
+
+        result, err := dataGap(db, newCheckTrace("dataGap"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := mock.ExpectationsWereMet(); err != nil {
+                t.Error(err)
+        }
+
+        if result != (checkResult{rows: 2, concerns: 2}) {
+                t.Errorf("expected 2 rows and 2 concerns, got %+v", result)
+        }
+
+        expected := `timestamp,station,blacklist,pga_count,pgv_count,status,schema_version
+2024-01-02T03:00:00Z,SNZO,false,0,0,silent,1
+2024-01-02T03:00:00Z,TFSS,false,5,0,low,1
+`
+        if got := files[filepath.Join(dir, "dataGap.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestSpike(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        mock.ExpectQuery(spikeSQL).
+                WithArgs("", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows(spikeRowColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", 5, 120).
+                        AddRow("2024-01-02 03:00:00", "TFSS", "false", 450, 30).
+                        AddRow("2024-01-02 03:00:00", "SNZO", "false", 0.5, 0.2).
+                        AddRow("2024-01-02 03:00:00", "OLD", "true", 300, 10))
+
+        result, err := spike(db, newCheckTrace("spike"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := mock.ExpectationsWereMet(); err != nil {
+                t.Error(err)
+        }
+
+        // SNZO is under -spike-floor, OLD is written but blacklisted.
+        if result != (checkResult{rows: 3, concerns: 2}) {
+                t.Errorf("expected 3 rows and 2 concerns, got %+v", result)
+        }
+
+        expected := `timestamp,station,blacklist,problem,max_pga,max_pgv,schema_version
+2024-01-02T03:00:00Z,TFSS,false,implausible-pga,450.0000,30.0000,1
+2024-01-02T03:00:00Z,OLD,true,implausible-pga,300.0000,10.0000,1
+2024-01-02T03:00:00Z,WEL,false,pga-pgv-mismatch,5.0000,120.0000,1
+`
+        if got := files[filepath.Join(dir, "spike.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestLatency(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        queryFrom = time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC)
+        queryTo = queryFrom.Add(time.Hour)
+        latencyThreshold = 15 * time.Minute
+        t.Cleanup(func() { queryFrom, queryTo, latencyThreshold = time.Time{}, time.Time{}, 0 })
+
+        // WEL's PGV has stopped, TFSS comes back from a dump as text.
+        mock.ExpectQuery(latencySQL).
+                WithArgs("", "", 1, "2024-01-02 03:00:00+00").
+                WillReturnRows(sqlmock.NewRows(latencyRowColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", queryTo.Add(-time.Minute), queryTo.Add(-time.Hour)).
+                        AddRow("2024-01-02 03:00:00", "TFSS", "false", "2024-01-02 02:55:00+00", nil))
+
+        result, err := latency(db, newCheckTrace("latency"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := mock.ExpectationsWereMet(); err != nil {
+                t.Error(err)
+        }
+
+        if result != (checkResult{rows: 2, concerns: 1}) {
+                t.Errorf("expected 2 rows and 1 concern, got %+v", result)
+        }
+
+        expected := `timestamp,station,blacklist,latest_pga,latest_pgv,latency_seconds,schema_version
+2024-01-02T03:00:00Z,WEL,false,2024-01-02T02:59:00Z,2024-01-02T02:00:00Z,3600.0000,1
+2024-01-02T03:00:00Z,TFSS,false,2024-01-02T02:55:00Z,,300.0000,1
+`
+        if got := files[filepath.Join(dir, "latency.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestSetupChecks(t *testing.T) {
+        ok := checkFunc{name: "ok", run: func(querier, *checkTrace) (checkResult, error) { return checkResult{rows: 1}, nil }}
+        broken := checkFunc{name: "broken", run: ok.run, setup: func() error { return errors.New("reading state") }}
+
+        checks := setupChecks([]check{broken, ok})
+
+        // The broken check fails when it's run, the other is left alone.
+        if _, err := checks[0].Run(nil, newCheckTrace("broken")); err == nil || err.Error() != "setup: reading state" {
+                t.Errorf("expected the setup error, got %v", err)
+        }
+        if checks[0].Name() != "broken" {
+                t.Errorf("expected the failed check to keep its name, got %s", checks[0].Name())
+        }
+        if result, err := checks[1].Run(nil, newCheckTrace("ok")); err != nil || result.rows != 1 {
+                t.Errorf("expected the other check to run, got %+v, %v", result, err)
+        }
+}
+
+func TestSendFailureAlert(t *testing.T) {
+        var payload checkFailurePayload
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+                        t.Error(err)
+                }
+        }))
+        defer server.Close()
+
+        alertWebhook = server.URL
+        t.Cleanup(func() { alertWebhook = "" })
+
+        sendFailureAlert([]checkFailure{{"spike", "query timed out"}})
+
+        if len(payload.Failures) != 1 || payload.Failures[0] != (checkFailure{"spike", "query timed out"}) {
+                t.Errorf("expected the spike failure, got %+v", payload)
+        }
+        if !strings.Contains(payload.Text, "spike (query timed out)") {
+                t.Errorf("expected the failure in the text, got %q", payload.Text)
+        }
+}
+
+func TestRunChecksSummary(t *testing.T) {
+        outputDir, summary, fail := dir, summaryPath, failFast
+        dir = t.TempDir()
+        summaryPath, failFast = filepath.Join(dir, "summary.json"), true
+        t.Cleanup(func() { dir, summaryPath, failFast = outputDir, summary, fail })
+
+        empty := func(querier, *checkTrace) (checkResult, error) { return checkResult{}, nil }
+        broken := func(querier, *checkTrace) (checkResult, error) { return checkResult{}, errors.New("no rows for you") }
+        checks := []check{
+                checkFunc{name: "empty", run: empty},
+                checkFunc{name: "emptyAfter", run: empty, after: true},
+                checkFunc{name: "brokenAfter", run: broken, after: true},
+                checkFunc{name: "later", run: empty, after: true},
+        }
+
+        if failed, _ := runChecks(nil, checks, nil, runStart); failed != 1 {
+                t.Errorf("expected 1 failed check, got %d", failed)
+        }
+
+        b, err := os.ReadFile(summaryPath)
+        if err != nil {
+                t.Fatal(err)
+        }
+        var s runSummary
+        if err := json.Unmarshal(b, &s); err != nil {
+                t.Fatal(err)
+        }
+
+        // A check that ran and found nothing is ok, even once a later one has failed, and with
+        // -fail-fast the after checks following the failure aren't run.
+        expected := map[string]string{"empty": "ok", "emptyAfter": "ok", "brokenAfter": "failed", "later": "not run"}
+        for _, c := range s.Checks {
+                if c.Status != expected[c.Check] {
+                        t.Errorf("%s: expected %s, got %s", c.Check, expected[c.Check], c.Status)
+                }
+        }
+        if len(s.Checks) != 4 {
+                t.Errorf("expected 4 checks, got %+v", s.Checks)
+        }
+}
+
+func TestRunChecksFailFast(t *testing.T) {
+        outputDir, fail := dir, failFast
+        dir, failFast = t.TempDir(), true
+        t.Cleanup(func() { dir, failFast = outputDir, fail })
+
+        // The slow check only finishes when -fail-fast cancels it.
+        slow := func(_ querier, tr *checkTrace) (checkResult, error) {
+                select {
+                case <-tr.ctx.Done():
+                        return checkResult{}, context.Cause(tr.ctx)
+                case <-time.After(10 * time.Second):
+                        return checkResult{rows: 1}, nil
+                }
+        }
+        checks := []check{
+                checkFunc{name: "broken", run: func(querier, *checkTrace) (checkResult, error) { return checkResult{}, errors.New("no rows for you") }},
+                checkFunc{name: "slow", run: slow},
+        }
+
+        start := time.Now()
+        if failed, _ := runChecks(nil, checks, nil, runStart); failed != 2 {
+                t.Errorf("expected both checks to fail, got %d", failed)
+        }
+        if elapsed := time.Since(start); elapsed > 5*time.Second {
+                t.Errorf("expected the slow check to be cancelled, the run took %s", elapsed)
+        }
+}
+
+func TestStationFilterWildcards(t *testing.T) {
+        files := captureOutput(t)
+
+        dump := t.TempDir()
+        for name, content := range map[string]string{
+                "source.csv": "sourcepk,station,network,blacklist\n1,W_L,NZ,f\n2,WXL,NZ,f\n",
+                "pga.csv": "sourcepk,pga,vertical,time\n",
+                "pgv.csv": "sourcepk,pgv,vertical,time\n",
+        } {
+                if err := os.WriteFile(filepath.Join(dump, name), []byte(content), 0o644); err != nil {
+                        t.Fatal(err)
+                }
+        }
+        db, err := openDump(dump)
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer db.Close()
+
+        includeStations = "WXL"
+        t.Cleanup(func() { includeStations = "" })
+
+        // W_L's _ isn't a wildcard matching WXL.
+        if _, err := dataGap(db, newCheckTrace("dataGap")); err != nil {
+                t.Fatal(err)
+        }
+        got := files[filepath.Join(dir, "dataGap.csv")].String()
+        if !strings.Contains(got, ",WXL,") || strings.Contains(got, "W_L") {
+                t.Errorf("expected only WXL, got\n%s", got)
+        }
+}
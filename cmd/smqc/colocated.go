@@ -0,0 +1,161 @@
+package main
+
+import (
+        "fmt"
+        "strconv"
+        "strings"
+)
+
+const colocatedSQL = `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        SUM(
+                (SELECT count(*) FROM impact.pga pga WHERE pga.sourcepk = loc.sourcepk
+                        AND (CAST($%[2]d AS INTEGER) = 0 OR (pga.time >= $%[3]d AND pga.time < $%[4]d))) +
+                (SELECT count(*) FROM impact.pgv pgv WHERE pgv.sourcepk = loc.sourcepk
+                        AND (CAST($%[2]d AS INTEGER) = 0 OR (pgv.time >= $%[3]d AND pgv.time < $%[4]d)))
+        ) AS noise_count
+FROM
+	impact.source loc
+WHERE
+	loc.station IN (%[1]s)
+GROUP BY
+	loc.station`
+
+var colocatedColumns = []column{
+        {"timestamp", textColumn},
+        {"station_a", textColumn},
+        {"count_a", intColumn},
+        {"station_b", textColumn},
+        {"count_b", intColumn},
+        {"ratio", floatColumn},
+        {"suspect", textColumn},
+}
+
+type colocatedPair struct {
+        a, b string
+}
+
+// parseColocated reads pairs of colocated stations in the form "WEL:WEL2,TFSS:TFSS2".
+func parseColocated(s string) ([]colocatedPair, error) {
+        var pairs []colocatedPair
+
+        for _, p := range strings.Split(s, ",") {
+                p = strings.TrimSpace(p)
+                if p == "" {
+                        continue
+                }
+
+                a, b, ok := strings.Cut(p, ":")
+                if !ok || a == "" || b == "" || a == b {
+                        return nil, fmt.Errorf("invalid colocated pair %q, expected STATION:STATION", p)
+                }
+                pairs = append(pairs, colocatedPair{a: a, b: b})
+        }
+
+        return pairs, nil
+}
+
+/*
+Compares the noise counts of colocated sensors. If one of a pair is much noisier than the
+other the noisy one is likely faulty, a site effect would show on both. Counts have one
+added before taking the ratio so a silent partner doesn't divide by zero.
+*/
+func colocatedNoise(db querier, tr *checkTrace) (checkResult, error) {
+        // A placeholder per station rather than = ANY($1) so the query also runs against
+        // a -dump-dir SQLite database.
+        var (
+                stations []interface{}
+                params []string
+        )
+        for _, p := range colocatedPairs {
+                for _, s := range []string{p.a, p.b} {
+                        stations = append(stations, s)
+                        params = append(params, "$" + strconv.Itoa(len(stations)))
+                }
+        }
+        n := len(stations)
+        query := fmt.Sprintf(colocatedSQL, strings.Join(params, ", "), n+1, n+2, n+3)
+        args := withWindow(stations...)
+
+        recordQueryStats(db, "colocatedNoise", query, args...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "colocatedNoise", query, args...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                count int
+        )
+
+        scan := &rowScanner{check: "colocatedNoise"}
+        defer scan.report()
+
+        counts := map[string]int{}
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &count)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                tr.row()
+                counts[station] = count
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        done := tr.writing()
+        defer done()
+
+        out := newCheckOutput("colocatedNoise", colocatedColumns...)
+        defer out.Close()
+
+        var concerns int
+
+        for _, p := range colocatedPairs {
+                ca, okA := counts[p.a]
+                cb, okB := counts[p.b]
+                if !okA || !okB {
+                        trace.Printf("colocated pair %s:%s not found in impact.source", p.a, p.b)
+                        continue
+                }
+
+                suspect, hi, lo := p.a, ca, cb
+                if cb > ca {
+                        suspect, hi, lo = p.b, cb, ca
+                }
+
+                ratio := float64(hi+1) / float64(lo+1)
+                if ratio <= colocatedRatio {
+                        continue
+                }
+
+                flagStation("colocatedNoise", suspect, "")
+                concerns++
+                overThreshold("colocatedNoise", suspect)
+
+                err := out.write(suspect, ratio, timestamp.String(), p.a, ca, p.b, cb, ratio, suspect)
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
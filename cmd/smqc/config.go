@@ -0,0 +1,171 @@
+package main
+
+import (
+        "bufio"
+        "flag"
+        "fmt"
+        "os"
+        "strconv"
+        "strings"
+)
+
+/*
+Settings from a -config file and the environment, so the tool can be pointed at a different
+hazard database or given different thresholds and output directory without changing the
+cron entry. The file is flat TOML, each key being a flag name without the dash:
+
+        # Test hazard database
+        db-host = "hazard-test.example.com"
+        noise-count-min = 20
+        limit = 25
+        output-dir = "/var/lib/smqc"
+        blacklist-flapping = true
+
+Any flag can also be set with SMQC_ and its name in capitals with underscores, e.g.
+SMQC_NOISE_COUNT_MIN=20. The command line wins over the environment which wins over the
+file, and the older HAZARD_DB_*, GRAFANA_TOKEN, INFLUX_TOKEN, PAGERDUTY_ROUTING_KEY and
+OPSGENIE_API_KEY variables count as environment.
+*/
+
+const envPrefix = "SMQC_"
+
+// legacyEnv are the variables that already gave a flag its default before there was a
+// config file, they still win over it. The tokens and keys are read here rather than being
+// the flags' defaults so -h doesn't print them.
+var legacyEnv = map[string]string{
+        "db-host": "HAZARD_DB_HOST",
+        "db-port": "HAZARD_DB_PORT",
+        "db-name": "HAZARD_DB_NAME",
+        "db-user": "HAZARD_DB_USER",
+        "grafana-token": "GRAFANA_TOKEN",
+        "influx-token": "INFLUX_TOKEN",
+        "pagerduty-routing-key": "PAGERDUTY_ROUTING_KEY",
+        "opsgenie-api-key": "OPSGENIE_API_KEY",
+}
+
+func envName(flagName string) string {
+        return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applySettings sets flags that weren't given on the command line from the environment and
+// then the -config file.
+func applySettings() error {
+        set := map[string]bool{}
+        flag.Visit(func(f *flag.Flag) {
+                set[f.Name] = true
+        })
+
+        var err error
+        flag.VisitAll(func(f *flag.Flag) {
+                if set[f.Name] || err != nil {
+                        return
+                }
+
+                if v, ok := os.LookupEnv(envName(f.Name)); ok {
+                        if err = f.Value.Set(v); err != nil {
+                                err = fmt.Errorf("invalid %s %q: %w", envName(f.Name), v, err)
+                        }
+                        set[f.Name] = true
+                        return
+                }
+                if key, ok := legacyEnv[f.Name]; ok {
+                        if v, ok := os.LookupEnv(key); ok {
+                                if err = f.Value.Set(v); err != nil {
+                                        err = fmt.Errorf("invalid %s %q: %w", key, v, err)
+                                }
+                                set[f.Name] = true
+                        }
+                }
+        })
+        if err != nil || configFile == "" {
+                return err
+        }
+
+        settings, err := readConfig(configFile)
+        if err != nil {
+                return fmt.Errorf("reading %s: %w", configFile, err)
+        }
+
+        for _, s := range settings {
+                f := flag.Lookup(s.key)
+                if f == nil || f.Name == "config" {
+                        return fmt.Errorf("%s line %d: unknown setting %q", configFile, s.line, s.key)
+                }
+                if set[s.key] {
+                        continue
+                }
+                if err := f.Value.Set(s.value); err != nil {
+                        return fmt.Errorf("%s line %d: invalid %s %q: %w", configFile, s.line, s.key, s.value, err)
+                }
+        }
+
+        return nil
+}
+
+type setting struct {
+        line int
+        key string
+        value string
+}
+
+// readConfig reads the key = value lines of a config file. String values are quoted as in
+// TOML, numbers, booleans and durations can be given bare or quoted.
+func readConfig(path string) ([]setting, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        var settings []setting
+
+        scanner := bufio.NewScanner(f)
+        for line := 1; scanner.Scan(); line++ {
+                text := strings.TrimSpace(scanner.Text())
+                if text == "" || strings.HasPrefix(text, "#") {
+                        continue
+                }
+                if strings.HasPrefix(text, "[") {
+                        return nil, fmt.Errorf("line %d: tables aren't supported, every setting is at the top level", line)
+                }
+
+                key, value, ok := strings.Cut(text, "=")
+                if !ok {
+                        return nil, fmt.Errorf("line %d: expected key = value", line)
+                }
+                key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+                if strings.HasPrefix(value, `"`) {
+                        // A comment can follow the closing quote.
+                        end := closingQuote(value)
+                        if end < 0 {
+                                return nil, fmt.Errorf("line %d: unterminated string", line)
+                        }
+                        if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+                                return nil, fmt.Errorf("line %d: unexpected %q after string", line, rest)
+                        }
+                        if value, err = strconv.Unquote(value[:end+1]); err != nil {
+                                return nil, fmt.Errorf("line %d: invalid string: %w", line, err)
+                        }
+                } else if i := strings.Index(value, "#"); i >= 0 {
+                        value = strings.TrimSpace(value[:i])
+                }
+
+                settings = append(settings, setting{line, key, value})
+        }
+
+        return settings, scanner.Err()
+}
+
+// closingQuote gives the index of the quote ending the string s starts with, or -1.
+func closingQuote(s string) int {
+        for i := 1; i < len(s); i++ {
+                switch s[i] {
+                case '\\':
+                        i++
+                case '"':
+                        return i
+                }
+        }
+        return -1
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+        "flag"
+        "os"
+        "path/filepath"
+        "reflect"
+        "testing"
+)
+
+func TestApplySettingsSecretEnv(t *testing.T) {
+        t.Setenv("INFLUX_TOKEN", "secret-token")
+        t.Cleanup(func() { influxToken = "" })
+
+        // The token isn't the flag's default, so -h doesn't print it.
+        if def := flag.Lookup("influx-token").DefValue; def != "" {
+                t.Errorf("expected no default for -influx-token, got %q", def)
+        }
+
+        if err := applySettings(); err != nil {
+                t.Fatal(err)
+        }
+        if influxToken != "secret-token" {
+                t.Errorf("expected -influx-token from INFLUX_TOKEN, got %q", influxToken)
+        }
+}
+
+func TestReadConfig(t *testing.T) {
+        tests := []struct {
+                name string
+                config string
+                expected []setting
+                err string
+        }{
+                {"settings", "# hazard database\ndb-host = \"hazard.example.com\"\n\ndb-port = 5432 # the default\nwindow = \"1h\"\n", []setting{{2, "db-host", "hazard.example.com"}, {4, "db-port", "5432"}, {5, "window", "1h"}}, ""},
+                {"quoted", `exclude-stations = "WEL,\"TFSS\"" # comment`, []setting{{1, "exclude-stations", `WEL,"TFSS"`}}, ""},
+                {"hash in string", `output-dir = "/data/#1"`, []setting{{1, "output-dir", "/data/#1"}}, ""},
+                {"table", "[database]\nhost = \"x\"", nil, "line 1: tables aren't supported, every setting is at the top level"},
+                {"no value", "window\n", nil, "line 1: expected key = value"},
+                {"unterminated", `db-host = "hazard`, nil, "line 1: unterminated string"},
+                {"after string", `db-host = "hazard" extra`, nil, `line 1: unexpected "extra" after string`},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        path := filepath.Join(t.TempDir(), "smqc.toml")
+                        if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+                                t.Fatal(err)
+                        }
+
+                        settings, err := readConfig(path)
+                        if tt.err != "" {
+                                if err == nil || err.Error() != tt.err {
+                                        t.Errorf("expected %q, got %v", tt.err, err)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if !reflect.DeepEqual(settings, tt.expected) {
+                                t.Errorf("expected %+v, got %+v", tt.expected, settings)
+                        }
+                })
+        }
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+        "database/sql"
+        "math/rand"
+        "os"
+        "os/signal"
+        "syscall"
+        "time"
+)
+
+/*
+Daemon mode for running as a service instead of from cron. With -interval the checks are
+run straight away and then again every interval, sharing the one connection pool. The run
+registry, delta and incident state are all in files so they carry on between runs the
+same as they do between cron runs.
+
+-daemon is the same with -interval defaulting to an hour. With -jitter each run waits a
+random time up to that long first so hosts started together don't all query the hazard
+database at the same moment.
+
+SIGINT or SIGTERM stops the process once the run in progress, if any, has finished writing
+its files. A second one cancels the run's queries, the checks fail and it stops straight
+away.
+*/
+
+func daemon(db *sql.DB, checks []check) {
+        stop := stopOnSignal()
+
+        trace.Printf("Running checks every %s", interval)
+
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+                if jitter > 0 {
+                        select {
+                        case <-stop:
+                                return
+                        case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+                        }
+                }
+
+                if failed, _ := runAll(db, checks, time.Now()); failed > 0 {
+                        trace.Printf("ERROR: %d of %d checks failed", failed, len(checks))
+                }
+
+                // A signal received during the run wins over a tick that's also waiting.
+                select {
+                case <-stop:
+                        return
+                default:
+                }
+
+                select {
+                case <-stop:
+                        return
+                case <-ticker.C:
+                }
+        }
+}
+
+// stopOnSignal closes the channel on the first SIGINT or SIGTERM and cancels runCtx on a
+// second, for when the run in progress is taking too long to wait for.
+func stopOnSignal() <-chan struct{} {
+        sigs := make(chan os.Signal, 2)
+        signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+        stop := make(chan struct{})
+        go func() {
+                s := <-sigs
+                trace.Printf("Stopping on %s", s)
+                close(stop)
+
+                s = <-sigs
+                trace.Printf("Cancelling the checks on %s", s)
+                cancelRun()
+        }()
+
+        return stop
+}
+
+// runOnce is one scheduled, or backfilled, run for at, the per run state is reset and the
+// checks set up first, so a check whose setup failed last run gets another go.
+func runOnce(db *sql.DB, checks []check, at time.Time) (int, int) {
+        runStart = at.UTC()
+        newRunID()
+        resetFindings()
+        setWindow(runStart)
+
+        window := runWindow(runStart)
+
+        var windows []time.Time
+        if duplicateRun != "off" {
+                var err error
+                windows, err = readRegistry()
+                if err != nil {
+                        trace.Printf("ERROR: reading run registry: %s", err)
+                        return len(checks), 0
+                }
+
+                if skipWindow(windows, window) {
+                        return 0, 0
+                }
+        }
+
+        return runChecks(db, setupChecks(checks), windows, window)
+}
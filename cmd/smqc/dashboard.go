@@ -0,0 +1,464 @@
+package main
+
+import (
+        "bufio"
+        "bytes"
+        "encoding/csv"
+        "encoding/json"
+        "flag"
+        "fmt"
+        "html/template"
+        "io"
+        "net/http"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+A dashboard of the check history for the duty officer, instead of pulling the CSVs off the
+host to chart them.
+
+        smqc dashboard [-window 168h] [-stations 20] [-out dashboard.html]
+
+writes a self contained HTML page to the output directory with a table of the stations
+ranked by their noise count and PGA ratio in the latest hour of history, then a chart of
+each of the top -stations' hourly noise count and ratio over the window. With
+-metrics-addr the same page is served at /dashboard, built from the history on each
+request, with ?window=168h&stations=20 to change them and with -sources ?source= for a
+source other than the first.
+
+The noise count charted is the station's highest component's for the hour. The page has no
+scripts or external resources so it can be mailed or opened offline.
+*/
+
+const (
+        dashboardFile = "dashboard.html"
+        dashboardWindow = 7 * 24 * time.Hour
+        dashboardStations = 20
+)
+
+func dashboardCommand(args []string) error {
+        fs := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+        window := fs.Duration("window", dashboardWindow, "how far back to chart the check history")
+        stations := fs.Int("stations", dashboardStations, "how many of the worst stations to chart")
+        out := fs.String("out", dashboardFile, "file to write the page to, relative to the output directory")
+        if err := fs.Parse(args); err != nil {
+                return err
+        }
+        if *window <= 0 || *stations <= 0 {
+                return fmt.Errorf("-window and -stations must be positive")
+        }
+
+        var b bytes.Buffer
+        if err := renderDashboard(&b, dir, time.Now().UTC(), *window, *stations); err != nil {
+                return err
+        }
+
+        path := *out
+        if !filepath.IsAbs(path) {
+                path = filepath.Join(dir, path)
+        }
+
+        tmp := path + ".tmp"
+        if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+                return err
+        }
+        if err := os.Rename(tmp, path); err != nil {
+                return err
+        }
+
+        trace.Printf("Wrote dashboard to %s", path)
+        return nil
+}
+
+// dashboardDirs are the history directories the served dashboard reads, by source name, ""
+// without -sources. They're fixed before the server starts as runSources changes dir for
+// each source while a request may be reading the history.
+var dashboardDirs map[string]string
+
+// serveDashboard renders the page for each request on the -metrics-addr server, of the
+// first source or ?source=.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+        window, stations := dashboardWindow, dashboardStations
+
+        source := r.URL.Query().Get("source")
+        if source == "" && len(sources) > 0 {
+                source = sources[0].Name
+        }
+        outputDir, ok := dashboardDirs[source]
+        if !ok {
+                http.Error(w, "unknown source", http.StatusNotFound)
+                return
+        }
+
+        if s := r.URL.Query().Get("window"); s != "" {
+                d, err := time.ParseDuration(s)
+                if err != nil || d <= 0 {
+                        http.Error(w, "invalid window", http.StatusBadRequest)
+                        return
+                }
+                window = d
+        }
+        if s := r.URL.Query().Get("stations"); s != "" {
+                n, err := strconv.Atoi(s)
+                if err != nil || n <= 0 {
+                        http.Error(w, "invalid stations", http.StatusBadRequest)
+                        return
+                }
+                stations = n
+        }
+
+        // Rendered to a buffer first so an error reading the history is a 500 rather
+        // than half a page.
+        var b bytes.Buffer
+        if err := renderDashboard(&b, outputDir, time.Now().UTC(), window, stations); err != nil {
+                trace.Printf("ERROR: rendering dashboard: %s", err)
+                http.Error(w, "error reading the check history", http.StatusInternalServerError)
+                return
+        }
+
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write(b.Bytes())
+}
+
+// historyValue is a row of a check's history with one of its numeric columns.
+type historyValue struct {
+        at time.Time
+        station string
+        blacklist string
+        value float64
+}
+
+// readHistoryValues reads a check's history in outputDir since from like readHistory, with
+// the value of column from each row. Rows without it, e.g. a file from before it was added,
+// are skipped.
+func readHistoryValues(outputDir, check, column string, from time.Time) ([]historyValue, error) {
+        var values []historyValue
+
+        err := readHistoryFiles(outputDir, check, func(f io.Reader, jsonl bool) error {
+                var (
+                        v []historyValue
+                        err error
+                )
+                if jsonl {
+                        v, err = readHistoryValuesJSON(f, column, from)
+                } else {
+                        v, err = readHistoryValuesCSV(f, column, from)
+                }
+                values = append(values, v...)
+                return err
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        return values, nil
+}
+
+func readHistoryValuesCSV(r io.Reader, column string, from time.Time) ([]historyValue, error) {
+        var values []historyValue
+
+        cr := csv.NewReader(r)
+        cr.FieldsPerRecord = -1
+
+        // The column is found by the file's header, a file only ever has the one.
+        index := -1
+        for {
+                rec, err := cr.Read()
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        return nil, err
+                }
+                if len(rec) < 3 {
+                        continue
+                }
+
+                at, err := parseHistoryTime(rec[0])
+                if err != nil {
+                        for i, name := range rec {
+                                if name == column {
+                                        index = i
+                                }
+                        }
+                        continue
+                }
+                if at.Before(from) || index < 0 || index >= len(rec) {
+                        continue
+                }
+
+                value, err := strconv.ParseFloat(rec[index], 64)
+                if err != nil {
+                        continue
+                }
+
+                values = append(values, historyValue{at: at, station: rec[1], blacklist: rec[2], value: value})
+        }
+
+        return values, nil
+}
+
+func readHistoryValuesJSON(r io.Reader, column string, from time.Time) ([]historyValue, error) {
+        var values []historyValue
+
+        scanner := bufio.NewScanner(r)
+        for scanner.Scan() {
+                var row map[string]interface{}
+                if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+                        return nil, err
+                }
+
+                timestamp, _ := row["timestamp"].(string)
+                at, err := parseHistoryTime(timestamp)
+                if err != nil || at.Before(from) {
+                        continue
+                }
+
+                value, ok := row[column].(float64)
+                if !ok {
+                        continue
+                }
+
+                station, _ := row["station"].(string)
+                blacklist, _ := row["blacklist"].(string)
+                values = append(values, historyValue{at: at, station: station, blacklist: blacklist, value: value})
+        }
+
+        return values, scanner.Err()
+}
+
+type dashboardStation struct {
+        Station string
+        Blacklist string
+        NoiseCount float64
+        Ratio float64
+        FlaggedHours int
+        NoiseChart template.HTML
+        RatioChart template.HTML
+
+        noise map[time.Time]float64
+        ratio map[time.Time]float64
+}
+
+type dashboardPage struct {
+        Generated string
+        From string
+        Latest string
+        Window string
+        Stations []*dashboardStation
+        Charted []*dashboardStation
+}
+
+// renderDashboard writes the page for the history in outputDir from now-window to now.
+func renderDashboard(w io.Writer, outputDir string, now time.Time, window time.Duration, charted int) error {
+        from := now.Add(-window)
+
+        noise, err := readHistoryValues(outputDir, "noiseCount", "noise_count", from)
+        if err != nil {
+                return err
+        }
+        ratio, err := readHistoryValues(outputDir, "ratioDiff", "ratio", from)
+        if err != nil {
+                return err
+        }
+
+        byStation := map[string]*dashboardStation{}
+        get := func(v historyValue) *dashboardStation {
+                s := byStation[v.station]
+                if s == nil {
+                        s = &dashboardStation{
+                                Station: v.station,
+                                noise: map[time.Time]float64{},
+                                ratio: map[time.Time]float64{},
+                        }
+                        byStation[v.station] = s
+                }
+                return s
+        }
+
+        var latest time.Time
+        for _, v := range noise {
+                hour := v.at.UTC().Truncate(time.Hour)
+                s := get(v)
+                if v.value > s.noise[hour] {
+                        s.noise[hour] = v.value
+                }
+                if hour.After(latest) {
+                        latest = hour
+                }
+        }
+        for _, v := range ratio {
+                hour := v.at.UTC().Truncate(time.Hour)
+                s := get(v)
+                if v.value > s.ratio[hour] {
+                        s.ratio[hour] = v.value
+                }
+                if hour.After(latest) {
+                        latest = hour
+                }
+        }
+
+        // The blacklist state is the one in the latest row seen for the station.
+        blacklistAt := map[string]time.Time{}
+        for _, rows := range [][]historyValue{noise, ratio} {
+                for _, v := range rows {
+                        if !v.at.Before(blacklistAt[v.station]) {
+                                blacklistAt[v.station] = v.at
+                                byStation[v.station].Blacklist = v.blacklist
+                        }
+                }
+        }
+
+        page := dashboardPage{
+                Generated: now.Format(time.RFC3339),
+                From: from.Format(time.RFC3339),
+                Window: window.String(),
+        }
+        if !latest.IsZero() {
+                page.Latest = latest.Format(time.RFC3339)
+        }
+
+        for _, s := range byStation {
+                s.NoiseCount = s.noise[latest]
+                s.Ratio = s.ratio[latest]
+
+                hours := map[time.Time]bool{}
+                for h := range s.noise {
+                        hours[h] = true
+                }
+                for h := range s.ratio {
+                        hours[h] = true
+                }
+                s.FlaggedHours = len(hours)
+
+                page.Stations = append(page.Stations, s)
+        }
+
+        // Stations in the latest hour first, by noise count then ratio, then the rest by
+        // how often they turned up.
+        sort.Slice(page.Stations, func(i, j int) bool {
+                a, b := page.Stations[i], page.Stations[j]
+                if a.NoiseCount != b.NoiseCount {
+                        return a.NoiseCount > b.NoiseCount
+                }
+                if a.Ratio != b.Ratio {
+                        return a.Ratio > b.Ratio
+                }
+                if a.FlaggedHours != b.FlaggedHours {
+                        return a.FlaggedHours > b.FlaggedHours
+                }
+                return a.Station < b.Station
+        })
+
+        page.Charted = page.Stations
+        if len(page.Charted) > charted {
+                page.Charted = page.Charted[:charted]
+        }
+        for _, s := range page.Charted {
+                s.NoiseChart = svgChart(s.noise, from, now, "#c0392b")
+                s.RatioChart = svgChart(s.ratio, from, now, "#2471a3")
+        }
+
+        return dashboardTemplate.Execute(w, page)
+}
+
+// svgChart draws an hourly series from from to to as an inline SVG, with a point where
+// the station was in the check's output and nothing where it wasn't.
+func svgChart(series map[time.Time]float64, from, to time.Time, colour string) template.HTML {
+        const width, height, pad = 600.0, 120.0, 4.0
+
+        var max float64
+        for _, v := range series {
+                if v > max {
+                        max = v
+                }
+        }
+        if max == 0 {
+                max = 1
+        }
+
+        span := to.Sub(from).Seconds()
+        hours := make([]time.Time, 0, len(series))
+        for h := range series {
+                hours = append(hours, h)
+        }
+        sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+
+        var b strings.Builder
+        fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f">`, width, height, width, height)
+        fmt.Fprintf(&b, `<rect width="%.0f" height="%.0f" fill="#fafafa" stroke="#ddd"/>`, width, height)
+        fmt.Fprintf(&b, `<text x="%.0f" y="12" font-size="10" fill="#666">%s</text>`, pad, formatFloat(max))
+
+        // Consecutive hours are joined, a gap starts a new line.
+        var line []string
+        flush := func() {
+                if len(line) > 1 {
+                        fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, colour, strings.Join(line, " "))
+                }
+                line = line[:0]
+        }
+        for i, h := range hours {
+                if i > 0 && h.Sub(hours[i-1]) > time.Hour {
+                        flush()
+                }
+                x := pad + (width-2*pad)*h.Sub(from).Seconds()/span
+                y := height - pad - (height-2*pad-12)*series[h]/max
+                line = append(line, fmt.Sprintf("%.1f,%.1f", x, y))
+                fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="2" fill="%s"><title>%s %s</title></circle>`, x, y, colour, h.Format(time.RFC3339), formatFloat(series[h]))
+        }
+        flush()
+
+        b.WriteString(`</svg>`)
+
+        // The values are all numbers and times written here.
+        return template.HTML(b.String())
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Strong motion noise checks</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 2px 10px; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.blacklisted { color: #999; }
+.station { margin-bottom: 1.5em; }
+.station h3 { margin: 0.2em 0; }
+.charts { display: flex; gap: 1em; flex-wrap: wrap; }
+.charts div { font-size: 0.8em; color: #666; }
+</style>
+</head>
+<body>
+<h1>Strong motion noise checks</h1>
+<p>History from {{.From}} to {{.Generated}} ({{.Window}}){{if .Latest}}, latest hour {{.Latest}}{{end}}.</p>
+
+<h2>Worst performers</h2>
+{{if .Stations}}
+<table>
+<tr><th>Station</th><th>Blacklist</th><th>Noise count</th><th>PGA ratio</th><th>Hours flagged</th></tr>
+{{range .Stations}}<tr{{if eq .Blacklist "true"}} class="blacklisted"{{end}}><td><a href="#{{.Station}}">{{.Station}}</a></td><td>{{.Blacklist}}</td><td>{{.NoiseCount}}</td><td>{{printf "%.1f" .Ratio}}</td><td>{{.FlaggedHours}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No stations in the check history for this window.</p>
+{{end}}
+
+{{if .Charted}}<h2>Trends</h2>{{end}}
+{{range .Charted}}<div class="station" id="{{.Station}}">
+<h3>{{.Station}}{{if eq .Blacklist "true"}} (blacklisted){{end}}</h3>
+<div class="charts">
+<div>Noise count<br>{{.NoiseChart}}</div>
+<div>PGA ratio<br>{{.RatioChart}}</div>
+</div>
+</div>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,182 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+
+        "github.com/mabznz/smqc/smqc"
+)
+
+/*
+A broken station most often goes quiet rather than noisy, and the noise counts only show
+the noisy ones. This compares every non blacklisted station in impact.source with the PGA
+and PGV values it contributed in the window and writes those that are silent, no values at
+all, or low, fewer than -data-gap-fraction of the median station's, to dataGap.csv as
+
+        timestamp,station,blacklist,pga_count,pgv_count,status
+
+quietest first. The median is over the stations being checked so a network wide outage
+shows every station as low rather than none of them.
+*/
+const dataGapSQL = `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        COALESCE(pga.value_count, 0) AS pga_count,
+        COALESCE(pgv.value_count, 0) AS pgv_count
+FROM
+	impact.source loc
+	LEFT OUTER JOIN (
+		SELECT pga.sourcepk, count(*) AS value_count
+		FROM impact.pga pga
+		WHERE CAST($3 AS INTEGER) = 0 OR (pga.time >= $4 AND pga.time < $5)
+		GROUP BY pga.sourcepk
+	) pga ON pga.sourcepk = loc.sourcepk
+	LEFT OUTER JOIN (
+		SELECT pgv.sourcepk, count(*) AS value_count
+		FROM impact.pgv pgv
+		WHERE CAST($3 AS INTEGER) = 0 OR (pgv.time >= $4 AND pgv.time < $5)
+		GROUP BY pgv.sourcepk
+	) pgv ON pgv.sourcepk = loc.sourcepk
+WHERE
+	(CAST($1 AS TEXT) = '' OR ',' || CAST($1 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `)
+	AND NOT ',' || CAST($2 AS TEXT) || ',' LIKE ` + smqc.StationPattern
+
+var dataGapColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"pga_count", intColumn},
+        {"pgv_count", intColumn},
+        {"status", textColumn},
+}
+
+type dataGapRow struct {
+        timestamp string
+        station string
+        blacklist string
+        pga int
+        pgv int
+}
+
+func dataGap(db querier, tr *checkTrace) (checkResult, error) {
+        recordQueryStats(db, "dataGap", dataGapSQL, withWindow(includeStations, excludeFor("dataGap"))...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "dataGap", dataGapSQL, withWindow(includeStations, excludeFor("dataGap"))...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                blacklist string
+                pgaCount int
+                pgvCount int
+        )
+
+        scan := &rowScanner{check: "dataGap"}
+        defer scan.report()
+
+        // A station with more than one source row has its values added up.
+        byStation := map[string]*dataGapRow{}
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &blacklist, &pgaCount, &pgvCount)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                blacklist = overrideBlacklist(station, blacklist)
+                tr.row()
+
+                // Blacklisted stations aren't expected to report.
+                if blacklist == "true" {
+                        continue
+                }
+
+                r := byStation[station]
+                if r == nil {
+                        r = &dataGapRow{timestamp: timestamp.String(), station: station, blacklist: blacklist}
+                        byStation[station] = r
+                }
+                r.pga += pgaCount
+                r.pgv += pgvCount
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        stations := make([]*dataGapRow, 0, len(byStation))
+        totals := make([]int, 0, len(byStation))
+        for _, r := range byStation {
+                stations = append(stations, r)
+                totals = append(totals, r.pga + r.pgv)
+        }
+        sort.Ints(totals)
+
+        var median float64
+        if n := len(totals); n > 0 {
+                median = float64(totals[n/2])
+                if n%2 == 0 {
+                        median = float64(totals[n/2-1] + totals[n/2]) / 2
+                }
+        }
+
+        sort.Slice(stations, func(i, j int) bool {
+                a, b := stations[i].pga + stations[i].pgv, stations[j].pga + stations[j].pgv
+                if a != b {
+                        return a < b
+                }
+                return stations[i].station < stations[j].station
+        })
+
+        out := newCheckOutput("dataGap", dataGapColumns...)
+        defer out.Close()
+
+        done := tr.writing()
+        defer done()
+
+        var concerns int
+        for _, r := range stations {
+                total := float64(r.pga + r.pgv)
+
+                status := "low"
+                switch {
+                case total == 0:
+                        status = "silent"
+                case total >= dataGapFraction * median:
+                        continue
+                }
+                if concerns == limit {
+                        break
+                }
+                concerns++
+                overThreshold("dataGap", r.station)
+                flagStation("dataGap", r.station, r.blacklist)
+
+                // The value for -sort and -health-score is how far short of the median it is.
+                shortfall := 1.0
+                if median > 0 {
+                        shortfall = 1 - total / median
+                }
+
+                if err := out.write(r.station, shortfall, r.timestamp, r.station, r.blacklist, r.pga, r.pgv, status); err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+        "bufio"
+        "encoding/csv"
+        "encoding/json"
+        "io"
+        "os"
+        "strings"
+)
+
+/*
+Row level deduplication for runs that happen more than once in the same hour.
+
+-duplicate-run works on the whole run, with -dedup each row is checked instead. Before the
+first append to a file this run, the rows already in it for the current hour are read and
+a row is skipped if one with the same text fields (station, component, blacklist and so on,
+everything but the timestamp and the values) is already there. That makes any number of
+runs within the hour append the same rows as one, including a re-run after a check failed
+part way.
+
+Only files whose first column is the timestamp are deduplicated, noiseCountDelta.csv is
+left alone as its state makes a second run's deltas correct as they are.
+*/
+
+// dedupKey is the hour and text fields of a row, or "" if the row isn't timestamped.
+func dedupKey(columns []column, fields []string) string {
+        if len(columns) == 0 || columns[0].name != "timestamp" || len(fields) != len(columns) {
+                return ""
+        }
+
+        at, err := parseHistoryTime(fields[0])
+        if err != nil {
+                return ""
+        }
+
+        key := []string{runWindow(at).Format("2006-01-02T15")}
+        for i, c := range columns[1:] {
+                if c.kind == textColumn {
+                        key = append(key, fields[i+1])
+                }
+        }
+
+        return strings.Join(key, "\x00")
+}
+
+// readDedupKeys reads the keys of the rows already in path for the current hour.
+func readDedupKeys(path string, columns []column) (map[string]bool, error) {
+        keys := map[string]bool{}
+        hour := runWindow(runStart).Format("2006-01-02T15")
+
+        add := func(fields []string) {
+                if k := dedupKey(columns, fields); strings.HasPrefix(k, hour + "\x00") {
+                        keys[k] = true
+                }
+        }
+
+        f, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        if strings.HasSuffix(path, ".jsonl") {
+                scanner := bufio.NewScanner(f)
+                for scanner.Scan() {
+                        var row map[string]interface{}
+                        if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+                                continue
+                        }
+
+                        fields := make([]string, len(columns))
+                        for i, c := range columns {
+                                fields[i], _ = row[c.name].(string)
+                        }
+                        add(fields)
+                }
+                return keys, scanner.Err()
+        }
+
+        r := csv.NewReader(f)
+        r.FieldsPerRecord = -1
+        for {
+                rec, err := r.Read()
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        return nil, err
+                }
+                add(rec)
+        }
+
+        return keys, nil
+}
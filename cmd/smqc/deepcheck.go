@@ -0,0 +1,170 @@
+package main
+
+import (
+        "fmt"
+        "io"
+        "math"
+        "net/http"
+        "net/url"
+        "sort"
+        "time"
+)
+
+/*
+The hour summaries in the hazard tables hide a lot, with -deep-check the -deep-check
+stations with the highest health score this run, as -health-score would rank them, have
+-deep-check-window of waveform data up to the end of the window fetched as miniSEED from the
+FDSN dataselect service at -dataselect-url. Each of their -fdsn-channels gets a row in
+deepCheck.csv as
+
+        timestamp,station,channel,samples,sample_rate,offset,rms
+
+where channel is NET.STA.LOC.CHA, offset is the mean and rms the root mean square about it,
+both in raw counts as there's no instrument response to hand. A dead channel has an rms
+near zero, a noisy one stands out against its neighbours, and a large offset is a sensor
+that's drifted. Fetching is best effort, a station the service has nothing for or can't
+be reached for is logged and left out.
+*/
+
+var deepCheckColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"channel", textColumn},
+        {"samples", intColumn},
+        {"sample_rate", floatColumn},
+        {"offset", floatColumn},
+        {"rms", floatColumn},
+}
+
+// fetchWaveforms is the miniSEED for station's -fdsn-channels between start and end, nil if
+// the service has none.
+func fetchWaveforms(station string, start, end time.Time) ([]byte, error) {
+        q := url.Values{}
+        q.Set("station", station)
+        q.Set("channel", fdsnChannels)
+        q.Set("starttime", start.UTC().Format("2006-01-02T15:04:05"))
+        q.Set("endtime", end.UTC().Format("2006-01-02T15:04:05"))
+
+        client := &http.Client{Timeout: 30 * time.Second}
+
+        res, err := client.Get(dataselectURL + "?" + q.Encode())
+        if err != nil {
+                return nil, err
+        }
+        defer res.Body.Close()
+
+        // No data is 204, or 404 from some services.
+        if res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotFound {
+                return nil, nil
+        }
+        if res.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("dataselect service returned %s", res.Status)
+        }
+
+        return io.ReadAll(res.Body)
+}
+
+// channelNoise is a channel's samples over the fetched window.
+type channelNoise struct {
+        id string
+        sampleRate float64
+        samples []float64
+}
+
+// offsetRMS is the mean of the samples and the root mean square about it.
+func (c channelNoise) offsetRMS() (float64, float64) {
+        var sum float64
+        for _, v := range c.samples {
+                sum += v
+        }
+        mean := sum / float64(len(c.samples))
+
+        var squares float64
+        for _, v := range c.samples {
+                squares += (v - mean) * (v - mean)
+        }
+
+        return mean, math.Sqrt(squares / float64(len(c.samples)))
+}
+
+// stationNoise fetches station's waveforms and gives its channels in order.
+func stationNoise(station string, start, end time.Time) ([]channelNoise, error) {
+        data, err := fetchWaveforms(station, start, end)
+        if err != nil || data == nil {
+                return nil, err
+        }
+
+        records, err := readMiniSEED(data)
+        if err != nil {
+                return nil, fmt.Errorf("reading miniSEED: %w", err)
+        }
+
+        byChannel := map[string]*channelNoise{}
+        for _, r := range records {
+                c := byChannel[r.id()]
+                if c == nil {
+                        c = &channelNoise{id: r.id(), sampleRate: r.sampleRate}
+                        byChannel[r.id()] = c
+                }
+                c.samples = append(c.samples, r.samples...)
+        }
+
+        var channels []channelNoise
+        for _, c := range byChannel {
+                if len(c.samples) > 0 {
+                        channels = append(channels, *c)
+                }
+        }
+        sort.Slice(channels, func(i, j int) bool { return channels[i].id < channels[j].id })
+
+        return channels, nil
+}
+
+func deepCheck(db querier, tr *checkTrace) (checkResult, error) {
+        scores := stationScores()
+        if len(scores) > deepCheckStations {
+                scores = scores[:deepCheckStations]
+        }
+
+        end := runStart
+        if !queryTo.IsZero() {
+                end = queryTo
+        }
+        start := end.Add(-deepCheckWindow)
+
+        done := tr.writing()
+        defer done()
+
+        out := newCheckOutput("deepCheck", deepCheckColumns...)
+        defer out.Close()
+
+        timestamp := runStart.Format(time.RFC3339)
+
+        for _, s := range scores {
+                channels, err := stationNoise(s.station, start, end)
+                if err != nil {
+                        trace.Printf("WARNING: deepCheck: fetching waveforms for %s from -dataselect-url: %s", s.station, err)
+                        continue
+                }
+                if len(channels) == 0 {
+                        trace.Printf("deepCheck: no waveform data for %s from -dataselect-url", s.station)
+                        continue
+                }
+
+                for _, c := range channels {
+                        tr.row()
+                        offset, rms := c.offsetRMS()
+
+                        err := out.write(s.station, rms, timestamp, s.station, c.id, len(c.samples), c.sampleRate, offset, rms)
+                        if err != nil {
+                                return checkResult{}, fmt.Errorf("writing file: %w", err)
+                        }
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count}, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "os"
+        "path/filepath"
+        "strings"
+        "time"
+)
+
+/*
+Delta encoding of the noise counts for bandwidth constrained consumers.
+
+Rather than the absolute count for every station each run, only the change since the
+previous run is written. Every deltaSnapshotEvery runs a full snapshot is written
+instead so consumers can resync. The absolute value for a station is the value from the
+last snapshot plus every delta after it. A station that drops out of the result is
+written as a delta back to zero.
+
+Records are written to noiseCountDelta.csv as
+        kind,timestamp,station,blacklist,component,value
+where kind is either snapshot or delta.
+*/
+
+var deltaColumns = []column{
+        {"kind", textColumn},
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"component", textColumn},
+        {"value", intColumn},
+}
+
+// deltaState is what's remembered between runs, kept in noiseCountDelta.json.
+type deltaState struct {
+        Run    int            `json:"run"`
+        Counts map[string]int `json:"counts"`
+}
+
+type noiseRow struct {
+        timestamp string
+        station string
+        blacklist string
+        component string
+        count int
+}
+
+func (r noiseRow) key() string {
+        return r.station + "," + r.component
+}
+
+func readDeltaState(path string) (deltaState, error) {
+        state := deltaState{Counts: map[string]int{}}
+
+        b, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                return state, nil
+        }
+        if err != nil {
+                return state, err
+        }
+
+        err = json.Unmarshal(b, &state)
+        if state.Counts == nil {
+                state.Counts = map[string]int{}
+        }
+        return state, err
+}
+
+func writeDeltaState(path string, state deltaState) error {
+        b, err := json.Marshal(state)
+        if err != nil {
+                return err
+        }
+
+        // Write then rename so a crash part way through doesn't lose the previous state.
+        tmp := path + ".tmp"
+        if err := os.WriteFile(tmp, b, 0666); err != nil {
+                return err
+        }
+        return os.Rename(tmp, path)
+}
+
+func writeNoiseDeltas(rows []noiseRow) error {
+        statePath := filepath.Join(dir, "noiseCountDelta.json")
+
+        state, err := readDeltaState(statePath)
+        if err != nil {
+                return fmt.Errorf("reading delta state: %w", err)
+        }
+
+        out := newCheckOutput("noiseCountDelta", deltaColumns...)
+        defer out.Close()
+
+        snapshot := deltaSnapshotEvery <= 1 || state.Run%deltaSnapshotEvery == 0
+        current := map[string]int{}
+
+        for _, r := range rows {
+                current[r.key()] = r.count
+
+                if snapshot {
+                        err := out.write(r.station, float64(r.count), "snapshot", r.timestamp, r.station, r.blacklist, r.component, r.count)
+                        if err != nil {
+                                return fmt.Errorf("writing file: %w", err)
+                        }
+                        continue
+                }
+
+                if d := r.count - state.Counts[r.key()]; d != 0 {
+                        err := out.write(r.station, float64(d), "delta", r.timestamp, r.station, r.blacklist, r.component, d)
+                        if err != nil {
+                                return fmt.Errorf("writing file: %w", err)
+                        }
+                }
+        }
+
+        // Stations no longer in the result go back to zero. There is no row for them this
+        // run so the blacklist is left empty.
+        if !snapshot {
+                timestamp := time.Now().UTC().Format(time.RFC3339)
+                if len(rows) > 0 {
+                        timestamp = rows[0].timestamp
+                }
+
+                for k, v := range state.Counts {
+                        if _, ok := current[k]; ok || v == 0 {
+                                continue
+                        }
+                        station, component, _ := strings.Cut(k, ",")
+
+                        err := out.write(station, float64(-v), "delta", timestamp, station, "", component, -v)
+                        if err != nil {
+                                return fmt.Errorf("writing file: %w", err)
+                        }
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return fmt.Errorf("writing file: %w", err)
+        }
+
+        err = writeDeltaState(statePath, deltaState{Run: state.Run + 1, Counts: current})
+        if err != nil {
+                return fmt.Errorf("writing delta state: %w", err)
+        }
+
+        return nil
+}
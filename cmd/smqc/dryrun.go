@@ -0,0 +1,168 @@
+package main
+
+import (
+        "context"
+        "database/sql"
+        "database/sql/driver"
+        "fmt"
+        "os"
+        "regexp"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+/*
+-dry-run is for trying out a check against the production read replica. The connection is
+read only, each check's query is printed to stdout with its parameters filled in, as it
+could be pasted into psql, and the rows it would have written follow it in the check's
+-format instead of going to the output files. With -dry-run-explain the query's plan is
+printed too, from EXPLAIN without ANALYZE so the query isn't run twice.
+
+Nothing else is written or sent: -alert-webhook, -grafana-url, the notifications,
+-results-db, -s3-bucket, -influx-url, -results-webhook, -arrow, the run registry, -delta and -new-stations
+state are all left alone whatever they're set to. Checks that read the history still read
+it, it just doesn't have this run's rows in it.
+*/
+
+// dryRunOut keeps the checks, which run at the same time, from interleaving on stdout.
+var dryRunOut sync.Mutex
+
+// disableSideEffects turns off everything that writes or sends anything for -dry-run.
+func disableSideEffects() {
+        alertWebhook, grafanaURL, notifySlack, notifySMTP, pagerdutyKey, opsgenieKey = "", "", "", "", "", ""
+        resultsDSN, s3Bucket, s3Only, influxURL, resultsWebhook, arrowOut, parquetOut = "", "", false, "", "", "", ""
+        duplicateRun, deltaMode, newStationsFeed, runDiff = "off", false, false, false
+}
+
+// readOnlyConnector makes every session read only, Postgres then refuses anything that
+// would write however the SQL's written.
+type readOnlyConnector struct {
+        driver.Connector
+}
+
+func (c readOnlyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+        conn, err := c.Connector.Connect(ctx)
+        if err != nil {
+                return nil, err
+        }
+
+        execer, ok := conn.(driver.ExecerContext)
+        if !ok {
+                conn.Close()
+                return nil, fmt.Errorf("the driver can't make the connection read only")
+        }
+        if _, err := execer.ExecContext(ctx, "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY", nil); err != nil {
+                conn.Close()
+                return nil, fmt.Errorf("making the connection read only: %w", err)
+        }
+
+        return conn, nil
+}
+
+var sqlParam = regexp.MustCompile(`\$(\d+)`)
+
+// renderSQL is query with its $n parameters replaced by args as SQL literals.
+func renderSQL(query string, args []interface{}) string {
+        return sqlParam.ReplaceAllStringFunc(query, func(p string) string {
+                n, _ := strconv.Atoi(p[1:])
+                if n < 1 || n > len(args) {
+                        return p
+                }
+                return sqlLiteral(args[n-1])
+        })
+}
+
+func sqlLiteral(v interface{}) string {
+        switch v := v.(type) {
+        case nil:
+                return "NULL"
+        case int:
+                return strconv.Itoa(v)
+        case int64:
+                return strconv.FormatInt(v, 10)
+        case float64:
+                return strconv.FormatFloat(v, 'g', -1, 64)
+        case bool:
+                return strconv.FormatBool(v)
+        case time.Time:
+                v = v.UTC()
+                return "'" + v.Format(windowTimeLayout) + "'"
+        }
+        return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+}
+
+// printDryRunQuery shows the query a check is about to run, and with -dry-run-explain its
+// plan. A plan that can't be had is shown as such rather than stopping the check.
+func printDryRunQuery(ctx context.Context, db querier, check, query string, args []interface{}) {
+        var b strings.Builder
+        fmt.Fprintf(&b, "-- %s query\n%s;\n", check, strings.TrimSpace(renderSQL(query, args)))
+
+        if dryRunExplain {
+                fmt.Fprintf(&b, "-- %s plan\n", check)
+
+                plan, err := explainQuery(ctx, db, query, args)
+                if err != nil {
+                        fmt.Fprintf(&b, "-- not available: %s\n", err)
+                }
+                for _, line := range plan {
+                        fmt.Fprintf(&b, "-- %s\n", line)
+                }
+        }
+
+        dryRunOut.Lock()
+        defer dryRunOut.Unlock()
+        fmt.Fprintln(os.Stdout, b.String())
+}
+
+// explainQuery is the query's plan a line at a time. A -dump-dir's SQLite has its own
+// EXPLAIN QUERY PLAN with the detail in the last column.
+func explainQuery(ctx context.Context, db querier, query string, args []interface{}) ([]string, error) {
+        explain := "EXPLAIN "
+        if dumpDir != "" {
+                explain = "EXPLAIN QUERY PLAN "
+        }
+
+        rows, err := db.QueryContext(ctx, explain + query, args...)
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+
+        columns, err := rows.Columns()
+        if err != nil {
+                return nil, err
+        }
+
+        var plan []string
+        for rows.Next() {
+                values := make([]sql.RawBytes, len(columns))
+                dest := make([]interface{}, len(values))
+                for i := range values {
+                        dest[i] = &values[i]
+                }
+                if err := rows.Scan(dest...); err != nil {
+                        return nil, err
+                }
+                plan = append(plan, string(values[len(values)-1]))
+        }
+
+        return plan, rows.Err()
+}
+
+// printDryRunRows shows the rows a check would have written.
+func printDryRunRows(o *checkOutput) error {
+        body, err := o.render()
+        if err != nil {
+                return err
+        }
+
+        dryRunOut.Lock()
+        defer dryRunOut.Unlock()
+
+        fmt.Fprintf(os.Stdout, "-- %s rows: %d\n", o.name, len(o.rows))
+        os.Stdout.Write(body)
+        fmt.Fprintln(os.Stdout)
+        return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+        "testing"
+        "time"
+)
+
+func TestRenderSQL(t *testing.T) {
+        at := time.Date(2024, 1, 2, 16, 0, 0, 0, time.FixedZone("NZDT", 13*3600))
+
+        for _, c := range []struct {
+                name string
+                query string
+                args []interface{}
+                expected string
+        }{
+                {"literals", "SELECT $1, $2, $3, $4, $5, $6", []interface{}{40, int64(7), 0.25, true, nil, "WEL"}, "SELECT 40, 7, 0.25, true, NULL, 'WEL'"},
+                {"quotes", "WHERE station = $1", []interface{}{"O'Neill"}, "WHERE station = 'O''Neill'"},
+                {"time", "time >= $1", []interface{}{at}, "time >= '2024-01-02 03:00:00+00'"},
+                // $10 is the tenth parameter rather than the first followed by a 0.
+                {"two digits", "$1 $10", []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, "1 10"},
+                {"reused", "$1 = '' OR $1 = x", []interface{}{""}, "'' = '' OR '' = x"},
+                {"missing", "$0 $2", []interface{}{1}, "$0 $2"},
+        } {
+                if got := renderSQL(c.query, c.args); got != c.expected {
+                        t.Errorf("%s: expected %s, got %s", c.name, c.expected, got)
+                }
+        }
+}
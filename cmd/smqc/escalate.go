@@ -0,0 +1,261 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "net/url"
+        "os"
+        "path/filepath"
+        "strings"
+        "time"
+)
+
+/*
+Paging for stations that stay broken. A non blacklisted station over a check's threshold,
+as the check counts its concerns, for -escalate-runs consecutive runs opens an incident in
+PagerDuty, through the Events API v2 with -pagerduty-routing-key, and/or an alert in
+Opsgenie with -opsgenie-api-key, and the incident is resolved the first run the station is
+back under it. A station that's only in a check's top results isn't paged for.
+-escalate-runs takes check=runs overrides like -notify-runs, and -escalate-stations limits
+paging to a list of stations, e.g. the key national network ones, where the chat
+notifications cover everything.
+
+Each station and check has its own incident, with the dedup key, the Opsgenie alias,
+
+        smqc/<check>/<station>
+
+or smqc/<source>/<check>/<station> with -sources. Both services fold a trigger with the key
+of an open incident into it, so an escalation that failed for one of them is sent to all of
+them again on the next run, as is a resolve. The state is kept in escalateState.json and a
+check that fails leaves its stations' as it was.
+*/
+
+const escalateStateFile = "escalateState.json"
+
+// defaultEscalateRuns is -escalate-runs for the checks without an override.
+const defaultEscalateRuns = 6
+
+type escalateStation struct {
+        Runs int `json:"runs"`
+        Open bool `json:"open"`
+}
+
+// escalateState is check to station.
+type escalateState map[string]map[string]*escalateStation
+
+func escalating() bool {
+        return pagerdutyKey != "" || opsgenieKey != ""
+}
+
+func escalateRunsFor(check string) int {
+        if n, ok := escalateRunOverrides[check]; ok {
+                return n
+        }
+        return escalateRuns
+}
+
+func escalateKey(check, station string) string {
+        if currentSource != "" {
+                return strings.Join([]string{"smqc", currentSource, check, station}, "/")
+        }
+        return strings.Join([]string{"smqc", check, station}, "/")
+}
+
+// escalatePersistent updates the state for the checks that ran this run, opening and
+// resolving incidents.
+func escalatePersistent(checks []string) {
+        statePath := filepath.Join(dir, escalateStateFile)
+
+        state := escalateState{}
+        if b, err := os.ReadFile(statePath); err == nil {
+                if err := json.Unmarshal(b, &state); err != nil {
+                        trace.Printf("WARNING: reading %s: %s", statePath, err)
+                }
+        }
+
+        // A check's results are its top stations however healthy they are, so only the ones
+        // over its threshold count.
+        findings.Lock()
+        flagged := map[string]map[string]bool{}
+        for check, stations := range findings.over {
+                flagged[check] = stations
+        }
+        findings.Unlock()
+
+        stations := stationList(escalateStations)
+
+        var opened, resolved int
+        for _, check := range checks {
+                if state[check] == nil {
+                        state[check] = map[string]*escalateStation{}
+                }
+
+                for station := range flagged[check] {
+                        if stations != "" && !inStationList(stations, station) {
+                                continue
+                        }
+                        s := state[check][station]
+                        if s == nil {
+                                s = &escalateStation{}
+                                state[check][station] = s
+                        }
+                        s.Runs++
+
+                        if s.Runs >= escalateRunsFor(check) && !s.Open {
+                                if err := triggerIncident(check, station, s.Runs); err != nil {
+                                        trace.Printf("WARNING: escalating %s for %s: %s", station, check, err)
+                                        continue
+                                }
+                                s.Open = true
+                                opened++
+                        }
+                }
+
+                for station, s := range state[check] {
+                        if flagged[check][station] && (stations == "" || inStationList(stations, station)) {
+                                continue
+                        }
+                        if s.Open {
+                                if err := resolveIncident(check, station); err != nil {
+                                        trace.Printf("WARNING: resolving the incident for %s from %s: %s", station, check, err)
+                                        s.Runs = 0
+                                        continue
+                                }
+                                resolved++
+                        }
+                        delete(state[check], station)
+                }
+        }
+
+        if opened > 0 || resolved > 0 {
+                trace.Printf("Escalated %d incidents and resolved %d", opened, resolved)
+        }
+
+        b, err := json.Marshal(state)
+        if err == nil {
+                tmp := statePath + ".tmp"
+                if err = os.WriteFile(tmp, b, 0666); err == nil {
+                        err = os.Rename(tmp, statePath)
+                }
+        }
+        if err != nil {
+                trace.Printf("WARNING: writing %s: %s", statePath, err)
+        }
+}
+
+// triggerIncident opens the incident in every service, an error means one of them failed.
+func triggerIncident(check, station string, runs int) error {
+        summary := fmt.Sprintf("Strong Motion noise: %s over the %s threshold for %d consecutive runs", station, check, runs)
+        // Opsgenie only takes strings as details.
+        details := map[string]string{"check": check, "station": station, "runs": fmt.Sprint(runs)}
+        if currentSource != "" {
+                details["source"] = currentSource
+        }
+
+        var errs []string
+
+        if pagerdutyKey != "" {
+                event := map[string]any{
+                        "routing_key": pagerdutyKey,
+                        "event_action": "trigger",
+                        "dedup_key": escalateKey(check, station),
+                        "payload": map[string]any{
+                                "summary": summary,
+                                "source": station,
+                                "severity": "error",
+                                "component": station,
+                                "class": check,
+                                "group": "smqc",
+                                "custom_details": details,
+                        },
+                }
+                if err := postEscalation(pagerdutyURL, "", event); err != nil {
+                        errs = append(errs, "pagerduty: " + err.Error())
+                }
+        }
+
+        if opsgenieKey != "" {
+                alert := map[string]any{
+                        "message": summary,
+                        "alias": escalateKey(check, station),
+                        "source": "smqc",
+                        "entity": station,
+                        "tags": []string{"smqc", check},
+                        "details": details,
+                        "priority": "P3",
+                }
+                if err := postEscalation(strings.TrimSuffix(opsgenieURL, "/") + "/v2/alerts", opsgenieKey, alert); err != nil {
+                        errs = append(errs, "opsgenie: " + err.Error())
+                }
+        }
+
+        if len(errs) > 0 {
+                return fmt.Errorf("%s", strings.Join(errs, ", "))
+        }
+        return nil
+}
+
+// resolveIncident resolves the incident in every service, an error means one of them failed.
+func resolveIncident(check, station string) error {
+        var errs []string
+
+        if pagerdutyKey != "" {
+                event := map[string]any{
+                        "routing_key": pagerdutyKey,
+                        "event_action": "resolve",
+                        "dedup_key": escalateKey(check, station),
+                }
+                if err := postEscalation(pagerdutyURL, "", event); err != nil {
+                        errs = append(errs, "pagerduty: " + err.Error())
+                }
+        }
+
+        if opsgenieKey != "" {
+                note := map[string]any{
+                        "source": "smqc",
+                        "note": fmt.Sprintf("%s no longer over the %s threshold", station, check),
+                }
+                u := strings.TrimSuffix(opsgenieURL, "/") + "/v2/alerts/" + url.PathEscape(escalateKey(check, station)) + "/close?identifierType=alias"
+                if err := postEscalation(u, opsgenieKey, note); err != nil {
+                        errs = append(errs, "opsgenie: " + err.Error())
+                }
+        }
+
+        if len(errs) > 0 {
+                return fmt.Errorf("%s", strings.Join(errs, ", "))
+        }
+        return nil
+}
+
+// postEscalation posts body as JSON, with an Opsgenie GenieKey when there's one.
+func postEscalation(u, genieKey string, body any) error {
+        b, err := json.Marshal(body)
+        if err != nil {
+                return err
+        }
+
+        req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+        if err != nil {
+                return err
+        }
+        req.Header.Set("Content-Type", "application/json")
+        if genieKey != "" {
+                req.Header.Set("Authorization", "GenieKey " + genieKey)
+        }
+
+        client := &http.Client{Timeout: 10 * time.Second}
+
+        res, err := client.Do(req)
+        if err != nil {
+                return err
+        }
+        defer res.Body.Close()
+
+        if res.StatusCode < 200 || res.StatusCode > 299 {
+                return fmt.Errorf("service returned %s", res.Status)
+        }
+
+        return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "reflect"
+        "testing"
+
+        "github.com/DATA-DOG/go-sqlmock"
+        "github.com/mabznz/smqc/smqc"
+)
+
+func TestEscalateOverThreshold(t *testing.T) {
+        captureOutput(t)
+        mock, db := newMock(t)
+
+        var triggered []string
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                var event struct {
+                        Action string `json:"event_action"`
+                        Key string `json:"dedup_key"`
+                }
+                json.NewDecoder(r.Body).Decode(&event)
+                if event.Action == "trigger" {
+                        triggered = append(triggered, event.Key)
+                }
+                w.WriteHeader(http.StatusAccepted)
+        }))
+        defer server.Close()
+
+        origDir, origKey, origURL, origRuns := dir, pagerdutyKey, pagerdutyURL, escalateRuns
+        dir, pagerdutyKey, pagerdutyURL, escalateRuns = t.TempDir(), "key", server.URL, 1
+        t.Cleanup(func() {
+                dir, pagerdutyKey, pagerdutyURL, escalateRuns = origDir, origKey, origURL, origRuns
+                resetFindings()
+        })
+        resetFindings()
+
+        mock.ExpectQuery(smqc.RatioDiffQuery(queryParams("ratioDiff")).SQL).
+                WithArgs(10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows(ratioRowColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL2", "false", 13.22179981, 0.99792, 0.07547).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", 1.0647, 0.9255, 0.9854))
+
+        if _, err := ratioDiff(db, newCheckTrace("ratioDiff")); err != nil {
+                t.Fatal(err)
+        }
+
+        // WEL is in the top stations but under -ratio-alert-threshold, so isn't paged for.
+        escalatePersistent([]string{"ratioDiff"})
+        if expected := []string{"smqc/ratioDiff/WEL2"}; !reflect.DeepEqual(triggered, expected) {
+                t.Errorf("expected %v triggered, got %v", expected, triggered)
+        }
+}
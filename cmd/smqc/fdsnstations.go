@@ -0,0 +1,274 @@
+package main
+
+import (
+        "encoding/xml"
+        "fmt"
+        "net/http"
+        "net/url"
+        "sort"
+        "strings"
+        "time"
+)
+
+/*
+impact.source and the station metadata drift apart: a closed station's sensor is left
+plugged in, a new one is opened before the hazard database knows about it. With
+-fdsn-stations the strong motion channels, -fdsn-channels, are fetched as StationXML from
+the FDSN station service at -fdsn-station-url and compared with the stations in
+impact.source and the values they contributed in the window. The mismatches are written to
+fdsnStations.csv as
+
+        timestamp,station,blacklist,status,value_count,start_date,end_date
+
+where status is
+
+        closed    every channel in the metadata has ended but the station still has values
+        unlisted  the station has values but isn't in the metadata at all
+        missing   the metadata has an open channel but impact.source has no such station
+
+the dates being the station's channels' earliest start and, for closed, latest end. Open
+is at the end of the window, or now without one. If the station service can't be reached
+the check fails rather than reporting every station as unlisted.
+*/
+
+var fdsnStationsColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"status", textColumn},
+        {"value_count", intColumn},
+        {"start_date", textColumn},
+        {"end_date", textColumn},
+}
+
+// fdsnStation is a station's channels in the metadata, open whether any of them are.
+type fdsnStation struct {
+        start time.Time
+        end time.Time
+        open bool
+}
+
+// stationXML is as much of FDSNStationXML as the check needs.
+type stationXML struct {
+        Networks []struct {
+                Stations []struct {
+                        Code string `xml:"code,attr"`
+                        Channels []struct {
+                                StartDate string `xml:"startDate,attr"`
+                                EndDate string `xml:"endDate,attr"`
+                        } `xml:"Channel"`
+                } `xml:"Station"`
+        } `xml:"Network"`
+}
+
+// parseFDSNTime reads a StationXML date, with or without a zone or fractional seconds.
+func parseFDSNTime(s string) (time.Time, error) {
+        for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+                if t, err := time.Parse(layout, s); err == nil {
+                        return t.UTC(), nil
+                }
+        }
+        return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// fetchFDSNStations is the stations with -fdsn-channels in the metadata, whether each has a
+// channel open at at.
+func fetchFDSNStations(at time.Time) (map[string]fdsnStation, error) {
+        q := url.Values{}
+        q.Set("level", "channel")
+        q.Set("channel", fdsnChannels)
+        q.Set("format", "xml")
+
+        client := &http.Client{Timeout: 30 * time.Second}
+
+        res, err := client.Get(fdsnStationURL + "?" + q.Encode())
+        if err != nil {
+                return nil, err
+        }
+        defer res.Body.Close()
+
+        // Nothing matching is 204, which can only be a wrong -fdsn-channels.
+        if res.StatusCode == http.StatusNoContent {
+                return nil, fmt.Errorf("station service has no %s channels", fdsnChannels)
+        }
+        if res.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("station service returned %s", res.Status)
+        }
+
+        var doc stationXML
+        if err := xml.NewDecoder(res.Body).Decode(&doc); err != nil {
+                return nil, fmt.Errorf("reading StationXML: %w", err)
+        }
+
+        stations := map[string]fdsnStation{}
+        for _, n := range doc.Networks {
+                for _, s := range n.Stations {
+                        st := stations[s.Code]
+                        for _, c := range s.Channels {
+                                start, err := parseFDSNTime(c.StartDate)
+                                if err != nil {
+                                        return nil, fmt.Errorf("station %s: %w", s.Code, err)
+                                }
+                                var end time.Time
+                                if c.EndDate != "" {
+                                        if end, err = parseFDSNTime(c.EndDate); err != nil {
+                                                return nil, fmt.Errorf("station %s: %w", s.Code, err)
+                                        }
+                                }
+
+                                if st.start.IsZero() || start.Before(st.start) {
+                                        st.start = start
+                                }
+                                if end.After(st.end) {
+                                        st.end = end
+                                }
+                                st.open = st.open || (!start.After(at) && (end.IsZero() || end.After(at)))
+                        }
+                        stations[s.Code] = st
+                }
+        }
+
+        return stations, nil
+}
+
+// inStationList says whether station is in a list from stationList.
+func inStationList(list, station string) bool {
+        return strings.Contains("," + list + ",", "," + station + ",")
+}
+
+type fdsnStationsRow struct {
+        timestamp string
+        station string
+        blacklist string
+        status string
+        count int
+        start string
+        end string
+}
+
+func fdsnStations(db querier, tr *checkTrace) (checkResult, error) {
+        at := runStart
+        if !queryTo.IsZero() {
+                at = queryTo
+        }
+        metadata, err := fetchFDSNStations(at)
+        if err != nil {
+                return checkResult{}, fmt.Errorf("fetching stations from -fdsn-station-url: %w", err)
+        }
+
+        // The same per station value counts as dataGap.
+        include, exclude := stationList(includeStations), excludeFor("fdsnStations")
+        recordQueryStats(db, "fdsnStations", dataGapSQL, withWindow(include, exclude)...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "fdsnStations", dataGapSQL, withWindow(include, exclude)...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                blacklist string
+                pgaCount int
+                pgvCount int
+        )
+
+        scan := &rowScanner{check: "fdsnStations"}
+        defer scan.report()
+
+        // A station with more than one source row has its values added up.
+        byStation := map[string]*fdsnStationsRow{}
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &blacklist, &pgaCount, &pgvCount)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                blacklist = overrideBlacklist(station, blacklist)
+                tr.row()
+
+                r := byStation[station]
+                if r == nil {
+                        r = &fdsnStationsRow{timestamp: timestamp.String(), station: station, blacklist: blacklist}
+                        byStation[station] = r
+                }
+                r.count += pgaCount + pgvCount
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        date := func(t time.Time) string {
+                if t.IsZero() {
+                        return ""
+                }
+                return t.Format(time.RFC3339)
+        }
+
+        var mismatched []*fdsnStationsRow
+        for station, r := range byStation {
+                m, listed := metadata[station]
+                switch {
+                case r.count == 0 || (listed && m.open):
+                        continue
+                case listed:
+                        r.status, r.start, r.end = "closed", date(m.start), date(m.end)
+                default:
+                        r.status = "unlisted"
+                }
+                mismatched = append(mismatched, r)
+        }
+
+        now := runStart.Format(time.RFC3339)
+        for station, m := range metadata {
+                if !m.open || byStation[station] != nil {
+                        continue
+                }
+                if (include != "" && !inStationList(include, station)) || inStationList(exclude, station) {
+                        continue
+                }
+                mismatched = append(mismatched, &fdsnStationsRow{timestamp: now, station: station, status: "missing", start: date(m.start)})
+        }
+
+        sort.Slice(mismatched, func(i, j int) bool {
+                if mismatched[i].status != mismatched[j].status {
+                        return mismatched[i].status < mismatched[j].status
+                }
+                return mismatched[i].station < mismatched[j].station
+        })
+        if len(mismatched) > limit {
+                mismatched = mismatched[:limit]
+        }
+
+        out := newCheckOutput("fdsnStations", fdsnStationsColumns...)
+        defer out.Close()
+
+        done := tr.writing()
+        defer done()
+
+        var concerns int
+        for _, r := range mismatched {
+                flagStation("fdsnStations", r.station, r.blacklist)
+                if r.blacklist != "true" {
+                        concerns++
+                        overThreshold("fdsnStations", r.station)
+                }
+                if err := out.write(r.station, float64(r.count), r.timestamp, r.station, r.blacklist, r.status, r.count, r.start, r.end); err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+        "net/http"
+        "net/http/httptest"
+        "reflect"
+        "testing"
+        "time"
+)
+
+func TestParseFDSNTime(t *testing.T) {
+        for _, c := range []struct {
+                s string
+                expected time.Time
+                err bool
+        }{
+                {"2008-09-10T00:00:00Z", time.Date(2008, 9, 10, 0, 0, 0, 0, time.UTC), false},
+                {"2008-09-10T00:00:00.5Z", time.Date(2008, 9, 10, 0, 0, 0, 5e8, time.UTC), false},
+                {"2008-09-10T12:00:00+12:00", time.Date(2008, 9, 10, 0, 0, 0, 0, time.UTC), false},
+                // Without a zone it's UTC.
+                {"2008-09-10T00:00:00", time.Date(2008, 9, 10, 0, 0, 0, 0, time.UTC), false},
+                {"2008-09-10T00:00:00.123456", time.Date(2008, 9, 10, 0, 0, 0, 123456000, time.UTC), false},
+                {"2008-09-10", time.Time{}, true},
+                {"", time.Time{}, true},
+        } {
+                got, err := parseFDSNTime(c.s)
+                if (err != nil) != c.err || !got.Equal(c.expected) || got.Location() != time.UTC {
+                        t.Errorf("%q: expected %s and an error %v, got %s and %v", c.s, c.expected, c.err, got, err)
+                }
+        }
+}
+
+func TestFetchFDSNStations(t *testing.T) {
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<FDSNStationXML xmlns="http://www.fdsn.org/xml/station/1" schemaVersion="1.1">
+  <Network code="NZ">
+    <Station code="WEL">
+      <Channel code="HNZ" locationCode="20" startDate="2008-09-10T00:00:00Z" endDate="2016-01-01T00:00:00Z"/>
+      <Channel code="HNZ" locationCode="21" startDate="2016-01-01T00:00:00"/>
+    </Station>
+    <Station code="OLD">
+      <Channel code="HNZ" locationCode="20" startDate="2001-01-01T00:00:00Z" endDate="2020-06-01T00:00:00Z"/>
+    </Station>
+  </Network>
+</FDSNStationXML>`))
+        }))
+        defer server.Close()
+
+        url := fdsnStationURL
+        fdsnStationURL = server.URL
+        t.Cleanup(func() { fdsnStationURL = url })
+
+        stations, err := fetchFDSNStations(time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC))
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        expected := map[string]fdsnStation{
+                "WEL": {start: time.Date(2008, 9, 10, 0, 0, 0, 0, time.UTC), end: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), open: true},
+                "OLD": {start: time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), end: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+        }
+        if !reflect.DeepEqual(stations, expected) {
+                t.Errorf("expected %+v, got %+v", expected, stations)
+        }
+}
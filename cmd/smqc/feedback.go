@@ -0,0 +1,199 @@
+package main
+
+import (
+        "encoding/csv"
+        "flag"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "sort"
+        "time"
+)
+
+/*
+Analyst feedback for validating thresholds.
+
+        smqc mark-false-positive [-check noiseCount] [-note "..."] STATION...
+
+records that a flagged station turned out to be fine in falsePositives.csv, as
+
+        timestamp,station,check,note
+
+with check * when the mark applies to every check. Then
+
+        smqc false-positive-report [-window 720h]
+
+compares the marks against how many runs each station was flagged in by the check history
+and writes falsePositiveReport.csv, replacing any previous report, as
+
+        station,check,flagged,false_positives,rate
+
+with a station of * for each check's overall rate.
+*/
+
+const feedbackFile = "falsePositives.csv"
+
+func markFalsePositive(args []string) error {
+        fs := flag.NewFlagSet("mark-false-positive", flag.ContinueOnError)
+        check := fs.String("check", "*", "the check the station was wrongly flagged by, * for all of them")
+        note := fs.String("note", "", "why the station is fine")
+        if err := fs.Parse(args); err != nil {
+                return err
+        }
+        if fs.NArg() == 0 {
+                return fmt.Errorf("usage: mark-false-positive [-check CHECK] [-note NOTE] STATION...")
+        }
+
+        f, err := os.OpenFile(filepath.Join(dir, feedbackFile), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+
+        w := csv.NewWriter(f)
+        timestamp := time.Now().UTC().Format(time.RFC3339)
+
+        for _, station := range fs.Args() {
+                w.Write([]string{timestamp, station, *check, *note})
+                trace.Printf("Marked %s as a false positive for %s", station, *check)
+        }
+
+        w.Flush()
+        return w.Error()
+}
+
+type feedbackKey struct {
+        station string
+        check string
+}
+
+func falsePositiveReport(args []string) error {
+        fs := flag.NewFlagSet("false-positive-report", flag.ContinueOnError)
+        window := fs.Duration("window", 30*24*time.Hour, "how far back to look at flags and feedback")
+        if err := fs.Parse(args); err != nil {
+                return err
+        }
+
+        from := time.Now().UTC().Add(-*window)
+
+        // The number of runs, by hour, each station was flagged in for each check.
+        flagged := map[feedbackKey]int{}
+        for _, check := range historyChecks {
+                history, err := readHistory(check, from)
+                if err != nil {
+                        return err
+                }
+
+                seen := map[string]bool{}
+                for _, h := range history {
+                        run := h.station + h.at.Truncate(time.Hour).Format(time.RFC3339)
+                        if !seen[run] {
+                                seen[run] = true
+                                flagged[feedbackKey{h.station, check}]++
+                        }
+                }
+        }
+
+        marks, err := readFeedback(from)
+        if err != nil {
+                return err
+        }
+
+        falsePositives := map[feedbackKey]int{}
+        for _, m := range marks {
+                for k := range flagged {
+                        if k.station == m.station && (m.check == "*" || m.check == k.check) {
+                                falsePositives[k]++
+                        }
+                }
+        }
+
+        var keys []feedbackKey
+        totals := map[string][2]int{}
+        for k, n := range flagged {
+                keys = append(keys, k)
+                t := totals[k.check]
+                totals[k.check] = [2]int{t[0] + n, t[1] + falsePositives[k]}
+        }
+        for check, t := range totals {
+                keys = append(keys, feedbackKey{"*", check})
+                flagged[feedbackKey{"*", check}] = t[0]
+                falsePositives[feedbackKey{"*", check}] = t[1]
+        }
+        sort.Slice(keys, func(i, j int) bool {
+                if keys[i].check != keys[j].check {
+                        return keys[i].check < keys[j].check
+                }
+                return keys[i].station < keys[j].station
+        })
+
+        path := filepath.Join(dir, "falsePositiveReport.csv")
+        f, err := os.Create(path)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+
+        w := csv.NewWriter(f)
+        w.Write([]string{"station", "check", "flagged", "false_positives", "rate"})
+
+        for _, k := range keys {
+                n, fp := flagged[k], falsePositives[k]
+                // More marks than flags is possible if a station was marked more than once.
+                rate := float64(fp) / float64(n)
+                if rate > 1 {
+                        rate = 1
+                }
+                w.Write([]string{k.station, k.check, fmt.Sprint(n), fmt.Sprint(fp), formatFloat(rate)})
+        }
+
+        w.Flush()
+        if err := w.Error(); err != nil {
+                return err
+        }
+
+        trace.Printf("Wrote false positive report for %d station checks to %s", len(keys), path)
+        return nil
+}
+
+type feedbackMark struct {
+        station string
+        check string
+}
+
+func readFeedback(from time.Time) ([]feedbackMark, error) {
+        f, err := os.Open(filepath.Join(dir, feedbackFile))
+        if os.IsNotExist(err) {
+                return nil, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        r := csv.NewReader(f)
+        r.FieldsPerRecord = -1
+
+        var marks []feedbackMark
+        for {
+                rec, err := r.Read()
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        return nil, err
+                }
+                if len(rec) < 3 {
+                        continue
+                }
+
+                at, err := time.Parse(time.RFC3339, rec[0])
+                if err != nil || at.Before(from) {
+                        continue
+                }
+                marks = append(marks, feedbackMark{station: rec[1], check: rec[2]})
+        }
+
+        return marks, nil
+}
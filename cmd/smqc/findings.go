@@ -0,0 +1,84 @@
+package main
+
+import (
+        "sort"
+        "sync"
+)
+
+// findings records the non blacklisted stations each check flagged this run, for the
+// features that look across checks, the ones of them over the check's threshold, and the
+// largest value each station was written with.
+var findings = struct {
+        sync.Mutex
+        byCheck map[string]map[string]bool
+        over map[string]map[string]bool
+        values map[string]map[string]float64
+}{byCheck: map[string]map[string]bool{}, over: map[string]map[string]bool{}, values: map[string]map[string]float64{}}
+
+// resetFindings starts a new run in daemon mode.
+func resetFindings() {
+        findings.Lock()
+        defer findings.Unlock()
+
+        findings.byCheck = map[string]map[string]bool{}
+        findings.over = map[string]map[string]bool{}
+        findings.values = map[string]map[string]float64{}
+}
+
+func flagStation(check, station, blacklist string) {
+        if blacklist == "true" {
+                return
+        }
+
+        findings.Lock()
+        defer findings.Unlock()
+
+        if findings.byCheck[check] == nil {
+                findings.byCheck[check] = map[string]bool{}
+        }
+        findings.byCheck[check][station] = true
+}
+
+// overThreshold is called where a check counts a concern, a non blacklisted station over
+// its threshold rather than just in the check's results, which is what's escalated.
+func overThreshold(check, station string) {
+        findings.Lock()
+        defer findings.Unlock()
+
+        if findings.over[check] == nil {
+                findings.over[check] = map[string]bool{}
+        }
+        findings.over[check][station] = true
+}
+
+// recordValue is called for every row a check writes with the row's main value.
+func recordValue(check, station string, value float64) {
+        findings.Lock()
+        defer findings.Unlock()
+
+        if findings.values[check] == nil {
+                findings.values[check] = map[string]float64{}
+        }
+        if v, ok := findings.values[check][station]; !ok || value > v {
+                findings.values[check][station] = value
+        }
+}
+
+// flaggedStations gives every station flagged this run with the checks that flagged it.
+func flaggedStations() map[string][]string {
+        findings.Lock()
+        defer findings.Unlock()
+
+        byStation := map[string][]string{}
+        for check, stations := range findings.byCheck {
+                for station := range stations {
+                        byStation[station] = append(byStation[station], check)
+                }
+        }
+
+        for _, checks := range byStation {
+                sort.Strings(checks)
+        }
+
+        return byStation
+}
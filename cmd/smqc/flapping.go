@@ -0,0 +1,231 @@
+package main
+
+import (
+        "bufio"
+        "encoding/csv"
+        "encoding/json"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+        "time"
+)
+
+/*
+Stations whose blacklist flag keeps flipping, usually manual toggling while troubleshooting,
+point at a problem that hasn't been fixed. This reads the blacklist column from the history
+already appended by the historyChecks, noiseCount.csv, ratioDiff.csv and so on, and writes
+stations that changed state more than -flapping-changes times within -flapping-window to
+blacklistFlapping.csv as
+
+        timestamp,station,changes,blacklist
+
+where blacklist is the latest state seen.
+*/
+
+// historyChecks are the checks whose appended files start timestamp,station,blacklist.
+var historyChecks = []string{"noiseCount", "ratioDiff", "mmiCheck", "flatline", "pgvRatio"}
+
+// historyTimeLayouts are the forms CURRENT_TIMESTAMP has been written in.
+var historyTimeLayouts = []string{
+        time.RFC3339Nano,
+        "2006-01-02 15:04:05.999999999-07:00",
+        "2006-01-02 15:04:05.999999999Z07:00",
+        "2006-01-02 15:04:05.999999999",
+}
+
+var flappingColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"changes", intColumn},
+        {"blacklist", textColumn},
+}
+
+type historyRow struct {
+        at time.Time
+        station string
+        blacklist string
+}
+
+func parseHistoryTime(s string) (time.Time, error) {
+        for _, layout := range historyTimeLayouts {
+                if t, err := time.Parse(layout, s); err == nil {
+                        return t, nil
+                }
+        }
+        return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+// readHistoryFiles calls read with each of a check's history files in outputDir, in either
+// -format and including the per network files written with -partition-by network and
+// rotated files. jsonl is whether the file is JSON lines rather than CSV.
+func readHistoryFiles(outputDir, check string, read func(r io.Reader, jsonl bool) error) error {
+        var paths []string
+        for _, d := range []string{outputDir, filepath.Join(outputDir, "*")} {
+                for _, ext := range []string{".csv", ".jsonl"} {
+                        p, err := historyFiles(d, check, ext)
+                        if err != nil {
+                                return err
+                        }
+                        paths = append(paths, p...)
+                }
+        }
+
+        for _, path := range paths {
+                f, err := openHistory(path)
+                if os.IsNotExist(err) {
+                        continue
+                }
+                if err != nil {
+                        return err
+                }
+
+                err = read(f, strings.HasSuffix(strings.TrimSuffix(path, ".gz"), ".jsonl"))
+                f.Close()
+                if err != nil {
+                        return fmt.Errorf("reading %s: %w", path, err)
+                }
+        }
+
+        return nil
+}
+
+// readHistory reads every row of a check's history since from.
+func readHistory(check string, from time.Time) ([]historyRow, error) {
+        var history []historyRow
+
+        err := readHistoryFiles(dir, check, func(f io.Reader, jsonl bool) error {
+                if jsonl {
+                        h, err := readHistoryJSON(f, from)
+                        history = append(history, h...)
+                        return err
+                }
+
+                r := csv.NewReader(f)
+                r.FieldsPerRecord = -1
+
+                for {
+                        rec, err := r.Read()
+                        if err == io.EOF {
+                                return nil
+                        }
+                        if err != nil {
+                                return err
+                        }
+                        if len(rec) < 3 {
+                                continue
+                        }
+
+                        at, err := parseHistoryTime(rec[0])
+                        if err != nil {
+                                // Not a data row, e.g. a header.
+                                continue
+                        }
+                        if at.Before(from) {
+                                continue
+                        }
+
+                        history = append(history, historyRow{at: at, station: rec[1], blacklist: rec[2]})
+                }
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        return history, nil
+}
+
+func readHistoryJSON(r io.Reader, from time.Time) ([]historyRow, error) {
+        var history []historyRow
+
+        scanner := bufio.NewScanner(r)
+        for scanner.Scan() {
+                var row struct {
+                        Timestamp string `json:"timestamp"`
+                        Station string `json:"station"`
+                        Blacklist string `json:"blacklist"`
+                }
+                if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+                        return nil, err
+                }
+
+                at, err := parseHistoryTime(row.Timestamp)
+                if err != nil || at.Before(from) {
+                        continue
+                }
+
+                history = append(history, historyRow{at: at, station: row.Station, blacklist: row.Blacklist})
+        }
+
+        return history, scanner.Err()
+}
+
+func blacklistFlapping(db querier, tr *checkTrace) (checkResult, error) {
+        now := time.Now().UTC()
+
+        tr.querying()
+        var history []historyRow
+        for _, check := range historyChecks {
+                h, err := readHistory(check, now.Add(-flappingWindow))
+                if err != nil {
+                        return checkResult{}, err
+                }
+                history = append(history, h...)
+        }
+        tr.executed()
+
+        sort.SliceStable(history, func(i, j int) bool {
+                return history[i].at.Before(history[j].at)
+        })
+
+        changes := map[string]int{}
+        latest := map[string]string{}
+
+        for _, h := range history {
+                tr.row()
+                if last, ok := latest[h.station]; ok && last != h.blacklist {
+                        changes[h.station]++
+                }
+                latest[h.station] = h.blacklist
+        }
+
+        done := tr.writing()
+        defer done()
+
+        out := newCheckOutput("blacklistFlapping", flappingColumns...)
+        defer out.Close()
+
+        timestamp := now.Format(time.RFC3339)
+
+        var concerns int
+
+        var stations []string
+        for station := range changes {
+                stations = append(stations, station)
+        }
+        sort.Strings(stations)
+
+        for _, station := range stations {
+                n := changes[station]
+                if n <= flappingChanges {
+                        continue
+                }
+
+                flagStation("blacklistFlapping", station, latest[station])
+                concerns++
+                overThreshold("blacklistFlapping", station)
+
+                err := out.write(station, float64(n), timestamp, station, n, latest[station])
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+        "fmt"
+
+        "github.com/mabznz/smqc/smqc"
+)
+
+/*
+A dead sensor or a locked up digitiser can keep reporting the same PGA or PGV value every
+time, which doesn't stand out in the noise counts or the ratios. This flags a station's
+component with at least -flatline-min-values values in the hour that are all within
+-flatline-spread of each other. The spread is max minus min rather than a variance so the
+query also runs against a -dump-dir SQLite database.
+*/
+const flatlineSQL = `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        CASE pga.vertical WHEN true THEN 'pga-true' WHEN false THEN 'pga-false' END AS component,
+        count(pga.pga) AS value_count,
+        MIN(pga.pga) AS min_value,
+        MAX(pga.pga) AS max_value
+FROM
+	impact.pga pga
+	INNER JOIN impact.source loc ON loc.sourcepk = pga.sourcepk
+WHERE
+	(CAST($4 AS TEXT) = '' OR ',' || CAST($4 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `)
+	AND NOT ',' || CAST($5 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `
+	AND (CAST($6 AS INTEGER) = 0 OR (pga.time >= $7 AND pga.time < $8))
+GROUP BY
+	loc.station, loc.blacklist, CASE pga.vertical WHEN true THEN 'pga-true' WHEN false THEN 'pga-false' END
+HAVING
+	count(pga.pga) >= $1 AND MAX(pga.pga) - MIN(pga.pga) <= $2
+UNION ALL
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        CASE pgv.vertical WHEN true THEN 'pgv-true' WHEN false THEN 'pgv-false' END,
+        count(pgv.pgv),
+        MIN(pgv.pgv),
+        MAX(pgv.pgv)
+FROM
+	impact.pgv pgv
+	INNER JOIN impact.source loc ON loc.sourcepk = pgv.sourcepk
+WHERE
+	(CAST($4 AS TEXT) = '' OR ',' || CAST($4 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `)
+	AND NOT ',' || CAST($5 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `
+	AND (CAST($6 AS INTEGER) = 0 OR (pgv.time >= $7 AND pgv.time < $8))
+GROUP BY
+	loc.station, loc.blacklist, CASE pgv.vertical WHEN true THEN 'pgv-true' WHEN false THEN 'pgv-false' END
+HAVING
+	count(pgv.pgv) >= $1 AND MAX(pgv.pgv) - MIN(pgv.pgv) <= $2
+ORDER BY value_count desc
+        LIMIT $3`
+
+var flatlineColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"component", textColumn},
+        {"value_count", intColumn},
+        {"min_value", floatColumn},
+        {"max_value", floatColumn},
+}
+
+func flatline(db querier, tr *checkTrace) (checkResult, error) {
+        recordQueryStats(db, "flatline", flatlineSQL, withWindow(flatlineMinValues, flatlineSpread, limit, includeStations, excludeFor("flatline"))...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "flatline", flatlineSQL, withWindow(flatlineMinValues, flatlineSpread, limit, includeStations, excludeFor("flatline"))...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                blacklist string
+                component string
+                count int
+                minValue float64
+                maxValue float64
+        )
+
+        out := newCheckOutput("flatline", flatlineColumns...)
+        defer out.Close()
+
+        var concerns int
+
+        scan := &rowScanner{check: "flatline"}
+        defer scan.report()
+
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &blacklist, &component, &count, &minValue, &maxValue)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                blacklist = overrideBlacklist(station, blacklist)
+                tr.row()
+                flagStation("flatline", station, blacklist)
+                if blacklist != "true" {
+                        concerns++
+                        overThreshold("flatline", station)
+                }
+
+                done := tr.writing()
+                err = out.write(station, float64(count), timestamp.String(), station, blacklist, component, count, minValue, maxValue)
+                done()
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        done := tr.writing()
+        defer done()
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "os"
+        "path/filepath"
+        "strings"
+)
+
+/*
+GeoJSON output for putting the noisy stations on a map. With -format geojson, or
+check=geojson for some of the checks, <check>.geojson is a FeatureCollection with a Point
+feature per row and the row's columns as its properties:
+
+        {"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[174.78,-41.28]},"properties":{"timestamp":"2026-10-14T05:00:00Z","station":"WEL",...}}]}
+
+A FeatureCollection can't be appended to so unlike the other formats the file only has the
+latest run's rows. Coordinates come from the -metadata-file, a station without them gets a
+null geometry.
+*/
+
+var outputFormats = []string{"csv", "jsonl", "geojson"}
+
+// parseFormats reads -format as a default format followed by any check=format overrides.
+func parseFormats(s string) (string, map[string]string, error) {
+        valid := func(format string) error {
+                for _, f := range outputFormats {
+                        if format == f {
+                                return nil
+                        }
+                }
+                return fmt.Errorf("unknown -format %q, expected %s", format, strings.Join(outputFormats, ", "))
+        }
+
+        def := "csv"
+        overrides := map[string]string{}
+
+        for i, part := range strings.Split(s, ",") {
+                part = strings.TrimSpace(part)
+
+                name, format, ok := strings.Cut(part, "=")
+                if !ok && i == 0 {
+                        if err := valid(part); err != nil {
+                                return "", nil, err
+                        }
+                        def = part
+                        continue
+                }
+                if !ok || name == "" {
+                        return "", nil, fmt.Errorf("invalid -format %q, only the first entry can be a format on its own", part)
+                }
+                if err := valid(format); err != nil {
+                        return "", nil, err
+                }
+
+                known := false
+                for _, c := range checkRegistry {
+                        known = known || c.check.Name() == name
+                }
+                if !known {
+                        return "", nil, fmt.Errorf("invalid -format %q, unknown check %q", part, name)
+                }
+                overrides[name] = format
+        }
+
+        return def, overrides, nil
+}
+
+// formatFor gives the output format of the check called name.
+func formatFor(name string) string {
+        if f, ok := checkFormats[name]; ok {
+                return f
+        }
+        if defaultFormat == "" {
+                return "csv"
+        }
+        return defaultFormat
+}
+
+type geoJSONFeature struct {
+        Type string `json:"type"`
+        Geometry *geoJSONPoint `json:"geometry"`
+        Properties json.RawMessage `json:"properties"`
+}
+
+type geoJSONPoint struct {
+        Type string `json:"type"`
+        Coordinates [2]float64 `json:"coordinates"`
+}
+
+// writeGeoJSON replaces path with a FeatureCollection of rows.
+func writeGeoJSON(path string, columns []column, rows []outputRow) error {
+        b, err := marshalGeoJSON(filepath.Base(path), columns, rows)
+        if err != nil {
+                return err
+        }
+
+        if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+                return err
+        }
+
+        tmp := path + ".tmp"
+        if err := os.WriteFile(tmp, b, 0666); err != nil {
+                return err
+        }
+        return os.Rename(tmp, path)
+}
+
+// marshalGeoJSON is rows as a FeatureCollection.
+func marshalGeoJSON(name string, columns []column, rows []outputRow) ([]byte, error) {
+        o := &checkOutput{name: name, columns: columns}
+
+        features := []geoJSONFeature{}
+        for _, r := range rows {
+                // The same properties as a -format jsonl line.
+                properties, err := o.marshalJSON(r)
+                if err != nil {
+                        return nil, err
+                }
+
+                f := geoJSONFeature{Type: "Feature", Properties: properties}
+                if m, ok := metadata[r.station]; ok && m.Latitude != nil {
+                        f.Geometry = &geoJSONPoint{Type: "Point", Coordinates: [2]float64{*m.Longitude, *m.Latitude}}
+                }
+                features = append(features, f)
+        }
+
+        b, err := json.Marshal(struct {
+                Type string `json:"type"`
+                Features []geoJSONFeature `json:"features"`
+        }{"FeatureCollection", features})
+        if err != nil {
+                return nil, err
+        }
+        return append(b, '\n'), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestParseFormats(t *testing.T) {
+        for _, c := range []struct {
+                flag string
+                format string
+                overrides map[string]string
+                err string
+        }{
+                {"csv", "csv", map[string]string{}, ""},
+                {"jsonl", "jsonl", map[string]string{}, ""},
+                {"csv,ratioDiff=geojson", "csv", map[string]string{"ratioDiff": "geojson"}, ""},
+                // Only overrides leaves the other checks as CSV.
+                {"spike=jsonl", "csv", map[string]string{"spike": "jsonl"}, ""},
+                {" geojson , noiseCount=csv ", "geojson", map[string]string{"noiseCount": "csv"}, ""},
+                {"xml", "", nil, `unknown -format "xml"`},
+                {"csv,spike=xml", "", nil, `unknown -format "xml"`},
+                {"csv,jsonl", "", nil, "only the first entry"},
+                {"csv,=jsonl", "", nil, "only the first entry"},
+                {"csv,noisecount=geojson", "", nil, `unknown check "noisecount"`},
+        } {
+                format, overrides, err := parseFormats(c.flag)
+                if c.err != "" {
+                        if err == nil || !strings.Contains(err.Error(), c.err) {
+                                t.Errorf("%q: expected an error with %q, got %v", c.flag, c.err, err)
+                        }
+                        continue
+                }
+                if err != nil {
+                        t.Errorf("%q: %s", c.flag, err)
+                        continue
+                }
+                if format != c.format || !reflect.DeepEqual(overrides, c.overrides) {
+                        t.Errorf("%q: expected %s and %v, got %s and %v", c.flag, c.format, c.overrides, format, overrides)
+                }
+        }
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+        "time"
+)
+
+/*
+Grafana annotations for incidents.
+
+A station turning up in more than one check in the same run (e.g. both a high noise count
+and a high PGA ratio) is a stronger signal than either on its own. When at least
+-grafana-min-stations non blacklisted stations correlate like this an incident is
+considered open and an annotation is posted to -grafana-url. Only the start of an incident
+is annotated, the open/closed state is kept in grafanaIncident.json between runs.
+*/
+
+const grafanaStateFile = "grafanaIncident.json"
+
+// correlatedStations are the stations flagged by more than one check, with those checks.
+func correlatedStations() map[string][]string {
+        correlated := map[string][]string{}
+        for station, checks := range flaggedStations() {
+                if len(checks) > 1 {
+                        correlated[station] = checks
+                }
+        }
+
+        return correlated
+}
+
+type grafanaState struct {
+        Open bool `json:"open"`
+        Since time.Time `json:"since,omitempty"`
+}
+
+type grafanaAnnotation struct {
+        Time int64 `json:"time"`
+        Tags []string `json:"tags"`
+        Text string `json:"text"`
+}
+
+// annotateIncident is best effort, problems are logged and don't fail the run.
+func annotateIncident(now time.Time) {
+        statePath := filepath.Join(dir, grafanaStateFile)
+
+        var state grafanaState
+        if b, err := os.ReadFile(statePath); err == nil {
+                if err := json.Unmarshal(b, &state); err != nil {
+                        trace.Printf("WARNING: reading %s: %s", statePath, err)
+                }
+        }
+
+        correlated := correlatedStations()
+        open := len(correlated) >= grafanaMinStations
+
+        if open && !state.Open {
+                if err := postAnnotation(now, correlated); err != nil {
+                        trace.Printf("WARNING: posting Grafana annotation: %s", err)
+                        // Leave the state closed so the next run tries again.
+                        return
+                }
+                trace.Printf("Posted Grafana annotation for incident with %d correlated stations", len(correlated))
+                state = grafanaState{Open: true, Since: now}
+        }
+
+        if !open && state.Open {
+                trace.Printf("Incident open since %s has cleared", state.Since.Format(time.RFC3339))
+                state = grafanaState{}
+        }
+
+        b, err := json.Marshal(state)
+        if err == nil {
+                err = os.WriteFile(statePath, b, 0666)
+        }
+        if err != nil {
+                trace.Printf("WARNING: writing %s: %s", statePath, err)
+        }
+}
+
+func postAnnotation(now time.Time, correlated map[string][]string) error {
+        var stations []string
+        for station := range correlated {
+                stations = append(stations, station)
+        }
+        sort.Strings(stations)
+
+        var lines []string
+        for _, station := range stations {
+                lines = append(lines, fmt.Sprintf("%s (%s)", station, strings.Join(correlated[station], ", ")))
+        }
+
+        b, err := json.Marshal(grafanaAnnotation{
+                Time: now.UnixMilli(),
+                Tags: []string{"smqc", "strong-motion"},
+                Text: fmt.Sprintf("Strong Motion noise incident: %d stations flagged by more than one check: %s",
+                        len(stations), strings.Join(lines, "; ")),
+        })
+        if err != nil {
+                return err
+        }
+
+        req, err := http.NewRequest(http.MethodPost, strings.TrimRight(grafanaURL, "/") + "/api/annotations", bytes.NewReader(b))
+        if err != nil {
+                return err
+        }
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("Authorization", "Bearer " + grafanaToken)
+
+        client := &http.Client{Timeout: 10 * time.Second}
+
+        res, err := client.Do(req)
+        if err != nil {
+                return err
+        }
+        defer res.Body.Close()
+
+        if res.StatusCode != http.StatusOK {
+                return fmt.Errorf("grafana returned %s", res.Status)
+        }
+
+        return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "time"
+)
+
+/*
+Per network or per region summaries, for technicians who only look after part of the
+country. With -group-by network or region every check's stations this run are grouped by
+their network, from the database and -metadata-file, or their -metadata-file region, and
+each group gets a row in groupSummary as
+
+        timestamp,check,group,stations,concerns,worst_station,worst_value
+
+where stations is how many of the group's stations the check wrote, concerns how many of
+those were over its threshold and not blacklisted, and the worst station the one of those
+with the highest value, or of all of them when none were. Stations without a network or
+region are in the group unknown. groupSummary is written in its -format like any other
+check, and with -partition-by by the same thing each group's rows go in its subdirectory
+with the rest of its output.
+*/
+
+var groupSummaryColumns = []column{
+        {"timestamp", textColumn},
+        {"check", textColumn},
+        {"group", textColumn},
+        {"stations", intColumn},
+        {"concerns", intColumn},
+        {"worst_station", textColumn},
+        {"worst_value", floatColumn},
+}
+
+// stationGroup is the station's network or region, or unknown.
+func stationGroup(by, station string) string {
+        var group string
+        switch by {
+        case "network":
+                group = stationNetworks[station]
+        case "region":
+                group = metadata[station].Region
+        }
+        if group == "" {
+                return "unknown"
+        }
+        return group
+}
+
+// needNetworks says whether the stations' networks have to be loaded from the database.
+func needNetworks() bool {
+        return partitionBy == "network" || groupBy == "network"
+}
+
+type stationGroupSummary struct {
+        check string
+        group string
+        stations int
+        concerns int
+        worst string
+        worstValue float64
+        worstFlagged bool
+}
+
+// add counts the station in the group, flagged if it's over the check's threshold.
+func (g *stationGroupSummary) add(station string, value float64, flagged bool) {
+        g.stations++
+        if flagged {
+                g.concerns++
+        }
+
+        switch {
+        case g.worst == "":
+        case flagged && !g.worstFlagged:
+        case flagged == g.worstFlagged && (value > g.worstValue || (value == g.worstValue && station < g.worst)):
+        default:
+                return
+        }
+        g.worst, g.worstValue, g.worstFlagged = station, value, flagged
+}
+
+func groupSummary(db querier, tr *checkTrace) (checkResult, error) {
+        findings.Lock()
+        byGroup := map[[2]string]*stationGroupSummary{}
+        for check, values := range findings.values {
+                if check == "groupSummary" {
+                        continue
+                }
+                for station, value := range values {
+                        tr.row()
+                        group := stationGroup(groupBy, station)
+
+                        g := byGroup[[2]string{check, group}]
+                        if g == nil {
+                                g = &stationGroupSummary{check: check, group: group}
+                                byGroup[[2]string{check, group}] = g
+                        }
+                        g.add(station, value, findings.byCheck[check][station])
+                }
+        }
+        findings.Unlock()
+
+        var groups []*stationGroupSummary
+        for _, g := range byGroup {
+                groups = append(groups, g)
+        }
+        sort.Slice(groups, func(i, j int) bool {
+                if groups[i].check != groups[j].check {
+                        return groups[i].check < groups[j].check
+                }
+                return groups[i].group < groups[j].group
+        })
+
+        out := newCheckOutput("groupSummary", groupSummaryColumns...)
+        defer out.Close()
+
+        done := tr.writing()
+        defer done()
+
+        timestamp := runStart.Format(time.RFC3339)
+
+        for _, g := range groups {
+                // The worst station puts the row in the group's -partition-by subdirectory.
+                var station string
+                if partitionBy == groupBy {
+                        station = g.worst
+                }
+
+                if err := out.write(station, float64(g.concerns), timestamp, g.check, g.group, g.stations, g.concerns, g.worst, g.worstValue); err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+One ranked list of the stations most likely to be broken instead of several files to
+merge. With -health-score every non blacklisted station flagged this run gets a score
+appended to healthScore.csv as
+
+        timestamp,station,score,checks
+
+A check that flagged the station adds its weight times how bad the station is compared with
+the worst one that check wrote, the station's value (noise count, ratio and so on) over
+the largest value in the check's output this run. So the noisiest station in noiseCount
+adds the whole of noiseCount's weight and one half as noisy adds half. Weights are 1
+unless -health-weights says otherwise, e.g. flatline=3,ratioDiff=2.
+*/
+
+var healthScoreColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"score", floatColumn},
+        {"checks", textColumn},
+}
+
+// parseHealthWeights reads -health-weights as check=weight pairs.
+func parseHealthWeights(s string) (map[string]float64, error) {
+        weights := map[string]float64{}
+
+        for _, part := range strings.Split(s, ",") {
+                part = strings.TrimSpace(part)
+                if part == "" {
+                        continue
+                }
+
+                name, value, ok := strings.Cut(part, "=")
+                w, err := strconv.ParseFloat(value, 64)
+                if !ok || name == "" || err != nil || w < 0 {
+                        return nil, fmt.Errorf("invalid -health-weights %q, expected check=weight", part)
+                }
+
+                known := false
+                for _, c := range checkRegistry {
+                        known = known || c.check.Name() == name
+                }
+                if !known {
+                        return nil, fmt.Errorf("invalid -health-weights %q, unknown check %q", part, name)
+                }
+                weights[name] = w
+        }
+
+        return weights, nil
+}
+
+type stationScore struct {
+        station string
+        score float64
+        checks []string
+}
+
+// stationScores scores the stations flagged this run, highest first.
+func stationScores() []stationScore {
+        findings.Lock()
+        defer findings.Unlock()
+
+        byStation := map[string]*stationScore{}
+
+        for check, stations := range findings.byCheck {
+                weight, ok := healthWeights[check]
+                if !ok {
+                        weight = 1
+                }
+
+                var worst float64
+                for _, v := range findings.values[check] {
+                        if v > worst {
+                                worst = v
+                        }
+                }
+
+                for station := range stations {
+                        // Checks without a meaningful value, or one that's zero, count in full.
+                        severity := 1.0
+                        if v, ok := findings.values[check][station]; ok && worst > 0 {
+                                severity = v / worst
+                        }
+
+                        s := byStation[station]
+                        if s == nil {
+                                s = &stationScore{station: station}
+                                byStation[station] = s
+                        }
+                        s.score += weight * severity
+                        s.checks = append(s.checks, check)
+                }
+        }
+
+        var scores []stationScore
+        for _, s := range byStation {
+                sort.Strings(s.checks)
+                scores = append(scores, *s)
+        }
+        sort.Slice(scores, func(i, j int) bool {
+                if scores[i].score != scores[j].score {
+                        return scores[i].score > scores[j].score
+                }
+                return scores[i].station < scores[j].station
+        })
+
+        return scores
+}
+
+func healthScore(db querier, tr *checkTrace) (checkResult, error) {
+        scores := stationScores()
+
+        done := tr.writing()
+        defer done()
+
+        out := newCheckOutput("healthScore", healthScoreColumns...)
+        defer out.Close()
+
+        timestamp := runStart.Format(time.RFC3339)
+
+        for _, s := range scores {
+                tr.row()
+                err := out.write(s.station, s.score, timestamp, s.station, s.score, strings.Join(s.checks, " "))
+                if err != nil {
+                        return checkResult{}, err
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count}, nil
+}
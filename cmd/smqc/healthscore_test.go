@@ -0,0 +1,37 @@
+package main
+
+import (
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestParseHealthWeights(t *testing.T) {
+        for _, c := range []struct {
+                flag string
+                weights map[string]float64
+                err string
+        }{
+                {"", map[string]float64{}, ""},
+                {"noiseCount=2, spike=0.5", map[string]float64{"noiseCount": 2, "spike": 0.5}, ""},
+                {"dataGap=0", map[string]float64{"dataGap": 0}, ""},
+                {"noiseCount", nil, "expected check=weight"},
+                {"noiseCount=-1", nil, "expected check=weight"},
+                {"noisecount=2", nil, `unknown check "noisecount"`},
+        } {
+                weights, err := parseHealthWeights(c.flag)
+                if c.err != "" {
+                        if err == nil || !strings.Contains(err.Error(), c.err) {
+                                t.Errorf("%q: expected an error with %q, got %v", c.flag, c.err, err)
+                        }
+                        continue
+                }
+                if err != nil {
+                        t.Errorf("%q: %s", c.flag, err)
+                        continue
+                }
+                if !reflect.DeepEqual(weights, c.weights) {
+                        t.Errorf("%q: expected %v, got %v", c.flag, c.weights, weights)
+                }
+        }
+}
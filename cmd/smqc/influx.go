@@ -0,0 +1,128 @@
+package main
+
+import (
+        "bytes"
+        "fmt"
+        "io"
+        "net/http"
+        "net/url"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+InfluxDB output so the noise history is in the same Grafana dashboards as the station
+telemetry. With -influx-url each check's rows for the run are written as line protocol
+points to -influx-bucket in -influx-org, one measurement per check, e.g.
+
+        noiseCount,station=WEL,blacklist=false,component=pga-true noise_count=40i 1704164400
+
+The text columns are tags and the numeric ones fields, a row with no numeric columns gets
+its main value as value. The point's time is the run's, the end of its window with -from.
+The write is to the v2 API, which InfluxDB 1.8 has too with a bucket of database/retention
+and a token of user:password. The token is -influx-token or INFLUX_TOKEN. A failed write is
+logged and doesn't fail the check.
+*/
+
+var influxClient = &http.Client{Timeout: 10 * time.Second}
+
+// influxLines are the check's rows for the run as line protocol.
+func influxLines(o *checkOutput, at time.Time) []byte {
+        var b bytes.Buffer
+
+        for _, r := range o.rows {
+                if len(r.fields) != len(o.columns) {
+                        continue
+                }
+
+                var tags, fields []string
+                for i, v := range r.fields {
+                        name := o.columns[i].name
+                        if name == "timestamp" || name == "schema_version" || v == nil {
+                                continue
+                        }
+
+                        key := influxEscape(name, ",= ")
+                        switch o.columns[i].kind {
+                        case intColumn:
+                                fields = append(fields, key + "=" + formatField(v) + "i")
+                        case floatColumn:
+                                fields = append(fields, key + "=" + formatField(v))
+                        default:
+                                // An empty tag value isn't allowed, it's left out instead.
+                                if s := formatField(v); s != "" {
+                                        tags = append(tags, key + "=" + influxEscape(s, ",= "))
+                                }
+                        }
+                }
+                if len(fields) == 0 {
+                        fields = append(fields, "value=" + strconv.FormatFloat(r.value, 'f', -1, 64))
+                }
+
+                b.WriteString(influxEscape(o.name, ", "))
+                for _, t := range tags {
+                        b.WriteByte(',')
+                        b.WriteString(t)
+                }
+                b.WriteByte(' ')
+                b.WriteString(strings.Join(fields, ","))
+                b.WriteByte(' ')
+                b.WriteString(strconv.FormatInt(at.Unix(), 10))
+                b.WriteByte('\n')
+        }
+
+        return b.Bytes()
+}
+
+// influxEscape backslash escapes the characters in special.
+func influxEscape(s, special string) string {
+        var b strings.Builder
+        for _, c := range s {
+                if strings.ContainsRune(special, c) || c == '\\' {
+                        b.WriteByte('\\')
+                }
+                b.WriteRune(c)
+        }
+        return b.String()
+}
+
+// writeInflux is best effort, problems are logged and don't fail the check.
+func writeInflux(o *checkOutput) {
+        body := influxLines(o, runStart)
+        if len(body) == 0 {
+                return
+        }
+
+        if err := postInflux(body); err != nil {
+                trace.Printf("WARNING: %s: writing to InfluxDB: %s", o.name, err)
+        }
+}
+
+func postInflux(body []byte) error {
+        q := url.Values{}
+        q.Set("org", influxOrg)
+        q.Set("bucket", influxBucket)
+        q.Set("precision", "s")
+
+        req, err := http.NewRequest(http.MethodPost, strings.TrimRight(influxURL, "/") + "/api/v2/write?" + q.Encode(), bytes.NewReader(body))
+        if err != nil {
+                return err
+        }
+        req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+        if influxToken != "" {
+                req.Header.Set("Authorization", "Token " + influxToken)
+        }
+
+        res, err := influxClient.Do(req)
+        if err != nil {
+                return err
+        }
+        defer res.Body.Close()
+
+        if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+                b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+                return fmt.Errorf("influxdb returned %s: %s", res.Status, strings.TrimSpace(string(b)))
+        }
+        return nil
+}
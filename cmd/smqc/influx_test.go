@@ -0,0 +1,39 @@
+package main
+
+import (
+        "testing"
+        "time"
+)
+
+func TestInfluxLines(t *testing.T) {
+        at := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+        for _, c := range []struct {
+                name string
+                columns []column
+                rows []outputRow
+                expected string
+        }{
+                {"noiseCount", noiseCountColumns, []outputRow{
+                        {"WEL", 40, []interface{}{"2024-01-02T03:00:00Z", "WEL", "false", "pga-true", 40}},
+                        {"TFSS", 3, []interface{}{"2024-01-02T03:00:00Z", "TFSS", "true", "pgv-false", 3}},
+                }, "noiseCount,station=WEL,blacklist=false,component=pga-true noise_count=40i 1704164400\n" +
+                        "noiseCount,station=TFSS,blacklist=true,component=pgv-false noise_count=3i 1704164400\n"},
+                {"ratioDiff", ratioDiffColumns, []outputRow{
+                        {"WEL", 12.5, []interface{}{"2024-01-02T03:00:00Z", "WEL", "false", 12.5, 0.25, 0.02}},
+                }, "ratioDiff,station=WEL,blacklist=false ratio=12.5000,max_vertical=0.2500,max_horizontal=0.0200 1704164400\n"},
+                // Tag values are escaped, empty or missing ones left out, and a row without a
+                // numeric column has its value.
+                {"text only", []column{{"timestamp", textColumn}, {"station", textColumn}, {"problem", textColumn}, {"note", textColumn}}, []outputRow{
+                        {"W L", 2, []interface{}{"2024-01-02T03:00:00Z", "W L", "a=b,c", nil}},
+                        {"SNZO", 1, []interface{}{"2024-01-02T03:00:00Z", "SNZO", "", "x"}},
+                }, "text\\ only,station=W\\ L,problem=a\\=b\\,c value=2 1704164400\n" +
+                        "text\\ only,station=SNZO,note=x value=1 1704164400\n"},
+                {"short", noiseCountColumns, []outputRow{{"WEL", 1, []interface{}{"2024-01-02T03:00:00Z", "WEL"}}}, ""},
+        } {
+                o := &checkOutput{name: c.name, columns: c.columns, rows: c.rows}
+                if got := string(influxLines(o, at)); got != c.expected {
+                        t.Errorf("%s: expected\n%s\ngot\n%s", c.name, c.expected, got)
+                }
+        }
+}
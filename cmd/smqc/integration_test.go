@@ -0,0 +1,299 @@
+//go:build integration
+
+package main
+
+import (
+        "database/sql"
+        "fmt"
+        "log"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+        "testing"
+        "time"
+
+        "github.com/ory/dockertest/v3"
+        "github.com/ory/dockertest/v3/docker"
+)
+
+/*
+The integration suite runs the checks' queries against a real Postgres, which sqlmock can
+only compare as text. It's left out of a plain go test, run it with
+
+        go test -tags integration ./cmd/smqc
+
+By default it starts a postgres container with dockertest, so it needs docker running, or
+DOCKER_HOST pointing at a daemon. With SMQC_TEST_DSN it uses that database instead, e.g. a
+CI service container. The impact schema there is dropped and recreated, so SMQC_TEST_DSN
+also needs SMQC_TEST_DESTRUCTIVE=1 to say it isn't a database that matters. Either way the database is seeded with integrationSchema, a miniature
+impact schema with only the columns the checks use, and integrationRows, a handful of
+stations each showing one problem during the hour from integrationFrom.
+*/
+
+var integrationDB *sql.DB
+
+var integrationFrom = time.Date(2024, 1, 2, 14, 0, 0, 0, time.UTC)
+
+const integrationSchema = `
+DROP SCHEMA IF EXISTS impact CASCADE;
+CREATE SCHEMA impact;
+
+CREATE TABLE impact.source (
+	sourcepk SERIAL PRIMARY KEY,
+	station TEXT NOT NULL,
+	network TEXT,
+	blacklist BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE impact.pga (
+	sourcepk INTEGER NOT NULL REFERENCES impact.source,
+	time TIMESTAMPTZ NOT NULL,
+	vertical BOOLEAN NOT NULL,
+	pga DOUBLE PRECISION NOT NULL
+);
+
+CREATE TABLE impact.pgv (
+	sourcepk INTEGER NOT NULL REFERENCES impact.source,
+	time TIMESTAMPTZ NOT NULL,
+	vertical BOOLEAN NOT NULL,
+	pgv DOUBLE PRECISION NOT NULL
+);
+
+CREATE TABLE impact.mmi (
+	sourcepk INTEGER NOT NULL REFERENCES impact.source,
+	time TIMESTAMPTZ NOT NULL,
+	mmi INTEGER NOT NULL
+);`
+
+// integrationRows seeds the stations, WEL is noisy on its vertical PGA, TFSS has a spike,
+// SNZO is quiet and its PGV stopped half an hour before the end of the hour, KHZ has
+// nothing in the hour and OLD is blacklisted but noisy.
+const integrationRows = `
+INSERT INTO impact.source (sourcepk, station, network, blacklist) VALUES
+	(1, 'WEL', 'NZ', false),
+	(2, 'TFSS', 'NZ', false),
+	(3, 'SNZO', 'NZ', false),
+	(4, 'KHZ', 'NZ', false),
+	(5, 'OLD', 'NZ', true);
+
+INSERT INTO impact.pga (sourcepk, time, vertical, pga)
+SELECT 1, '2024-01-02 14:00:00+00'::timestamptz + n * interval '1 minute', true, 2.5 FROM generate_series(0, 39) n
+UNION ALL
+SELECT 1, '2024-01-02 14:00:00+00'::timestamptz + n * interval '1 minute', false, 0.5 FROM generate_series(0, 39) n
+UNION ALL
+SELECT 2, '2024-01-02 14:00:00+00'::timestamptz + n * interval '5 minute', v, 0.2 FROM generate_series(0, 11) n, (VALUES (true), (false)) o(v)
+UNION ALL
+SELECT 2, '2024-01-02 14:30:00+00', true, 450
+UNION ALL
+SELECT 3, '2024-01-02 14:00:00+00'::timestamptz + n * interval '5 minute', v, 0.1 FROM generate_series(0, 11) n, (VALUES (true), (false)) o(v)
+UNION ALL
+SELECT 4, '2024-01-01 09:00:00+00', true, 0.1
+UNION ALL
+SELECT 5, '2024-01-02 14:00:00+00'::timestamptz + n * interval '1 minute', true, 3 FROM generate_series(0, 39) n;
+
+INSERT INTO impact.pgv (sourcepk, time, vertical, pgv)
+SELECT 1, '2024-01-02 14:00:00+00'::timestamptz + n * interval '5 minute', v, 0.5 FROM generate_series(0, 11) n, (VALUES (true), (false)) o(v)
+UNION ALL
+SELECT 2, '2024-01-02 14:00:00+00'::timestamptz + n * interval '5 minute', v, 0.1 FROM generate_series(0, 11) n, (VALUES (true), (false)) o(v)
+UNION ALL
+SELECT 3, '2024-01-02 14:00:00+00'::timestamptz + n * interval '5 minute', v, 0.05 FROM generate_series(0, 5) n, (VALUES (true), (false)) o(v);`
+
+func TestMain(m *testing.M) {
+        dsn := os.Getenv("SMQC_TEST_DSN")
+
+        if dsn != "" && os.Getenv("SMQC_TEST_DESTRUCTIVE") != "1" {
+                log.Fatalf("SMQC_TEST_DSN's impact schema is dropped and recreated, set SMQC_TEST_DESTRUCTIVE=1 if that's what you want")
+        }
+
+        var resource *dockertest.Resource
+        if dsn == "" {
+                pool, err := dockertest.NewPool("")
+                if err != nil {
+                        log.Fatalf("connecting to docker: %s", err)
+                }
+
+                resource, err = pool.RunWithOptions(&dockertest.RunOptions{
+                        Repository: "postgres",
+                        Tag: "16-alpine",
+                        Env: []string{"POSTGRES_PASSWORD=smqc", "POSTGRES_DB=hazard"},
+                }, func(c *docker.HostConfig) {
+                        c.AutoRemove = true
+                        c.RestartPolicy = docker.RestartPolicy{Name: "no"}
+                })
+                if err != nil {
+                        log.Fatalf("starting postgres: %s", err)
+                }
+                // Don't leave the container behind if the tests hang.
+                resource.Expire(600)
+
+                dsn = fmt.Sprintf("postgres://postgres:smqc@%s/hazard?sslmode=disable", resource.GetHostPort("5432/tcp"))
+
+                pool.MaxWait = 2 * time.Minute
+                if err := pool.Retry(func() error {
+                        db, err := sql.Open("postgres", dsn)
+                        if err != nil {
+                                return err
+                        }
+                        defer db.Close()
+                        return db.Ping()
+                }); err != nil {
+                        pool.Purge(resource)
+                        log.Fatalf("waiting for postgres: %s", err)
+                }
+        }
+
+        code := runIntegration(m, dsn)
+
+        if resource != nil {
+                resource.Close()
+        }
+        os.Exit(code)
+}
+
+// runIntegration seeds the database at dsn and runs the tests against it.
+func runIntegration(m *testing.M, dsn string) int {
+        db, err := sql.Open("postgres", dsn)
+        if err != nil {
+                log.Printf("opening %s: %s", dsn, err)
+                return 1
+        }
+        defer db.Close()
+
+        for _, s := range []string{integrationSchema, integrationRows} {
+                if _, err := db.Exec(s); err != nil {
+                        log.Printf("seeding the impact schema: %s", err)
+                        return 1
+                }
+        }
+        integrationDB = db
+
+        return m.Run()
+}
+
+// integrationWindow bounds the checks to the seeded hour.
+func integrationWindow(t *testing.T) {
+        t.Helper()
+
+        queryFrom, queryTo = integrationFrom, integrationFrom.Add(time.Hour)
+        t.Cleanup(func() { queryFrom, queryTo = time.Time{}, time.Time{} })
+}
+
+// runIntegrationCheck runs the check against the seeded database and returns its CSV rows
+// without the header.
+func runIntegrationCheck(t *testing.T, check string, run func(querier, *checkTrace) (checkResult, error)) (checkResult, []string) {
+        t.Helper()
+
+        files := captureOutput(t)
+        integrationWindow(t)
+
+        result, err := run(integrationDB, newCheckTrace(check))
+        if err != nil {
+                t.Fatalf("%s: %s", check, err)
+        }
+
+        lines := strings.Split(strings.TrimSpace(files[filepath.Join(dir, check + ".csv")].String()), "\n")
+        return result, lines[1:]
+}
+
+// integrationStations limits the checks to some of the seeded stations.
+func integrationStations(t *testing.T, stations string) {
+        t.Helper()
+
+        includeStations = stations
+        t.Cleanup(func() { includeStations = "" })
+}
+
+func TestIntegrationNoiseCount(t *testing.T) {
+        integrationStations(t, "WEL,SNZO")
+        result, rows := runIntegrationCheck(t, "noiseCount", noiseCount)
+
+        // SNZO's PGA components have too few values, ties come back in any order.
+        expected := []string{
+                "2024-01-02T15:00:00Z,SNZO,false,pgv-false,6,1",
+                "2024-01-02T15:00:00Z,SNZO,false,pgv-true,6,1",
+                "2024-01-02T15:00:00Z,WEL,false,pga-false,40,1",
+                "2024-01-02T15:00:00Z,WEL,false,pga-true,40,1",
+                "2024-01-02T15:00:00Z,WEL,false,pgv-false,12,1",
+                "2024-01-02T15:00:00Z,WEL,false,pgv-true,12,1",
+        }
+        got := append([]string{}, rows...)
+        sort.Strings(got)
+        if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+                t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+        }
+        if len(rows) > 0 && !strings.Contains(rows[0], ",WEL,false,pga-") {
+                t.Errorf("expected the largest count first, got %v", rows)
+        }
+        if result.rows != 6 {
+                t.Errorf("expected 6 rows, got %+v", result)
+        }
+}
+
+func TestIntegrationRatioDiff(t *testing.T) {
+        // KHZ and OLD are missing a component.
+        integrationStations(t, "WEL,TFSS,SNZO")
+        result, rows := runIntegrationCheck(t, "ratioDiff", ratioDiff)
+
+        expected := []string{
+                "2024-01-02T15:00:00Z,TFSS,false,2250.0000,450.0000,0.2000,1",
+                "2024-01-02T15:00:00Z,WEL,false,5.0000,2.5000,0.5000,1",
+                "2024-01-02T15:00:00Z,SNZO,false,1.0000,0.1000,0.1000,1",
+        }
+        if strings.Join(rows, "\n") != strings.Join(expected, "\n") {
+                t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(rows, "\n"))
+        }
+        if result.concerns != 1 {
+                t.Errorf("expected 1 concern, got %+v", result)
+        }
+}
+
+func TestIntegrationDataGap(t *testing.T) {
+        result, rows := runIntegrationCheck(t, "dataGap", dataGap)
+
+        // OLD is blacklisted, the others have plenty.
+        expected := []string{
+                "2024-01-02T15:00:00Z,KHZ,false,0,0,silent,1",
+        }
+        if strings.Join(rows, "\n") != strings.Join(expected, "\n") {
+                t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(rows, "\n"))
+        }
+        if result.concerns != 1 {
+                t.Errorf("expected 1 concern, got %+v", result)
+        }
+}
+
+func TestIntegrationSpike(t *testing.T) {
+        _, rows := runIntegrationCheck(t, "spike", spike)
+
+        // OLD has no PGV, it's written though it's blacklisted.
+        expected := []string{
+                "2024-01-02T15:00:00Z,TFSS,false,implausible-pga,450.0000,0.1000,1",
+                "2024-01-02T15:00:00Z,OLD,true,pga-pgv-mismatch,3.0000,0.0000,1",
+        }
+        if strings.Join(rows, "\n") != strings.Join(expected, "\n") {
+                t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(rows, "\n"))
+        }
+}
+
+func TestIntegrationLatency(t *testing.T) {
+        latencyThreshold = 15 * time.Minute
+        t.Cleanup(func() { latencyThreshold = 0 })
+
+        result, rows := runIntegrationCheck(t, "latency", latency)
+
+        // KHZ's last PGA was the day before, SNZO's PGV stopped at 14:25 and WEL's PGA at
+        // 14:39.
+        expected := []string{
+                "2024-01-02T15:00:00Z,KHZ,false,2024-01-01T09:00:00Z,,108000.0000,1",
+                "2024-01-02T15:00:00Z,SNZO,false,2024-01-02T14:55:00Z,2024-01-02T14:25:00Z,2100.0000,1",
+                "2024-01-02T15:00:00Z,WEL,false,2024-01-02T14:39:00Z,2024-01-02T14:55:00Z,1260.0000,1",
+                "2024-01-02T15:00:00Z,TFSS,false,2024-01-02T14:55:00Z,2024-01-02T14:55:00Z,300.0000,1",
+        }
+        if strings.Join(rows, "\n") != strings.Join(expected, "\n") {
+                t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(rows, "\n"))
+        }
+        if result.concerns != 3 {
+                t.Errorf("expected 3 concerns, got %+v", result)
+        }
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "time"
+
+        "github.com/mabznz/smqc/smqc"
+)
+
+/*
+A telemetry outage shows up as data arriving later and later before it shows up as no data.
+With -latency the newest PGA and PGV summary rows of each non blacklisted station are
+compared with the run time, or the end of the window, and the stations written to
+latency.csv as
+
+        timestamp,station,blacklist,latest_pga,latest_pgv,latency_seconds
+
+stalest first, up to -limit of them. latency_seconds is how old the staler of the two is,
+so a station whose PGV has stopped but whose PGA hasn't still shows, and a station at or
+over -latency is flagged. Rows after the end of the window aren't counted, rows before its
+start are. A station with no values at all is dataGap's, it's left out here.
+*/
+const latencySQL = `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        pga.latest AS latest_pga,
+        pgv.latest AS latest_pgv
+FROM
+	impact.source loc
+	LEFT OUTER JOIN (
+		SELECT pga.sourcepk, MAX(pga.time) AS latest
+		FROM impact.pga pga
+		WHERE CAST($3 AS INTEGER) = 0 OR pga.time < $4
+		GROUP BY pga.sourcepk
+	) pga ON pga.sourcepk = loc.sourcepk
+	LEFT OUTER JOIN (
+		SELECT pgv.sourcepk, MAX(pgv.time) AS latest
+		FROM impact.pgv pgv
+		WHERE CAST($3 AS INTEGER) = 0 OR pgv.time < $4
+		GROUP BY pgv.sourcepk
+	) pgv ON pgv.sourcepk = loc.sourcepk
+WHERE
+	(CAST($1 AS TEXT) = '' OR ',' || CAST($1 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `)
+	AND NOT ',' || CAST($2 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `
+	AND (pga.latest IS NOT NULL OR pgv.latest IS NOT NULL)`
+
+var latencyColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"latest_pga", textColumn},
+        {"latest_pgv", textColumn},
+        {"latency_seconds", floatColumn},
+}
+
+// valueTimeLayouts are how a time column comes back as text, from a -dump-dir in the form
+// psql writes it.
+var valueTimeLayouts = []string{
+        time.RFC3339Nano,
+        "2006-01-02 15:04:05.999999999-07",
+        "2006-01-02 15:04:05.999999999-07:00",
+        "2006-01-02 15:04:05.999999999",
+}
+
+// valueTime is a value's time, zero for NULL.
+type valueTime struct {
+        time.Time
+}
+
+func (t *valueTime) Scan(src interface{}) error {
+        var s string
+        switch v := src.(type) {
+        case nil:
+                t.Time = time.Time{}
+                return nil
+        case time.Time:
+                t.Time = v.UTC()
+                return nil
+        case string:
+                s = v
+        case []byte:
+                s = string(v)
+        default:
+                return fmt.Errorf("unsupported time type %T", src)
+        }
+
+        for _, layout := range valueTimeLayouts {
+                if at, err := time.Parse(layout, s); err == nil {
+                        t.Time = at.UTC()
+                        return nil
+                }
+        }
+        return fmt.Errorf("unrecognised time %q", s)
+}
+
+func (t valueTime) String() string {
+        if t.IsZero() {
+                return ""
+        }
+        return t.Format(time.RFC3339)
+}
+
+type latencyRow struct {
+        timestamp string
+        station string
+        blacklist string
+        pga valueTime
+        pgv valueTime
+        latency float64
+}
+
+// latencyArgs are the stations and, instead of the whole window, whether it's bounded and
+// its end.
+func latencyArgs() []interface{} {
+        window := windowArgs()
+        return []interface{}{includeStations, excludeFor("latency"), window[0], window[2]}
+}
+
+func latency(db querier, tr *checkTrace) (checkResult, error) {
+        recordQueryStats(db, "latency", latencySQL, latencyArgs()...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "latency", latencySQL, latencyArgs()...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                blacklist string
+                latestPGA valueTime
+                latestPGV valueTime
+        )
+
+        scan := &rowScanner{check: "latency"}
+        defer scan.report()
+
+        // A station with more than one source row has the newest of each.
+        byStation := map[string]*latencyRow{}
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &blacklist, &latestPGA, &latestPGV)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                blacklist = overrideBlacklist(station, blacklist)
+                tr.row()
+
+                // Blacklisted stations aren't expected to report.
+                if blacklist == "true" {
+                        continue
+                }
+
+                r := byStation[station]
+                if r == nil {
+                        r = &latencyRow{timestamp: timestamp.String(), station: station, blacklist: blacklist}
+                        byStation[station] = r
+                }
+                if latestPGA.After(r.pga.Time) {
+                        r.pga = latestPGA
+                }
+                if latestPGV.After(r.pgv.Time) {
+                        r.pgv = latestPGV
+                }
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        at := runStart
+        if !queryTo.IsZero() {
+                at = queryTo
+        }
+
+        stations := make([]*latencyRow, 0, len(byStation))
+        for _, r := range byStation {
+                staler := r.pga.Time
+                if staler.IsZero() || (!r.pgv.IsZero() && r.pgv.Before(staler)) {
+                        staler = r.pgv.Time
+                }
+                r.latency = at.Sub(staler).Seconds()
+                stations = append(stations, r)
+        }
+
+        sort.Slice(stations, func(i, j int) bool {
+                if stations[i].latency != stations[j].latency {
+                        return stations[i].latency > stations[j].latency
+                }
+                return stations[i].station < stations[j].station
+        })
+        if len(stations) > limit {
+                stations = stations[:limit]
+        }
+
+        out := newCheckOutput("latency", latencyColumns...)
+        defer out.Close()
+
+        done := tr.writing()
+        defer done()
+
+        var concerns int
+        for _, r := range stations {
+                if r.latency >= stationThreshold("latency", r.station, latencyThreshold.Seconds()) {
+                        concerns++
+                        overThreshold("latency", r.station)
+                        flagStation("latency", r.station, r.blacklist)
+                }
+
+                if err := out.write(r.station, r.latency, r.timestamp, r.station, r.blacklist, r.pga.String(), r.pgv.String(), r.latency); err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
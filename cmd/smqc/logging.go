@@ -0,0 +1,168 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "io"
+        "log/slog"
+        "math/rand"
+        "os"
+        "path/filepath"
+        "runtime"
+        "strconv"
+        "strings"
+        "sync/atomic"
+        "time"
+)
+
+/*
+The log is structured, each line a record with a time, level, source, message and the id of
+the run it's from
+
+        time=2024-01-02T15:00:01.234Z level=INFO source=strong_motion_noise_checks.go:704 msg="check finished" run=5f3a9c1e check=noiseCount rows=7 concerns=2
+
+or with -log-format json one JSON object per line with the same fields. -log-level leaves
+out anything less severe, debug, info, warn or error. -log-output is where it goes, a file,
+/tmp/strong_motion_noise_check.log by default and rotated by -log-max-size, or stdout or
+stderr when running in a container.
+
+A message logged with an ERROR: or WARNING: prefix, as most of the code does, is logged
+at that level without it.
+*/
+
+const defaultLogOutput = "/tmp/strong_motion_noise_check.log"
+
+var logLevel = new(slog.LevelVar)
+
+// runID identifies the lines from one run, there's a new one for each daemon or backfill run.
+var runID atomic.Value
+
+func newRunID() {
+        runID.Store(fmt.Sprintf("%08x", rand.Uint32()))
+}
+
+// logger is trace, a slog.Logger that also takes the Printf style calls most of the code
+// makes.
+type logger struct {
+        *slog.Logger
+}
+
+var logPrefixes = []struct {
+        prefix string
+        level slog.Level
+}{
+        {"ERROR: ", slog.LevelError},
+        {"WARNING: ", slog.LevelWarn},
+}
+
+func (l *logger) Printf(format string, args ...interface{}) {
+        l.output(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) Println(args ...interface{}) {
+        l.output(slog.LevelInfo, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func (l *logger) Fatalf(format string, args ...interface{}) {
+        l.output(slog.LevelError, fmt.Sprintf(format, args...))
+        os.Exit(1)
+}
+
+func (l *logger) Fatalln(args ...interface{}) {
+        l.output(slog.LevelError, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+        os.Exit(1)
+}
+
+// output logs msg from the caller of the Printf style method, at the level its prefix
+// gives or at least level.
+func (l *logger) output(level slog.Level, msg string) {
+        for _, p := range logPrefixes {
+                if strings.HasPrefix(msg, p.prefix) {
+                        msg = strings.TrimPrefix(msg, p.prefix)
+                        level = max(level, p.level)
+                        break
+                }
+        }
+
+        ctx := context.Background()
+        if !l.Enabled(ctx, level) {
+                return
+        }
+
+        var pcs [1]uintptr
+        runtime.Callers(3, pcs[:])
+        r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+        l.Handler().Handle(ctx, r)
+}
+
+// runHandler adds the run id to every record.
+type runHandler struct {
+        slog.Handler
+}
+
+func (h runHandler) Handle(ctx context.Context, r slog.Record) error {
+        if id, _ := runID.Load().(string); id != "" {
+                r.AddAttrs(slog.String("run", id))
+        }
+        return h.Handler.Handle(ctx, r)
+}
+
+func (h runHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+        return runHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h runHandler) WithGroup(name string) slog.Handler {
+        return runHandler{h.Handler.WithGroup(name)}
+}
+
+func newLogHandler(w io.Writer, format string) slog.Handler {
+        opts := &slog.HandlerOptions{
+                AddSource: true,
+                Level: logLevel,
+                ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+                        // file:line like the log package's Lshortfile rather than the full path.
+                        if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+                                a.Value = slog.StringValue(filepath.Base(src.File) + ":" + strconv.Itoa(src.Line))
+                        }
+                        return a
+                },
+        }
+
+        if format == "json" {
+                return runHandler{slog.NewJSONHandler(w, opts)}
+        }
+        return runHandler{slog.NewTextHandler(w, opts)}
+}
+
+// setupLogging replaces the stderr logger trace starts with once the flags are read.
+func setupLogging() error {
+        var level slog.Level
+        if err := level.UnmarshalText([]byte(logLevelFlag)); err != nil {
+                return fmt.Errorf("unknown -log-level %q, expected debug, info, warn or error", logLevelFlag)
+        }
+        logLevel.Set(level)
+
+        if logFormat != "text" && logFormat != "json" {
+                return fmt.Errorf("unknown -log-format %q, expected text or json", logFormat)
+        }
+        if logOutput == "stdout" && arrowOut == "-" {
+                return fmt.Errorf("-log-output stdout can't be used with -arrow -")
+        }
+
+        var w io.Writer
+        switch logOutput {
+        case "stdout":
+                w = os.Stdout
+        case "stderr":
+                w = os.Stderr
+        default:
+                l, err := openRotatingLog(logOutput)
+                if err != nil {
+                        return fmt.Errorf("opening -log-output: %w", err)
+                }
+                w = l
+        }
+
+        trace.Logger = slog.New(newLogHandler(w, logFormat))
+        return nil
+}
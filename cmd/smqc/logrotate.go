@@ -0,0 +1,94 @@
+package main
+
+import (
+        "os"
+        "path/filepath"
+        "sort"
+        "time"
+)
+
+/*
+Size based rotation of the log. Once writing would take the log past -log-max-size MB it's
+renamed with a timestamp suffix, e.g. strong_motion_noise_check.log.20240102T030405, and a
+fresh log started. Only the newest -log-keep old logs are kept.
+*/
+
+// rotatingLog is the writer under trace. It's only written through the one slog handler,
+// which serialises the writes.
+type rotatingLog struct {
+        path string
+        f *os.File
+        size int64
+}
+
+func openRotatingLog(path string) (*rotatingLog, error) {
+        l := &rotatingLog{path: path}
+        if err := l.open(); err != nil {
+                return nil, err
+        }
+        return l, nil
+}
+
+func (l *rotatingLog) open() error {
+        f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+        if err != nil {
+                return err
+        }
+
+        info, err := f.Stat()
+        if err != nil {
+                f.Close()
+                return err
+        }
+
+        l.f, l.size = f, info.Size()
+        return nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+        if max := int64(logMaxSize) << 20; max > 0 && l.size > 0 && l.size + int64(len(p)) > max {
+                // If rotating fails keep writing to the old log rather than lose the line.
+                if err := l.rotate(); err != nil {
+                        l.f.WriteString("WARNING: rotating log: " + err.Error() + "\n")
+                }
+        }
+
+        n, err := l.f.Write(p)
+        l.size += int64(n)
+        return n, err
+}
+
+func (l *rotatingLog) rotate() error {
+        rotated := l.path + "." + time.Now().UTC().Format("20060102T150405")
+        if err := os.Rename(l.path, rotated); err != nil {
+                return err
+        }
+
+        old := l.f
+        if err := l.open(); err != nil {
+                return err
+        }
+        old.Close()
+
+        return l.prune()
+}
+
+// prune removes all but the newest -log-keep rotated logs, the suffixes sort by age.
+func (l *rotatingLog) prune() error {
+        rotated, err := filepath.Glob(l.path + ".*")
+        if err != nil {
+                return err
+        }
+        if len(rotated) <= logKeep {
+                return nil
+        }
+
+        sort.Strings(rotated)
+        for _, path := range rotated[:len(rotated)-logKeep] {
+                if err := os.Remove(path); err != nil {
+                        return err
+                }
+        }
+
+        return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+        "encoding/csv"
+        "encoding/json"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+Auxiliary station metadata that doesn't live in impact.source, read from -metadata-file.
+
+The file is either a JSON array of objects or a CSV with a header row, both using the
+field names below. Only station is required. commissioned is a date as YYYY-MM-DD.
+
+        station,network,region,colocation_group,latitude,longitude,sensor_type,commissioned
+
+region is free text, e.g. a technician's patrol area, for -partition-by and -group-by. Where the database also supplies a value (currently only network) -metadata-precedence
+decides which one wins.
+*/
+
+type stationMetadata struct {
+        Station string `json:"station"`
+        Network string `json:"network"`
+        Region string `json:"region"`
+        ColocationGroup string `json:"colocation_group"`
+        Latitude *float64 `json:"latitude"`
+        Longitude *float64 `json:"longitude"`
+        SensorType string `json:"sensor_type"`
+        Commissioned string `json:"commissioned"`
+}
+
+func (m stationMetadata) validate() error {
+        if m.Station == "" {
+                return fmt.Errorf("missing station")
+        }
+        if m.Latitude != nil && (*m.Latitude < -90 || *m.Latitude > 90) {
+                return fmt.Errorf("station %s: latitude %f out of range", m.Station, *m.Latitude)
+        }
+        if m.Longitude != nil && (*m.Longitude < -180 || *m.Longitude > 180) {
+                return fmt.Errorf("station %s: longitude %f out of range", m.Station, *m.Longitude)
+        }
+        if (m.Latitude == nil) != (m.Longitude == nil) {
+                return fmt.Errorf("station %s: latitude and longitude must be given together", m.Station)
+        }
+        if m.Commissioned != "" {
+                if _, err := time.Parse("2006-01-02", m.Commissioned); err != nil {
+                        return fmt.Errorf("station %s: invalid commissioned date %q", m.Station, m.Commissioned)
+                }
+        }
+        return nil
+}
+
+// loadMetadata reads the metadata file. Malformed entries are logged and skipped, only a
+// file that can't be read or parsed at all is an error.
+func loadMetadata(path string) (map[string]stationMetadata, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        var entries []stationMetadata
+
+        switch strings.ToLower(filepath.Ext(path)) {
+        case ".json":
+                err = json.NewDecoder(f).Decode(&entries)
+        case ".csv":
+                entries, err = readMetadataCSV(f)
+        default:
+                err = fmt.Errorf("unknown metadata file type %q, expected .json or .csv", filepath.Ext(path))
+        }
+        if err != nil {
+                return nil, fmt.Errorf("reading metadata file %s: %w", path, err)
+        }
+
+        metadata := map[string]stationMetadata{}
+
+        for i, m := range entries {
+                if err := m.validate(); err != nil {
+                        trace.Printf("WARNING: metadata entry %d: %s, skipping", i+1, err)
+                        continue
+                }
+                if _, ok := metadata[m.Station]; ok {
+                        trace.Printf("WARNING: metadata entry %d: duplicate station %s, skipping", i+1, m.Station)
+                        continue
+                }
+                metadata[m.Station] = m
+        }
+
+        return metadata, nil
+}
+
+func readMetadataCSV(r io.Reader) ([]stationMetadata, error) {
+        cr := csv.NewReader(r)
+        cr.FieldsPerRecord = -1
+
+        header, err := cr.Read()
+        if err != nil {
+                return nil, err
+        }
+
+        col := map[string]int{}
+        for i, h := range header {
+                col[strings.TrimSpace(h)] = i
+        }
+        if _, ok := col["station"]; !ok {
+                return nil, fmt.Errorf("no station column in header")
+        }
+
+        var entries []stationMetadata
+
+        for line := 2; ; line++ {
+                rec, err := cr.Read()
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        return nil, err
+                }
+
+                field := func(name string) string {
+                        if i, ok := col[name]; ok && i < len(rec) {
+                                return strings.TrimSpace(rec[i])
+                        }
+                        return ""
+                }
+
+                m := stationMetadata{
+                        Station: field("station"),
+                        Network: field("network"),
+                        Region: field("region"),
+                        ColocationGroup: field("colocation_group"),
+                        SensorType: field("sensor_type"),
+                        Commissioned: field("commissioned"),
+                }
+
+                var bad bool
+                for _, c := range []struct {
+                        name string
+                        v **float64
+                }{{"latitude", &m.Latitude}, {"longitude", &m.Longitude}} {
+                        s := field(c.name)
+                        if s == "" {
+                                continue
+                        }
+                        v, err := strconv.ParseFloat(s, 64)
+                        if err != nil {
+                                trace.Printf("WARNING: metadata line %d: invalid %s %q, skipping", line, c.name, s)
+                                bad = true
+                                break
+                        }
+                        *c.v = &v
+                }
+                if bad {
+                        continue
+                }
+
+                entries = append(entries, m)
+        }
+
+        return entries, nil
+}
+
+// mergeNetworks combines the networks from the database with those in the metadata file.
+func mergeNetworks(fromDB map[string]string) map[string]string {
+        networks := map[string]string{}
+
+        for station, network := range fromDB {
+                networks[station] = network
+        }
+
+        for station, m := range metadata {
+                if m.Network == "" {
+                        continue
+                }
+                if networks[station] == "" || metadataPrecedence == "file" {
+                        networks[station] = m.Network
+                }
+        }
+
+        return networks
+}
+
+// metadataColocatedPairs gives every pair of stations sharing a colocation group.
+func metadataColocatedPairs() []colocatedPair {
+        groups := map[string][]string{}
+        for station, m := range metadata {
+                if m.ColocationGroup != "" {
+                        groups[m.ColocationGroup] = append(groups[m.ColocationGroup], station)
+                }
+        }
+
+        var pairs []colocatedPair
+        for _, stations := range groups {
+                sort.Strings(stations)
+                for i := range stations {
+                        for j := i + 1; j < len(stations); j++ {
+                                pairs = append(pairs, colocatedPair{a: stations[i], b: stations[j]})
+                        }
+                }
+        }
+
+        return pairs
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+        "net"
+        "net/http"
+        "os"
+        "os/signal"
+        "path/filepath"
+        "syscall"
+        "time"
+
+        "github.com/prometheus/client_golang/prometheus"
+        "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+Prometheus metrics for scraping the check results instead of tailing the CSVs.
+
+With -metrics-addr the latest results are served at /metrics on that address as
+
+        smqc_noise_count{station,component,blacklist,source}
+        smqc_pga_ratio{station,blacklist,source}
+        smqc_pgv_ratio{station,blacklist,source}
+
+along with how each check went on its last run
+
+        smqc_check_duration_seconds{check,source}
+        smqc_check_query_seconds{check,source}
+        smqc_check_rows{check,source}
+        smqc_check_concerns{check,source}
+        smqc_check_last_success_timestamp_seconds{check,source}
+        smqc_check_failures_total{check,source}
+
+source is the -sources name, empty and so left out without it. Each run replaces the values
+of the one before for its source, stations that drop out of a check's top results drop out
+of its gauge. After the checks the process keeps serving until it's stopped so the results
+are there to be scraped between runs, with -interval the daemon's runs keep them up to
+date. The same server has the HTML dashboard at /dashboard.
+*/
+
+var (
+        noiseCountGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_noise_count",
+                Help: "Number of PGA or PGV values reported by a station over the last hour.",
+        }, []string{"station", "component", "blacklist", "source"})
+
+        pgaRatioGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_pga_ratio",
+                Help: "Ratio of a station's larger to smaller maximum vertical and horizontal PGA over the last hour.",
+        }, []string{"station", "blacklist", "source"})
+
+        pgvRatioGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_pgv_ratio",
+                Help: "Ratio of a station's larger to smaller maximum vertical and horizontal PGV over the last hour.",
+        }, []string{"station", "blacklist", "source"})
+
+        checkDurationGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_check_duration_seconds",
+                Help: "How long the check took on its last run, including writing its output.",
+        }, []string{"check", "source"})
+
+        checkQueryGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_check_query_seconds",
+                Help: "How long the check's query took to return on its last run.",
+        }, []string{"check", "source"})
+
+        checkRowsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_check_rows",
+                Help: "Rows the check wrote on its last successful run.",
+        }, []string{"check", "source"})
+
+        checkConcernsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_check_concerns",
+                Help: "Rows over the check's threshold on its last successful run, leaving out blacklisted stations.",
+        }, []string{"check", "source"})
+
+        checkLastSuccessGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+                Name: "smqc_check_last_success_timestamp_seconds",
+                Help: "Unix time the check last finished without an error.",
+        }, []string{"check", "source"})
+
+        checkFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+                Name: "smqc_check_failures_total",
+                Help: "Number of runs of the check that failed.",
+        }, []string{"check", "source"})
+)
+
+// serveMetrics listens on addr before the checks run so a port already in use fails the
+// run straight away.
+func serveMetrics(addr string) error {
+        registry := prometheus.NewRegistry()
+        registry.MustRegister(noiseCountGauge, pgaRatioGauge, pgvRatioGauge)
+        registry.MustRegister(checkDurationGauge, checkQueryGauge, checkRowsGauge, checkConcernsGauge, checkLastSuccessGauge, checkFailures)
+
+        l, err := net.Listen("tcp", addr)
+        if err != nil {
+                return err
+        }
+
+        dashboardDirs = map[string]string{}
+        if len(sources) == 0 {
+                dashboardDirs[""] = dir
+        }
+        for _, s := range sources {
+                dashboardDirs[s.Name] = filepath.Join(dir, s.Name)
+        }
+
+        mux := http.NewServeMux()
+        mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+        mux.HandleFunc("/dashboard", serveDashboard)
+
+        go func() {
+                if err := http.Serve(l, mux); err != nil {
+                        trace.Printf("ERROR: serving metrics: %s", err)
+                }
+        }()
+
+        trace.Printf("Serving metrics on %s", l.Addr())
+
+        return nil
+}
+
+// resetGauge clears a station gauge for a new run of the current source, leaving the other
+// sources' values.
+func resetGauge(g *prometheus.GaugeVec) {
+        g.DeletePartialMatch(prometheus.Labels{"source": currentSource})
+}
+
+// recordCheckMetrics updates the metrics for a run of a check. A failed run keeps the rows
+// and concerns of the last one that worked.
+func recordCheckMetrics(tr *checkTrace, result checkResult, err error) {
+        checkDurationGauge.WithLabelValues(tr.check, currentSource).Set(time.Since(tr.start).Seconds())
+        checkQueryGauge.WithLabelValues(tr.check, currentSource).Set(tr.execute.Seconds())
+
+        if err != nil {
+                checkFailures.WithLabelValues(tr.check, currentSource).Inc()
+                return
+        }
+
+        checkRowsGauge.WithLabelValues(tr.check, currentSource).Set(float64(result.rows))
+        checkConcernsGauge.WithLabelValues(tr.check, currentSource).Set(float64(result.concerns))
+        checkLastSuccessGauge.WithLabelValues(tr.check, currentSource).SetToCurrentTime()
+}
+
+// waitForStop blocks until the process is interrupted or terminated.
+func waitForStop() {
+        stop := make(chan os.Signal, 1)
+        signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+        s := <-stop
+
+        trace.Printf("Stopped serving metrics: %s", s)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+        "fmt"
+
+        "github.com/mabznz/smqc/smqc"
+)
+
+/*
+MMI is a whole number intensity from 1 to 12 so a noise count on its own doesn't say much,
+a quiet hour legitimately reports the same low value over and over. What does point at a
+problem is a station reporting more than -noise-count-min values in the hour that never
+change at all, or reporting values that aren't on the scale.
+*/
+const mmiCheckSQL = `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        count(mmi.sourcepk) AS mmi_count,
+        MIN(mmi.mmi) AS min_mmi,
+        MAX(mmi.mmi) AS max_mmi
+FROM
+	impact.mmi mmi
+	INNER JOIN impact.source loc ON loc.sourcepk = mmi.sourcepk
+WHERE
+	(CAST($3 AS TEXT) = '' OR ',' || CAST($3 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `)
+	AND NOT ',' || CAST($4 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `
+	AND (CAST($5 AS INTEGER) = 0 OR (mmi.time >= $6 AND mmi.time < $7))
+GROUP BY
+	loc.station, loc.blacklist
+HAVING
+	(count(mmi.sourcepk) > $1 AND MIN(mmi.mmi) = MAX(mmi.mmi))
+	OR MIN(mmi.mmi) < 1
+	OR MAX(mmi.mmi) > 12
+ORDER BY mmi_count desc
+        LIMIT $2`
+
+var mmiCheckColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"problem", textColumn},
+        {"mmi_count", intColumn},
+        {"min_mmi", intColumn},
+        {"max_mmi", intColumn},
+}
+
+func mmiCheck(db querier, tr *checkTrace) (checkResult, error) {
+        recordQueryStats(db, "mmiCheck", mmiCheckSQL, withWindow(noiseCountMin, limit, includeStations, excludeFor("mmiCheck"))...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "mmiCheck", mmiCheckSQL, withWindow(noiseCountMin, limit, includeStations, excludeFor("mmiCheck"))...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                blacklist string
+                count int
+                minMMI int
+                maxMMI int
+        )
+
+        out := newCheckOutput("mmiCheck", mmiCheckColumns...)
+        defer out.Close()
+
+        var concerns int
+
+        scan := &rowScanner{check: "mmiCheck"}
+        defer scan.report()
+
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &blacklist, &count, &minMMI, &maxMMI)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                blacklist = overrideBlacklist(station, blacklist)
+                tr.row()
+                flagStation("mmiCheck", station, blacklist)
+                if blacklist != "true" {
+                        concerns++
+                        overThreshold("mmiCheck", station)
+                }
+
+                problem := "constant"
+                if minMMI < 1 || maxMMI > 12 {
+                        problem = "out-of-range"
+                }
+
+                done := tr.writing()
+                err = out.write(station, float64(count), timestamp.String(), station, blacklist, problem, count, minMMI, maxMMI)
+                done()
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        done := tr.writing()
+        defer done()
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+        "fmt"
+        "time"
+
+        "github.com/mabznz/smqc/smqc"
+)
+
+/*
+MMI from a station goes straight into the public shaking maps, so a station reporting felt
+shaking when there wasn't any is worse than one that's merely noisy. With -mmi-felt-check,
+as it calls the event service, this finds the stations
+with at least -mmi-felt-count values of -mmi-felt or more in the window and writes those
+without an earthquake to explain them to mmiFelt.csv as
+
+        timestamp,station,blacklist,felt_count,max_mmi
+
+most felt values first. The events are fetched from -quake-url whatever -quake-filter is,
+those of at least -mmi-felt-magnitude within -quake-radius km of the station explain it,
+every event for a station without -metadata-file coordinates. They're only fetched when
+a station has felt values, and if the event service can't be reached the check fails
+rather than reporting every station that felt a real earthquake.
+*/
+const mmiFeltSQL = `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        count(mmi.sourcepk) AS felt_count,
+        MAX(mmi.mmi) AS max_mmi
+FROM
+	impact.mmi mmi
+	INNER JOIN impact.source loc ON loc.sourcepk = mmi.sourcepk
+WHERE
+	mmi.mmi >= $1
+	AND (CAST($4 AS TEXT) = '' OR ',' || CAST($4 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `)
+	AND NOT ',' || CAST($5 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `
+	AND (CAST($6 AS INTEGER) = 0 OR (mmi.time >= $7 AND mmi.time < $8))
+GROUP BY
+	loc.station, loc.blacklist
+HAVING
+	count(mmi.sourcepk) >= $2
+ORDER BY felt_count desc
+        LIMIT $3`
+
+var mmiFeltColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"felt_count", intColumn},
+        {"max_mmi", intColumn},
+}
+
+type mmiFeltRow struct {
+        timestamp string
+        station string
+        blacklist string
+        count int
+        maxMMI int
+}
+
+func mmiFelt(db querier, tr *checkTrace) (checkResult, error) {
+        recordQueryStats(db, "mmiFelt", mmiFeltSQL, withWindow(mmiFeltLevel, mmiFeltCount, limit, includeStations, excludeFor("mmiFelt"))...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "mmiFelt", mmiFeltSQL, withWindow(mmiFeltLevel, mmiFeltCount, limit, includeStations, excludeFor("mmiFelt"))...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                blacklist string
+                count int
+                maxMMI int
+        )
+
+        scan := &rowScanner{check: "mmiFelt"}
+        defer scan.report()
+
+        var felt []mmiFeltRow
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &blacklist, &count, &maxMMI)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                blacklist = overrideBlacklist(station, blacklist)
+                tr.row()
+                felt = append(felt, mmiFeltRow{timestamp.String(), station, blacklist, count, maxMMI})
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        var events []quakeEvent
+        if len(felt) > 0 {
+                start, end := runStart.Add(-time.Hour), runStart
+                if !queryTo.IsZero() {
+                        start, end = queryFrom, queryTo
+                }
+                if events, err = fetchQuakes(start, end, mmiFeltMagnitude); err != nil {
+                        return checkResult{}, fmt.Errorf("fetching earthquakes from -quake-url: %w", err)
+                }
+        }
+
+        out := newCheckOutput("mmiFelt", mmiFeltColumns...)
+        defer out.Close()
+
+        done := tr.writing()
+        defer done()
+
+        var concerns int
+        for _, r := range felt {
+                if _, _, ok := quakeNear(r.station, events); ok {
+                        continue
+                }
+
+                flagStation("mmiFelt", r.station, r.blacklist)
+                if r.blacklist != "true" {
+                        concerns++
+                        overThreshold("mmiFelt", r.station)
+                }
+                if err := out.write(r.station, float64(r.count), r.timestamp, r.station, r.blacklist, r.count, r.maxMMI); err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+        "encoding/binary"
+        "fmt"
+        "math"
+        "strings"
+        "time"
+)
+
+/*
+Just enough miniSEED 2 to get the samples out of what an FDSN dataselect service returns:
+the fixed header, blockette 1000 for the encoding and record length, and the integer,
+float, Steim1 and Steim2 encodings. Anything else is an error rather than a guess.
+*/
+
+// mseedRecord is one record's channel and samples.
+type mseedRecord struct {
+        network string
+        station string
+        location string
+        channel string
+        start time.Time
+        sampleRate float64
+        samples []float64
+}
+
+// id is the record's channel as NET.STA.LOC.CHA.
+func (r mseedRecord) id() string {
+        return strings.Join([]string{r.network, r.station, r.location, r.channel}, ".")
+}
+
+// readMiniSEED decodes every record in data.
+func readMiniSEED(data []byte) ([]mseedRecord, error) {
+        var records []mseedRecord
+        for offset := 0; offset < len(data); {
+                r, length, err := readMiniSEEDRecord(data[offset:])
+                if err != nil {
+                        return nil, fmt.Errorf("record at byte %d: %w", offset, err)
+                }
+                records = append(records, r)
+                offset += length
+        }
+        return records, nil
+}
+
+func readMiniSEEDRecord(b []byte) (mseedRecord, int, error) {
+        if len(b) < 48 {
+                return mseedRecord{}, 0, fmt.Errorf("short record, %d bytes", len(b))
+        }
+
+        // The header's byte order isn't stated anywhere, the year has to make sense.
+        var order binary.ByteOrder = binary.BigEndian
+        if y := order.Uint16(b[20:]); y < 1900 || y > 2100 {
+                order = binary.LittleEndian
+        }
+
+        r := mseedRecord{
+                station: strings.TrimSpace(string(b[8:13])),
+                location: strings.TrimSpace(string(b[13:15])),
+                channel: strings.TrimSpace(string(b[15:18])),
+                network: strings.TrimSpace(string(b[18:20])),
+        }
+
+        year, day := int(order.Uint16(b[20:])), int(order.Uint16(b[22:]))
+        r.start = time.Date(year, 1, day, int(b[24]), int(b[25]), int(b[26]), int(order.Uint16(b[28:])) * 100000, time.UTC)
+
+        n := int(order.Uint16(b[30:]))
+        r.sampleRate = mseedSampleRate(int16(order.Uint16(b[32:])), int16(order.Uint16(b[34:])))
+        dataOffset := int(order.Uint16(b[44:]))
+
+        // Each blockette has to be after the last, one pointing back would never end.
+        encoding, length := -1, 0
+        for previous, next := 47, int(order.Uint16(b[46:])); next != 0; {
+                if next <= previous {
+                        return mseedRecord{}, 0, fmt.Errorf("blockette chain goes back to byte %d", next)
+                }
+                if next + 4 > len(b) {
+                        return mseedRecord{}, 0, fmt.Errorf("blockette at byte %d past the end of the record", next)
+                }
+                if order.Uint16(b[next:]) == 1000 {
+                        if next + 8 > len(b) {
+                                return mseedRecord{}, 0, fmt.Errorf("short blockette 1000")
+                        }
+                        encoding, length = int(b[next+4]), 1 << b[next+6]
+                        if b[next+5] == 0 {
+                                order = binary.LittleEndian
+                        }
+                        break
+                }
+                previous, next = next, int(order.Uint16(b[next+2:]))
+        }
+        if encoding < 0 {
+                return mseedRecord{}, 0, fmt.Errorf("no blockette 1000")
+        }
+        // A record shorter than its header would leave readMiniSEED where it was.
+        if length < 48 {
+                return mseedRecord{}, 0, fmt.Errorf("invalid record length %d", length)
+        }
+        if length > len(b) || dataOffset > length {
+                return mseedRecord{}, 0, fmt.Errorf("record length %d past the end of the data", length)
+        }
+
+        samples, err := decodeMiniSEED(encoding, order, b[dataOffset:length], n)
+        if err != nil {
+                return mseedRecord{}, 0, err
+        }
+        r.samples = samples
+
+        return r, length, nil
+}
+
+// mseedSampleRate is the sample rate from the header's factor and multiplier, as SEED has it.
+func mseedSampleRate(factor, multiplier int16) float64 {
+        f, m := float64(factor), float64(multiplier)
+        switch {
+        case factor == 0 || multiplier == 0:
+                return 0
+        case factor > 0 && multiplier > 0:
+                return f * m
+        case factor > 0:
+                return -f / m
+        case multiplier > 0:
+                return -m / f
+        }
+        return 1 / (f * m)
+}
+
+func decodeMiniSEED(encoding int, order binary.ByteOrder, b []byte, n int) ([]float64, error) {
+        size := map[int]int{1: 2, 3: 4, 4: 4, 5: 8}[encoding]
+
+        switch encoding {
+        case 10, 11:
+                return decodeSteim(encoding == 11, b, n)
+        case 1, 3, 4, 5:
+                if n * size > len(b) {
+                        return nil, fmt.Errorf("%d samples past the end of the record", n)
+                }
+        default:
+                return nil, fmt.Errorf("unsupported encoding %d", encoding)
+        }
+
+        samples := make([]float64, n)
+        for i := range samples {
+                p := b[i*size:]
+                switch encoding {
+                case 1:
+                        samples[i] = float64(int16(order.Uint16(p)))
+                case 3:
+                        samples[i] = float64(int32(order.Uint32(p)))
+                case 4:
+                        samples[i] = float64(math.Float32frombits(order.Uint32(p)))
+                case 5:
+                        samples[i] = math.Float64frombits(order.Uint64(p))
+                }
+        }
+        return samples, nil
+}
+
+// signExtend is the low bits of w as a signed number.
+func signExtend(w uint32, bits uint) int32 {
+        return int32(w << (32 - bits)) >> (32 - bits)
+}
+
+// decodeSteim undoes Steim1 or Steim2 compression, which is always big endian. Each 64 byte
+// frame is a word of 2 bit codes then 15 words of differences, the first frame's first two
+// being the record's first and last samples.
+func decodeSteim(steim2 bool, b []byte, n int) ([]float64, error) {
+        var (
+                diffs []int32
+                first, last int32
+        )
+
+        for frame := 0; frame + 64 <= len(b) && len(diffs) < n; frame += 64 {
+                codes := binary.BigEndian.Uint32(b[frame:])
+
+                for i := 1; i < 16; i++ {
+                        w := binary.BigEndian.Uint32(b[frame + 4*i:])
+                        code := (codes >> (30 - 2*uint(i))) & 3
+
+                        if frame == 0 && i == 1 {
+                                first = int32(w)
+                                continue
+                        }
+                        if frame == 0 && i == 2 {
+                                last = int32(w)
+                                continue
+                        }
+
+                        var count int
+                        var bits uint
+                        switch {
+                        case code == 0:
+                                continue
+                        case code == 1:
+                                count, bits = 4, 8
+                        case !steim2 && code == 2:
+                                count, bits = 2, 16
+                        case !steim2:
+                                count, bits = 1, 32
+                        default:
+                                dnib := w >> 30
+                                switch {
+                                case code == 2 && dnib == 1:
+                                        count, bits = 1, 30
+                                case code == 2 && dnib == 2:
+                                        count, bits = 2, 15
+                                case code == 2 && dnib == 3:
+                                        count, bits = 3, 10
+                                case code == 3 && dnib == 0:
+                                        count, bits = 5, 6
+                                case code == 3 && dnib == 1:
+                                        count, bits = 6, 5
+                                case code == 3 && dnib == 2:
+                                        count, bits = 7, 4
+                                default:
+                                        return nil, fmt.Errorf("invalid Steim2 word in frame %d", frame/64)
+                                }
+                        }
+
+                        for k := count - 1; k >= 0; k-- {
+                                diffs = append(diffs, signExtend(w >> (bits * uint(k)), bits))
+                        }
+                }
+        }
+
+        if n == 0 {
+                return nil, nil
+        }
+        if len(diffs) < n {
+                return nil, fmt.Errorf("%d samples in the Steim frames, expected %d", len(diffs), n)
+        }
+
+        // The first difference is from the previous record's last sample.
+        samples := make([]float64, n)
+        x := first
+        samples[0] = float64(x)
+        for i := 1; i < n; i++ {
+                x += diffs[i]
+                samples[i] = float64(x)
+        }
+        if x != last {
+                return nil, fmt.Errorf("Steim last sample %d, expected %d", x, last)
+        }
+
+        return samples, nil
+}
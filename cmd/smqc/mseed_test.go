@@ -0,0 +1,139 @@
+package main
+
+import (
+        "encoding/binary"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+// steimFrame is a 64 byte Steim frame of its words, the unused ones zero.
+func steimFrame(words ...uint32) []byte {
+        b := make([]byte, 64)
+        for i, w := range words {
+                binary.BigEndian.PutUint32(b[4*i:], w)
+        }
+        return b
+}
+
+func TestDecodeSteim(t *testing.T) {
+        tests := []struct {
+                name string
+                steim2 bool
+                frame []byte
+                expected []float64
+        }{
+                // Codes 1 and 2 for the four byte and two halfword difference words, from a
+                // first sample of 10 to a last of -5000.
+                {"steim1", false, steimFrame(0x01800000, 0x0000000a, 0xffffec78, 0x0002fd00, 0x03dfe890), []float64{10, 12, 9, 9, 1000, -5000}},
+                // Code 3 with five 6 bit differences then two code 2 words of one 30 bit
+                // difference each.
+                {"steim2", true, steimFrame(0x03a00000, 0x00000064, 0xfffeee8f, 0x0007e01f, 0x7ffeee0e, 0x7fffffff), []float64{100, 101, 99, 99, 130, -70000, -70001}},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        samples, err := decodeSteim(tt.steim2, tt.frame, len(tt.expected))
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if !reflect.DeepEqual(samples, tt.expected) {
+                                t.Errorf("expected %v, got %v", tt.expected, samples)
+                        }
+                })
+        }
+}
+
+func TestDecodeSteimErrors(t *testing.T) {
+        tests := []struct {
+                name string
+                steim2 bool
+                frame []byte
+                n int
+                err string
+        }{
+                {"last sample", false, steimFrame(0x01000000, 0x0000000a, 0x0000000b, 0x00010101), 4, "Steim last sample 13, expected 11"},
+                {"too few", false, steimFrame(0x01000000, 0x0000000a, 0x0000000d, 0x00010101), 5, "4 samples in the Steim frames, expected 5"},
+                {"steim2 nibble", true, steimFrame(0x02000000, 0x0000000a, 0x0000000a, 0x00000000), 1, "invalid Steim2 word in frame 0"},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        if _, err := decodeSteim(tt.steim2, tt.frame, tt.n); err == nil || err.Error() != tt.err {
+                                t.Errorf("expected %q, got %v", tt.err, err)
+                        }
+                })
+        }
+}
+
+// mseedHeader is a big endian record's fixed header with its first blockette at 48.
+func mseedHeader(size int) []byte {
+        b := make([]byte, size)
+        copy(b[8:], "WEL  10HNZNZ")
+        binary.BigEndian.PutUint16(b[20:], 2024)
+        binary.BigEndian.PutUint16(b[22:], 2)
+        binary.BigEndian.PutUint16(b[44:], 64)
+        binary.BigEndian.PutUint16(b[46:], 48)
+        return b
+}
+
+func TestReadMiniSEED(t *testing.T) {
+        b := mseedHeader(128)
+        binary.BigEndian.PutUint16(b[30:], 2)
+        binary.BigEndian.PutUint16(b[32:], 100)
+        binary.BigEndian.PutUint16(b[34:], 1)
+        // Blockette 1000, 32 bit integers in a 128 byte record.
+        binary.BigEndian.PutUint16(b[48:], 1000)
+        b[52], b[53], b[54] = 3, 1, 7
+        binary.BigEndian.PutUint32(b[64:], 5)
+        binary.BigEndian.PutUint32(b[68:], 0xfffffffd)
+
+        records, err := readMiniSEED(append(b, b...))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if len(records) != 2 {
+                t.Fatalf("expected 2 records, got %d", len(records))
+        }
+        r := records[0]
+        if r.id() != "NZ.WEL.10.HNZ" || r.sampleRate != 100 || !reflect.DeepEqual(r.samples, []float64{5, -3}) {
+                t.Errorf("unexpected record %+v", r)
+        }
+}
+
+func TestReadMiniSEEDErrors(t *testing.T) {
+        tests := []struct {
+                name string
+                record func(b []byte)
+                err string
+        }{
+                {"blockette loop", func(b []byte) {
+                        binary.BigEndian.PutUint16(b[48:], 1001)
+                        binary.BigEndian.PutUint16(b[50:], 48)
+                }, "blockette chain goes back to byte 48"},
+                {"blockette in the header", func(b []byte) {
+                        binary.BigEndian.PutUint16(b[46:], 20)
+                }, "blockette chain goes back to byte 20"},
+                {"no blockette 1000", func(b []byte) {
+                        binary.BigEndian.PutUint16(b[48:], 1001)
+                }, "no blockette 1000"},
+                {"zero length", func(b []byte) {
+                        binary.BigEndian.PutUint16(b[48:], 1000)
+                        b[52], b[53], b[54] = 3, 1, 64
+                }, "invalid record length 0"},
+                {"short length", func(b []byte) {
+                        binary.BigEndian.PutUint16(b[48:], 1000)
+                        b[52], b[53], b[54] = 3, 1, 0
+                }, "invalid record length 1"},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        b := mseedHeader(128)
+                        tt.record(b)
+                        if _, err := readMiniSEED(b); err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+                                t.Errorf("expected %q, got %v", tt.err, err)
+                        }
+                })
+        }
+}
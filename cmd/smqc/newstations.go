@@ -0,0 +1,161 @@
+package main
+
+import (
+        "bufio"
+        "fmt"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+        "time"
+)
+
+/*
+The "brand new problems" feed. With -new-stations, stations flagged this run that have never
+been flagged before are written to newStations.csv as
+
+        timestamp,station,checks
+
+Looking through the whole history every run would get slower forever so the stations seen
+so far are kept in flaggedStations.txt, one per line. The first time it's built from the
+existing history of the historyChecks, noiseCount.csv, ratioDiff.csv and so on, or their
+rows in smqc.results with -results-db, which has to happen before this run's checks add to
+that history.
+*/
+
+const seenStationsFile = "flaggedStations.txt"
+
+var newStationColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"checks", textColumn},
+}
+
+func readSeenStations() (map[string]bool, error) {
+        seen := map[string]bool{}
+
+        f, err := os.Open(filepath.Join(dir, seenStationsFile))
+        if os.IsNotExist(err) {
+                return seedSeenStations()
+        }
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        scanner := bufio.NewScanner(f)
+        for scanner.Scan() {
+                if station := strings.TrimSpace(scanner.Text()); station != "" {
+                        seen[station] = true
+                }
+        }
+
+        return seen, scanner.Err()
+}
+
+// seedSeenStations reads the stations flagged so far from the check history, in -results-db
+// when it's set as a deployment might only be writing there.
+func seedSeenStations() (map[string]bool, error) {
+        seen := map[string]bool{}
+        add := func(check string, h historyRow) {
+                if h.blacklist != "true" {
+                        seen[h.station] = true
+                }
+        }
+
+        if resultsDSN != "" {
+                if err := readResultsHistory(historyChecks, time.Time{}, add); err != nil {
+                        return nil, fmt.Errorf("reading -results-db: %w", err)
+                }
+                trace.Printf("Seeded %s with %d stations from -results-db", seenStationsFile, len(seen))
+                return seen, nil
+        }
+
+        for _, check := range historyChecks {
+                history, err := readHistory(check, time.Time{})
+                if err != nil {
+                        return nil, err
+                }
+                for _, h := range history {
+                        add(check, h)
+                }
+        }
+
+        trace.Printf("Seeded %s with %d stations from the check history", seenStationsFile, len(seen))
+
+        return seen, nil
+}
+
+// loadSeenStations is newStations' setup.
+func loadSeenStations() error {
+        seen, err := readSeenStations()
+        if err != nil {
+                return fmt.Errorf("reading %s: %w", seenStationsFile, err)
+        }
+        seenStations = seen
+        return nil
+}
+
+func writeSeenStations(seen map[string]bool) error {
+        var stations []string
+        for station := range seen {
+                stations = append(stations, station)
+        }
+        sort.Strings(stations)
+
+        path := filepath.Join(dir, seenStationsFile)
+        tmp := path + ".tmp"
+
+        if err := os.WriteFile(tmp, []byte(strings.Join(stations, "\n") + "\n"), 0666); err != nil {
+                return err
+        }
+        return os.Rename(tmp, path)
+}
+
+func newStations(db querier, tr *checkTrace) (checkResult, error) {
+        seen := seenStations
+        if seen == nil {
+                return checkResult{}, fmt.Errorf("flagged station index not loaded")
+        }
+
+        var fresh []string
+        flagged := flaggedStations()
+        for station := range flagged {
+                tr.row()
+                if !seen[station] {
+                        fresh = append(fresh, station)
+                }
+        }
+        sort.Strings(fresh)
+
+        done := tr.writing()
+        defer done()
+
+        out := newCheckOutput("newStations", newStationColumns...)
+        defer out.Close()
+
+        timestamp := runStart.Format(time.RFC3339)
+
+        for _, station := range fresh {
+                seen[station] = true
+
+                err := out.write(station, 0, timestamp, station, strings.Join(flagged[station], " "))
+                if err != nil {
+                        return checkResult{}, err
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        if len(fresh) > 0 {
+                trace.Printf("%d stations flagged for the first time: %s", len(fresh), strings.Join(fresh, ", "))
+        }
+
+        if err := writeSeenStations(seen); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: len(fresh)}, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+        "path/filepath"
+        "reflect"
+        "testing"
+)
+
+func TestSeedSeenStationsResultsDB(t *testing.T) {
+        dsn := resultsDSN
+        resultsDSN = "sqlite:" + filepath.Join(t.TempDir(), "results.db")
+        t.Cleanup(func() { resultsDSN = dsn })
+
+        db, err := openResultsDB(resultsDSN)
+        if err != nil {
+                t.Fatal(err)
+        }
+        for _, r := range []struct{ check, station, key string }{
+                {"noiseCount", "WEL", "blacklist=false,component=pga-true"},
+                {"ratioDiff", "TFSS", "blacklist=false"},
+                {"ratioDiff", "OLD", "blacklist=true"},
+        } {
+                if _, err := db.Exec(upsertResultSQL, "2024-01-02T03:00:00Z", r.check, r.station, r.key, 1.0, "{}", "2024-01-02T03:00:00Z"); err != nil {
+                        t.Fatal(err)
+                }
+        }
+        db.Close()
+
+        // There's no csv history, the stations come from -results-db less the blacklisted OLD.
+        orig := dir
+        dir = t.TempDir()
+        t.Cleanup(func() { dir = orig })
+
+        seen, err := seedSeenStations()
+        if err != nil {
+                t.Fatal(err)
+        }
+        if expected := map[string]bool{"WEL": true, "TFSS": true}; !reflect.DeepEqual(seen, expected) {
+                t.Errorf("expected %v, got %v", expected, seen)
+        }
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "net/smtp"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+Notifications for stations that stay in a check's results. A non blacklisted station
+flagged by a check for -notify-runs consecutive runs is sent once to -notify-slack and/or
+emailed through -notify-smtp, and not again until it's dropped out of that check's results,
+when a recovery is sent. -notify-runs is a number of runs optionally followed by
+check=runs overrides, e.g. 3,ratioDiff=2.
+
+The consecutive runs and what's been sent are kept in notifyState.json. A check that fails
+leaves its stations' state as it was. Sending is best effort, if every notifier fails the
+new alerts are tried again next run.
+*/
+
+const notifyStateFile = "notifyState.json"
+
+// defaultNotifyRuns is -notify-runs for the checks without an override.
+const defaultNotifyRuns = 3
+
+type notifyStation struct {
+        Runs int `json:"runs"`
+        Alerted bool `json:"alerted"`
+}
+
+// notifyState is check to station.
+type notifyState map[string]map[string]*notifyStation
+
+// parseRuns reads a runs flag like -notify-runs, name being the flag. A check without an
+// override has the number on its own, or def when there's only overrides.
+func parseRuns(name, s string, def int) (int, map[string]int, error) {
+        overrides := map[string]int{}
+
+        for i, part := range strings.Split(s, ",") {
+                part = strings.TrimSpace(part)
+                check, value, ok := strings.Cut(part, "=")
+                if !ok {
+                        if i > 0 {
+                                return 0, nil, fmt.Errorf("invalid -%s %q, only the first entry can be a number on its own", name, part)
+                        }
+                        value = part
+                }
+
+                n, err := strconv.Atoi(value)
+                if err != nil || n < 1 {
+                        return 0, nil, fmt.Errorf("invalid -%s %q, expected a number of runs of at least 1", name, part)
+                }
+                if !ok {
+                        def = n
+                        continue
+                }
+
+                known := false
+                for _, c := range checkRegistry {
+                        known = known || c.check.Name() == check
+                }
+                if !known {
+                        return 0, nil, fmt.Errorf("invalid -%s %q, unknown check %q", name, part, check)
+                }
+                overrides[check] = n
+        }
+
+        return def, overrides, nil
+}
+
+func notifyRunsFor(check string) int {
+        if n, ok := notifyRunOverrides[check]; ok {
+                return n
+        }
+        return notifyRuns
+}
+
+// notifyPersistent updates the state for the checks that ran this run and sends what's new.
+func notifyPersistent(checks []string) {
+        statePath := filepath.Join(dir, notifyStateFile)
+
+        state := notifyState{}
+        if b, err := os.ReadFile(statePath); err == nil {
+                if err := json.Unmarshal(b, &state); err != nil {
+                        trace.Printf("WARNING: reading %s: %s", statePath, err)
+                }
+        }
+
+        findings.Lock()
+        flagged := map[string]map[string]bool{}
+        for check, stations := range findings.byCheck {
+                flagged[check] = stations
+        }
+        findings.Unlock()
+
+        var alerts, recoveries []string
+        var newAlerts []*notifyStation
+
+        for _, check := range checks {
+                if state[check] == nil {
+                        state[check] = map[string]*notifyStation{}
+                }
+
+                for station := range flagged[check] {
+                        s := state[check][station]
+                        if s == nil {
+                                s = &notifyStation{}
+                                state[check][station] = s
+                        }
+                        s.Runs++
+
+                        if s.Runs >= notifyRunsFor(check) && !s.Alerted {
+                                s.Alerted = true
+                                newAlerts = append(newAlerts, s)
+                                alerts = append(alerts, fmt.Sprintf("%s flagged by %s for %d consecutive runs", station, check, s.Runs))
+                        }
+                }
+
+                for station, s := range state[check] {
+                        if flagged[check][station] {
+                                continue
+                        }
+                        if s.Alerted {
+                                recoveries = append(recoveries, fmt.Sprintf("%s no longer flagged by %s", station, check))
+                        }
+                        delete(state[check], station)
+                }
+        }
+
+        if len(alerts) > 0 || len(recoveries) > 0 {
+                sort.Strings(alerts)
+                sort.Strings(recoveries)
+
+                if err := sendNotification(alerts, recoveries); err != nil {
+                        trace.Printf("WARNING: sending notification: %s", err)
+                        for _, s := range newAlerts {
+                                s.Alerted = false
+                        }
+                } else {
+                        trace.Printf("Sent notification of %d alerts and %d recoveries", len(alerts), len(recoveries))
+                }
+        }
+
+        b, err := json.Marshal(state)
+        if err == nil {
+                tmp := statePath + ".tmp"
+                if err = os.WriteFile(tmp, b, 0666); err == nil {
+                        err = os.Rename(tmp, statePath)
+                }
+        }
+        if err != nil {
+                trace.Printf("WARNING: writing %s: %s", statePath, err)
+        }
+}
+
+// sendNotification sends to every notifier, an error means none of them worked.
+func sendNotification(alerts, recoveries []string) error {
+        subject := fmt.Sprintf("Strong Motion noise: %d alerts, %d recoveries", len(alerts), len(recoveries))
+
+        var lines []string
+        for _, a := range alerts {
+                lines = append(lines, "ALERT: " + a)
+        }
+        for _, r := range recoveries {
+                lines = append(lines, "RECOVERED: " + r)
+        }
+        body := strings.Join(lines, "\n")
+
+        var errs []string
+        sent := false
+
+        if notifySlack != "" {
+                if err := postSlack(subject + "\n" + body); err != nil {
+                        errs = append(errs, "slack: " + err.Error())
+                } else {
+                        sent = true
+                }
+        }
+
+        if notifySMTP != "" {
+                if err := sendMail(subject, body); err != nil {
+                        errs = append(errs, "email: " + err.Error())
+                } else {
+                        sent = true
+                }
+        }
+
+        if len(errs) > 0 {
+                trace.Printf("WARNING: notifying: %s", strings.Join(errs, ", "))
+        }
+        if !sent {
+                return fmt.Errorf("no notifier succeeded")
+        }
+        return nil
+}
+
+func postSlack(text string) error {
+        b, err := json.Marshal(struct {
+                Text string `json:"text"`
+        }{text})
+        if err != nil {
+                return err
+        }
+
+        client := &http.Client{Timeout: 10 * time.Second}
+
+        res, err := client.Post(notifySlack, "application/json", bytes.NewReader(b))
+        if err != nil {
+                return err
+        }
+        defer res.Body.Close()
+
+        if res.StatusCode < 200 || res.StatusCode > 299 {
+                return fmt.Errorf("webhook returned %s", res.Status)
+        }
+
+        return nil
+}
+
+// sendMail authenticates with -notify-smtp-user and SMTP_PASSWORD when the user is set.
+func sendMail(subject, body string) error {
+        var to []string
+        for _, addr := range strings.Split(notifyTo, ",") {
+                if addr = strings.TrimSpace(addr); addr != "" {
+                        to = append(to, addr)
+                }
+        }
+
+        var auth smtp.Auth
+        if notifySMTPUser != "" {
+                host, _, _ := strings.Cut(notifySMTP, ":")
+                auth = smtp.PlainAuth("", notifySMTPUser, os.Getenv("SMTP_PASSWORD"), host)
+        }
+
+        msg := "From: " + notifyFrom + "\r\n" +
+                "To: " + strings.Join(to, ", ") + "\r\n" +
+                "Subject: " + subject + "\r\n" +
+                "Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+                "\r\n" +
+                strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"
+
+        return smtp.SendMail(notifySMTP, auth, notifyFrom, to, []byte(msg))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestParseRuns(t *testing.T) {
+        for _, c := range []struct {
+                flag string
+                runs int
+                overrides map[string]int
+                err string
+        }{
+                {"3", 3, map[string]int{}, ""},
+                {"2,ratioDiff=4", 2, map[string]int{"ratioDiff": 4}, ""},
+                // Only overrides leaves the other checks at the default.
+                {"dataGap=3", 6, map[string]int{"dataGap": 3}, ""},
+                {" 5 , spike=1 ", 5, map[string]int{"spike": 1}, ""},
+                {"0", 0, nil, "at least 1"},
+                {"dataGap=x", 0, nil, "at least 1"},
+                {"dataGap=3,4", 0, nil, "only the first entry"},
+                {"dataGaps=3", 0, nil, `unknown check "dataGaps"`},
+        } {
+                runs, overrides, err := parseRuns("escalate-runs", c.flag, 6)
+                if c.err != "" {
+                        if err == nil || !strings.Contains(err.Error(), c.err) {
+                                t.Errorf("%q: expected an error with %q, got %v", c.flag, c.err, err)
+                        }
+                        continue
+                }
+                if err != nil {
+                        t.Errorf("%q: %s", c.flag, err)
+                        continue
+                }
+                if runs != c.runs || !reflect.DeepEqual(overrides, c.overrides) {
+                        t.Errorf("%q: expected %d and %v, got %d and %v", c.flag, c.runs, c.overrides, runs, overrides)
+                }
+        }
+}
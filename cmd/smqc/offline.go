@@ -0,0 +1,209 @@
+package main
+
+import (
+        "context"
+        "database/sql"
+        "encoding/csv"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "regexp"
+        "strconv"
+        "strings"
+
+        _ "modernc.org/sqlite"
+)
+
+/*
+Offline mode for developing checks without VPN access to the hazard database.
+
+With -dump-dir the impact.pga, impact.pgv, impact.mmi and impact.source tables are loaded
+from pga.csv, pgv.csv, mmi.csv and source.csv in that directory into an in memory SQLite
+database and the checks run their usual queries against it. mmi.csv is optional, older
+dumps without it get an empty impact.mmi. Each file needs a header row naming the
+columns, e.g. a psql \copy ... WITH CSV HEADER of the table. Boolean columns may be
+written as t/f, true/false or 1/0. Times are compared as text so a time column should be
+as psql writes it in UTC, e.g. 2024-01-02 15:04:05+00.
+*/
+
+var dumpTables = []string{"pga", "pgv", "mmi", "source"}
+
+var dumpColumnName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// dumpColumnTypes are the SQLite types for the columns the checks do arithmetic or
+// comparisons on, anything else is loaded as text.
+var dumpColumnTypes = map[string]string{
+        "sourcepk": "INTEGER",
+        "pga": "REAL",
+        "pgv": "REAL",
+        "vertical": "BOOLEAN",
+        "mmi": "INTEGER",
+}
+
+// querier is what the checks need from a database, satisfied by *sql.DB for both the
+// hazard database and an in memory dump, and by *sql.Conn with -trace-events. It's the
+// checks' only way to the rows so the tests hand them a go-sqlmock database instead.
+type querier interface {
+        QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+        QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func openDump(dumpDir string) (*sql.DB, error) {
+        db, err := sql.Open("sqlite", ":memory:")
+        if err != nil {
+                return nil, err
+        }
+
+        // Every connection to :memory: is a different database so keep the one connection
+        // open for the whole run.
+        db.SetMaxOpenConns(1)
+        db.SetMaxIdleConns(1)
+
+        if _, err := db.Exec(`ATTACH DATABASE ':memory:' AS impact`); err != nil {
+                db.Close()
+                return nil, err
+        }
+
+        for _, t := range dumpTables {
+                path := filepath.Join(dumpDir, t + ".csv")
+
+                if _, err := os.Stat(path); t == "mmi" && os.IsNotExist(err) {
+                        if _, err := db.Exec(`CREATE TABLE impact.mmi (sourcepk INTEGER, mmi INTEGER, time TEXT)`); err != nil {
+                                db.Close()
+                                return nil, err
+                        }
+                        trace.Printf("No mmi.csv in %s, impact.mmi is empty", dumpDir)
+                        continue
+                }
+
+                n, err := loadDumpTable(db, t, path)
+                if err != nil {
+                        db.Close()
+                        return nil, fmt.Errorf("loading %s.csv: %w", t, err)
+                }
+                trace.Printf("Loaded %d rows into impact.%s from %s", n, t, dumpDir)
+        }
+
+        return db, nil
+}
+
+func loadDumpTable(db *sql.DB, table, path string) (int, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return 0, err
+        }
+        defer f.Close()
+
+        r := csv.NewReader(f)
+
+        header, err := r.Read()
+        if err != nil {
+                return 0, err
+        }
+
+        var cols, params []string
+        hasNetwork, hasTime := false, false
+        for i, h := range header {
+                h = strings.ToLower(strings.TrimSpace(h))
+                if !dumpColumnName.MatchString(h) {
+                        return 0, fmt.Errorf("invalid column name %q", h)
+                }
+                header[i] = h
+
+                switch h {
+                case "network":
+                        hasNetwork = true
+                case "time":
+                        hasTime = true
+                }
+                typ, ok := dumpColumnTypes[h]
+                if !ok {
+                        typ = "TEXT"
+                }
+                cols = append(cols, h + " " + typ)
+                params = append(params, "$" + strconv.Itoa(i+1))
+        }
+
+        // -partition-by network reads source.network, it's empty if not in the dump.
+        if table == "source" && !hasNetwork {
+                cols = append(cols, "network TEXT")
+        }
+        // -from, -to and -window compare the values' time, it's NULL if not in the dump.
+        if table != "source" && !hasTime {
+                cols = append(cols, "time TEXT")
+        }
+
+        _, err = db.Exec(fmt.Sprintf("CREATE TABLE impact.%s (%s)", table, strings.Join(cols, ", ")))
+        if err != nil {
+                return 0, err
+        }
+
+        tx, err := db.Begin()
+        if err != nil {
+                return 0, err
+        }
+        defer tx.Rollback()
+
+        stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO impact.%s (%s) VALUES (%s)", table, strings.Join(header, ", "), strings.Join(params, ", ")))
+        if err != nil {
+                return 0, err
+        }
+        defer stmt.Close()
+
+        var n int
+        for {
+                rec, err := r.Read()
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        return n, err
+                }
+
+                args := make([]interface{}, len(rec))
+                for i, v := range rec {
+                        args[i], err = dumpValue(header[i], v)
+                        if err != nil {
+                                return n, fmt.Errorf("line %d: %w", n+2, err)
+                        }
+                }
+
+                if _, err := stmt.Exec(args...); err != nil {
+                        return n, fmt.Errorf("line %d: %w", n+2, err)
+                }
+                n++
+        }
+
+        return n, tx.Commit()
+}
+
+// dumpValue converts a CSV field for insertion, empty fields are NULL. blacklist is
+// written by the checks as is so it's kept as true/false text the way Postgres gives it.
+func dumpValue(col, v string) (interface{}, error) {
+        if v == "" {
+                return nil, nil
+        }
+
+        if col == "blacklist" {
+                switch strings.ToLower(v) {
+                case "t", "true", "1":
+                        return "true", nil
+                case "f", "false", "0":
+                        return "false", nil
+                }
+                return v, nil
+        }
+
+        if dumpColumnTypes[col] != "BOOLEAN" {
+                return v, nil
+        }
+
+        switch strings.ToLower(v) {
+        case "t", "true", "1":
+                return 1, nil
+        case "f", "false", "0":
+                return 0, nil
+        }
+        return nil, fmt.Errorf("invalid boolean %q for %s", v, col)
+}
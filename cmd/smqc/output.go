@@ -0,0 +1,573 @@
+package main
+
+import (
+        "bytes"
+        "context"
+        "database/sql"
+        "encoding/csv"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+const stationNetworkSQL = `
+SELECT
+        station,
+        COALESCE(network, '')
+FROM
+	impact.source`
+
+// checkOutput hands out the file a check's rows are appended to. Normally that's a single
+// <name>.csv, or <name>.jsonl or <name>.geojson for the check's -format, in dir but with
+// -partition-by network each network gets its own subdirectory so teams can be given
+// access to only their stations. Output that isn't about a station is asked for with
+// station "" and is never partitioned.
+type checkOutput struct {
+        name string
+        format string
+        columns []column
+        files map[string]io.Writer
+        csv map[string]*csv.Writer
+        buffered []outputRow
+        rows []outputRow
+
+        // With -dedup, the rows already written to each file this hour and how many were
+        // skipped for it.
+        written map[string]map[string]bool
+        duplicates int
+
+        // A GeoJSON file is a single FeatureCollection so its rows are kept for flush to
+        // replace the file with.
+        features map[string][]outputRow
+
+        // count is the rows written.
+        count int
+}
+
+// column describes a field of a check's output, the kind is used to type the column in
+// formats that have types.
+type column struct {
+        name string
+        kind columnKind
+}
+
+type columnKind int
+
+const (
+        textColumn columnKind = iota
+        intColumn
+        floatColumn
+)
+
+// outputRow is a row of output. value is the check's main value (count, ratio) for -sort
+// and fields are string, int or float64 to match the columns.
+type outputRow struct {
+        station string
+        value float64
+        fields []interface{}
+}
+
+// newCheckOutput is the output for a check's columns, every row also gets a schema_version,
+// and with -sources the source before it.
+func newCheckOutput(name string, columns ...column) *checkOutput {
+        columns = columns[:len(columns):len(columns)]
+        if len(sources) > 0 {
+                columns = append(columns, column{"source", textColumn})
+        }
+
+        return &checkOutput{
+                name: name,
+                format: formatFor(name),
+                columns: append(columns, column{"schema_version", intColumn}),
+                files: map[string]io.Writer{},
+                csv: map[string]*csv.Writer{},
+                written: map[string]map[string]bool{},
+                features: map[string][]outputRow{},
+        }
+}
+
+func (o *checkOutput) path(station string) string {
+        path := filepath.Join(dir, o.name + "." + o.format)
+
+        if partitionBy != "" && station != "" {
+                path = filepath.Join(dir, stationGroup(partitionBy, station), o.name + "." + o.format)
+        }
+
+        return path
+}
+
+// outputSink is where the checks' rows end up. Open opens path for appending and says
+// whether it's empty, a CSV file's header is header.
+type outputSink interface {
+        Open(path string, header []string) (io.Writer, bool, error)
+}
+
+// sink is the files in the output directory, the tests replace it to write the output to
+// buffers.
+var sink outputSink = fileSink{}
+
+// fileSink opens the output files, rotating a file first if it's due or a CSV file's header
+// isn't header.
+type fileSink struct{}
+
+func (fileSink) Open(path string, header []string) (io.Writer, bool, error) {
+        if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+                return nil, false, err
+        }
+        if err := rotateOutput(path, header); err != nil {
+                return nil, false, fmt.Errorf("rotating %s: %w", path, err)
+        }
+
+        f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+        if err != nil {
+                return nil, false, err
+        }
+
+        info, err := f.Stat()
+        if err != nil {
+                f.Close()
+                return nil, false, err
+        }
+
+        return f, info.Size() == 0, nil
+}
+
+func (o *checkOutput) open(path string) (io.Writer, error) {
+        if f, ok := o.files[path]; ok {
+                return f, nil
+        }
+
+        var header []string
+        if o.format == "csv" {
+                header = csvHeader(o.columns)
+        }
+
+        f, empty, err := sink.Open(path, header)
+        if err != nil {
+                return nil, err
+        }
+        o.files[path] = f
+
+        if o.format == "csv" {
+                w := csv.NewWriter(f)
+                o.csv[path] = w
+
+                // A new file gets a header so the columns don't have to be remembered,
+                // appending to an existing one doesn't repeat it.
+                if empty {
+                        w.Write(header)
+                }
+        }
+
+        if dedup && !empty {
+                keys, err := readDedupKeys(path, o.columns)
+                if err != nil {
+                        return nil, fmt.Errorf("reading %s for -dedup: %w", path, err)
+                }
+                o.written[path] = keys
+        } else if dedup {
+                o.written[path] = map[string]bool{}
+        }
+
+        return f, nil
+}
+
+// write appends a row to the station's file. With -sort the row is buffered and only
+// written, in order, by flush.
+func (o *checkOutput) write(station string, value float64, fields ...interface{}) error {
+        fields = fields[:len(fields):len(fields)]
+        if len(sources) > 0 {
+                fields = append(fields, currentSource)
+        }
+        r := outputRow{station, value, append(fields, outputSchemaVersion)}
+
+        if sortField != "" {
+                o.buffered = append(o.buffered, r)
+                return nil
+        }
+
+        return o.writeRow(r)
+}
+
+// writeRow writes r to the station's file, keeping it for the Arrow output, -results-db,
+// -s3-bucket, -influx-url and -results-webhook too.
+func (o *checkOutput) writeRow(r outputRow) error {
+        if r.station != "" {
+                recordValue(o.name, r.station, r.value)
+        }
+
+        if arrowOut != "" || parquetOut != "" || resultsDB != nil || s3Bucket != "" || influxURL != "" || resultsWebhook != "" || dryRun {
+                o.rows = append(o.rows, r)
+        }
+        if s3Only || dryRun {
+                o.count++
+                return nil
+        }
+
+        path := o.path(r.station)
+        if o.format == "geojson" {
+                o.features[path] = append(o.features[path], r)
+                o.count++
+                return nil
+        }
+
+        f, err := o.open(path)
+        if err != nil {
+                return err
+        }
+
+        if dedup && o.duplicate(path, r) {
+                o.duplicates++
+                return nil
+        }
+
+        // Every check goes through here so a new check only has to describe its columns.
+        if w, ok := o.csv[path]; ok {
+                line := make([]string, len(r.fields))
+                for i, v := range r.fields {
+                        line[i] = formatField(v)
+                }
+                if err := w.Write(line); err != nil {
+                        return err
+                }
+                o.count++
+                return nil
+        }
+
+        line, err := o.marshalJSON(r)
+        if err != nil {
+                return err
+        }
+        if _, err := f.Write(line); err != nil {
+                return err
+        }
+        o.count++
+
+        return nil
+}
+
+// duplicate reports whether r was already written to path this hour, remembering it if not.
+func (o *checkOutput) duplicate(path string, r outputRow) bool {
+        fields := make([]string, len(r.fields))
+        for i, v := range r.fields {
+                fields[i] = formatField(v)
+        }
+
+        k := dedupKey(o.columns, fields)
+        if k == "" {
+                return false
+        }
+        if o.written[path][k] {
+                return true
+        }
+        o.written[path][k] = true
+        return false
+}
+
+// marshalJSON renders r as a line of -format jsonl.
+func (o *checkOutput) marshalJSON(r outputRow) ([]byte, error) {
+        if len(r.fields) != len(o.columns) {
+                return nil, fmt.Errorf("%s row has %d fields, expected %d", o.name, len(r.fields), len(o.columns))
+        }
+
+        // Built by hand rather than from a map so the fields keep the column order.
+        var b bytes.Buffer
+        b.WriteByte('{')
+        for i, v := range r.fields {
+                if i > 0 {
+                        b.WriteByte(',')
+                }
+
+                name, _ := json.Marshal(o.columns[i].name)
+                b.Write(name)
+                b.WriteByte(':')
+
+                // Numbers stay numbers, with the same -float-precision as the CSV.
+                if f, ok := v.(float64); ok {
+                        v = json.Number(formatFloat(f))
+                }
+                value, err := json.Marshal(v)
+                if err != nil {
+                        return nil, fmt.Errorf("%s column %s: %w", o.name, o.columns[i].name, err)
+                }
+                b.Write(value)
+        }
+        b.WriteString("}\n")
+
+        return b.Bytes(), nil
+}
+
+// flush writes out any rows buffered by -sort, the GeoJSON files, and the run's rows with
+// -arrow, -results-db, -s3-bucket, -influx-url and -results-webhook.
+func (o *checkOutput) flush() error {
+        rows := o.buffered
+        o.buffered = nil
+
+        sort.SliceStable(rows, func(i, j int) bool {
+                a, b := rows[i], rows[j]
+                if sortDesc {
+                        a, b = b, a
+                }
+                if sortField == "station" {
+                        return a.station < b.station
+                }
+                return a.value < b.value
+        })
+
+        for _, r := range rows {
+                if err := o.writeRow(r); err != nil {
+                        return err
+                }
+        }
+
+        for path, w := range o.csv {
+                w.Flush()
+                if err := w.Error(); err != nil {
+                        return fmt.Errorf("writing %s: %w", path, err)
+                }
+        }
+
+        if dryRun {
+                return printDryRunRows(o)
+        }
+
+        // A run with no rows still replaces the last run's.
+        if o.format == "geojson" && len(o.features) == 0 && !s3Only {
+                o.features[o.path("")] = nil
+        }
+        for path, rows := range o.features {
+                if err := writeGeoJSON(path, o.columns, rows); err != nil {
+                        return fmt.Errorf("writing %s: %w", path, err)
+                }
+        }
+        o.features = map[string][]outputRow{}
+
+        if arrowOut != "" {
+                if err := writeArrow(o); err != nil {
+                        return fmt.Errorf("writing arrow: %w", err)
+                }
+        }
+
+        if parquetOut != "" {
+                if err := writeParquet(o); err != nil {
+                        return fmt.Errorf("writing -parquet: %w", err)
+                }
+        }
+
+        if resultsDB != nil && len(o.rows) > 0 {
+                if err := writeResults(o); err != nil {
+                        return fmt.Errorf("writing -results-db: %w", err)
+                }
+        }
+
+        // A run with no rows still gets an object, so a missing one means it didn't run.
+        if s3Bucket != "" {
+                if err := uploadS3(o); err != nil {
+                        return fmt.Errorf("uploading to -s3-bucket: %w", err)
+                }
+        }
+
+        if influxURL != "" && len(o.rows) > 0 {
+                writeInflux(o)
+        }
+
+        if resultsWebhook != "" {
+                sendResultsWebhooks(o)
+        }
+
+        return nil
+}
+
+// parseSort reads -sort as field[:asc|desc].
+func parseSort(s string) (string, bool, error) {
+        if s == "" {
+                return "", false, nil
+        }
+
+        field, direction, _ := strings.Cut(s, ":")
+        if field != "station" && field != "value" {
+                return "", false, fmt.Errorf("unknown -sort field %q, expected station or value", field)
+        }
+
+        switch direction {
+        case "", "asc":
+                return field, false, nil
+        case "desc":
+                return field, true, nil
+        }
+        return "", false, fmt.Errorf("unknown -sort direction %q, expected asc or desc", direction)
+}
+
+func (o *checkOutput) Close() {
+        if o.duplicates > 0 {
+                trace.Printf("%s: skipped %d rows already written this hour", o.name, o.duplicates)
+        }
+
+        // Anything flush didn't get to because the check failed part way.
+        for _, w := range o.csv {
+                w.Flush()
+        }
+
+        for _, f := range o.files {
+                if c, ok := f.(io.Closer); ok {
+                        c.Close()
+                }
+        }
+}
+
+func formatField(v interface{}) string {
+        switch v := v.(type) {
+        case string:
+                return v
+        case int:
+                return strconv.Itoa(v)
+        case float64:
+                return formatFloat(v)
+        }
+        return fmt.Sprint(v)
+}
+
+// formatFloat renders every numeric value in the output with the same -float-precision.
+func formatFloat(v float64) string {
+        return strconv.FormatFloat(v, 'f', floatPrecision, 64)
+}
+
+// loadStationNetworks maps each station to its network for partitioning the output.
+func loadStationNetworks(db querier) (map[string]string, error) {
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        rows, err := db.QueryContext(ctx, stationNetworkSQL)
+        if err != nil {
+                return nil, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        networks := map[string]string{}
+
+        var station, network string
+        for rows.Next() {
+                if err := rows.Scan(&station, &network); err != nil {
+                        return nil, err
+                }
+                networks[station] = network
+        }
+
+        return networks, rows.Err()
+}
+
+// dbTimestamp is the CURRENT_TIMESTAMP a query selects. Postgres gives a time.Time in the
+// session's time zone and a -dump-dir SQLite database gives UTC text, either way it's
+// written as RFC3339 in UTC. With bounded queries it's the end of the window instead.
+type dbTimestamp struct {
+        time.Time
+}
+
+func (t *dbTimestamp) Scan(src interface{}) error {
+        if !queryTo.IsZero() {
+                t.Time = queryTo
+                return nil
+        }
+
+        var s string
+        switch v := src.(type) {
+        case time.Time:
+                t.Time = v
+                return nil
+        case string:
+                s = v
+        case []byte:
+                s = string(v)
+        default:
+                return fmt.Errorf("unsupported timestamp type %T", src)
+        }
+
+        at, err := parseHistoryTime(s)
+        if err != nil {
+                return err
+        }
+        t.Time = at
+        return nil
+}
+
+func (t dbTimestamp) String() string {
+        return t.UTC().Format(time.RFC3339)
+}
+
+var errSkipRow = errors.New("skip row")
+
+// rowScanner scans a check's rows keeping count of them. A row that fails to scan fails
+// the check unless -continue-on-scan-error is set, in which case it's logged and
+// errSkipRow is returned so the rest of the result can still be written.
+type rowScanner struct {
+        check string
+        row int
+        skipped int
+}
+
+func (s *rowScanner) scan(rows *sql.Rows, dest ...interface{}) error {
+        s.row++
+
+        err := rows.Scan(dest...)
+        if err == nil {
+                return nil
+        }
+
+        if !continueOnScanError {
+                return fmt.Errorf("scanning row %d: %w", s.row, err)
+        }
+
+        s.skipped++
+        trace.Printf("WARNING: %s: skipping row %d: %s", s.check, s.row, err)
+
+        return errSkipRow
+}
+
+// end returns the error, if any, that stopped rows early. The check's output up to then
+// has already been written so the log says it's incomplete.
+func (s *rowScanner) end(ctx context.Context, rows *sql.Rows) error {
+        err := rows.Err()
+        if err == nil {
+                // A -dump-dir SQLite query that's cancelled ends its rows without an error.
+                err = ctx.Err()
+        }
+        return s.ended(ctx, err)
+}
+
+// ended is end for rows stopped by err.
+func (s *rowScanner) ended(ctx context.Context, err error) error {
+        if err == nil {
+                return nil
+        }
+
+        trace.Printf("WARNING: %s: results ended early after %d rows, this hour's output is incomplete", s.check, s.row)
+
+        return fmt.Errorf("reading row %d: %w", s.row+1, queryError(ctx, err))
+}
+
+// skip is scan's -continue-on-scan-error for the smqc package's checks, which scan their
+// own rows, as Options.SkipScanError.
+func (s *rowScanner) skip(check string, row int, err error) bool {
+        s.row = row
+        if !continueOnScanError {
+                return false
+        }
+
+        s.skipped++
+        trace.Printf("WARNING: %s: skipping row %d: %s", check, row, err)
+
+        return true
+}
+
+func (s *rowScanner) report() {
+        if s.skipped > 0 {
+                trace.Printf("WARNING: %s: skipped %d of %d rows that failed to scan", s.check, s.skipped, s.row)
+        }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+        "fmt"
+        "os"
+        "path/filepath"
+        "strings"
+        "time"
+
+        "github.com/apache/arrow-go/v18/parquet"
+        "github.com/apache/arrow-go/v18/parquet/compress"
+        "github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+/*
+Parquet output for long-term analytics. With -parquet DIR each check's rows for the run are
+written, Snappy compressed, to
+
+        DIR/<check>/date=<run date>/<check>-<run time>.parquet
+
+with a column per output column typed as for -arrow, so each check is one table with one
+schema and the directories are Hive style partitions that Athena, Spark or DuckDB pick up
+as a date column. With -sources the file name has the source after the check, the rows
+already have a source column and a partition of the same name would clash with it. A check
+with no rows writes no file. Each file is written under a temporary name and renamed, so a
+job reading DIR, or syncing it to S3, never sees half of one.
+*/
+
+// parquetPath is where the check's file for the run goes.
+func parquetPath(check string) string {
+        name := check
+        if currentSource != "" {
+                name += "-" + currentSource
+        }
+        at := strings.ReplaceAll(runStart.Format(time.RFC3339), ":", "")
+
+        return filepath.Join(parquetOut, check, "date=" + runStart.Format("2006-01-02"), fmt.Sprintf("%s-%s.parquet", name, at))
+}
+
+func writeParquet(o *checkOutput) error {
+        if len(o.columns) == 0 || len(o.rows) == 0 {
+                return nil
+        }
+
+        rec, err := arrowRecord(o)
+        if err != nil {
+                return err
+        }
+        defer rec.Release()
+
+        path := parquetPath(o.name)
+        if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+                return err
+        }
+
+        tmp := path + ".tmp"
+        f, err := os.Create(tmp)
+        if err != nil {
+                return err
+        }
+        defer os.Remove(tmp)
+
+        props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+        w, err := pqarrow.NewFileWriter(rec.Schema(), f, props, pqarrow.DefaultWriterProps())
+        if err != nil {
+                f.Close()
+                return err
+        }
+        if err := w.Write(rec); err != nil {
+                w.Close()
+                return err
+        }
+        // Closing the writer writes the footer and closes f.
+        if err := w.Close(); err != nil {
+                return err
+        }
+
+        return os.Rename(tmp, path)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+        "fmt"
+        "strconv"
+        "time"
+
+        "github.com/mabznz/smqc/smqc"
+)
+
+/*
+The same vertical versus horizontal comparison as ratioDiff but of the maximum PGV, some
+stations show noise on the velocity channels before PGA looks wrong. Rows over
+-ratio-alert-threshold count as findings but aren't sent to -alert-webhook.
+*/
+func pgvRatio(db querier, tr *checkTrace) (checkResult, error) {
+        q := smqc.PGVRatioQuery(queryParams("pgvRatio"))
+        recordQueryStats(db, "pgvRatio", q.SQL, q.Args...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        c := newLibraryCheck(db, tr, "pgvRatio")
+        defer c.scan.report()
+
+        results, err := smqc.RatiosFrom(ctx, c, "pgvRatio", q, c.options())
+        if c.err != nil {
+                return checkResult{}, c.error(ctx, err)
+        }
+
+        out := newCheckOutput("pgvRatio", ratioDiffColumns...)
+        defer out.Close()
+
+        resetGauge(pgvRatioGauge)
+
+        var concerns int
+
+        for _, r := range results {
+                if r.Explained && quakeFilter == "exclude" {
+                        continue
+                }
+                blacklist := strconv.FormatBool(r.Blacklist)
+                if !r.Explained {
+                        flagStation("pgvRatio", r.Station, blacklist)
+                }
+                pgvRatioGauge.WithLabelValues(r.Station, blacklist, currentSource).Set(r.Ratio)
+
+                if !r.Explained && r.Ratio > stationThreshold("pgvRatio", r.Station, ratioAlertThreshold) && !r.Blacklist {
+                        concerns++
+                        overThreshold("pgvRatio", r.Station)
+                }
+
+                done := tr.writing()
+                err := out.write(r.Station, r.Ratio, r.Timestamp.Format(time.RFC3339), r.Station, blacklist, r.Ratio, r.MaxVertical, r.MaxHorizontal)
+                done()
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+        if err != nil {
+                return checkResult{}, c.error(ctx, err)
+        }
+
+        done := tr.writing()
+        defer done()
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+        "bufio"
+        "fmt"
+        "math"
+        "net/http"
+        "net/url"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+/*
+A real earthquake puts stations at the top of the noise counts and ratios without anything
+being wrong with them. With -quake-filter the events of the hour are fetched from the FDSN
+event service at -quake-url and a noiseCount, ratioDiff or pgvRatio row is explained by an
+event of at least -quake-min-magnitude within -quake-radius km of the station. A station
+without -metadata-file coordinates is taken to be within range of every event.
+
+An explained row isn't flagged or counted as a finding. With annotate it's written as usual
+and also to quakeExplained.csv as
+
+        timestamp,station,check,event,magnitude,distance_km
+
+with exclude it's left out of the check's file. Fetching events is best effort, if the
+service can't be reached the run goes ahead without them.
+*/
+
+type quakeEvent struct {
+        id string
+        latitude float64
+        longitude float64
+        magnitude float64
+}
+
+var quakeExplainedColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"check", textColumn},
+        {"event", textColumn},
+        {"magnitude", floatColumn},
+        {"distance_km", textColumn},
+}
+
+var quakes = struct {
+        sync.Mutex
+        events []quakeEvent
+        explained [][]interface{}
+}{}
+
+// loadQuakes fetches the events for the run's hour.
+func loadQuakes(end time.Time) {
+        events, err := fetchQuakes(end.Add(-time.Hour), end, quakeMinMagnitude)
+
+        quakes.Lock()
+        defer quakes.Unlock()
+
+        quakes.events, quakes.explained = nil, nil
+        if err != nil {
+                trace.Printf("WARNING: fetching earthquakes from -quake-url, not filtering for them this run: %s", err)
+                return
+        }
+        quakes.events = events
+
+        if len(events) > 0 {
+                trace.Printf("%d earthquakes of magnitude %s or more in the last hour", len(events), strconv.FormatFloat(quakeMinMagnitude, 'f', -1, 64))
+        }
+}
+
+func fetchQuakes(start, end time.Time, minMagnitude float64) ([]quakeEvent, error) {
+        q := url.Values{}
+        q.Set("starttime", start.UTC().Format("2006-01-02T15:04:05"))
+        q.Set("endtime", end.UTC().Format("2006-01-02T15:04:05"))
+        q.Set("minmagnitude", strconv.FormatFloat(minMagnitude, 'f', -1, 64))
+        q.Set("format", "text")
+
+        client := &http.Client{Timeout: 10 * time.Second}
+
+        res, err := client.Get(quakeURL + "?" + q.Encode())
+        if err != nil {
+                return nil, err
+        }
+        defer res.Body.Close()
+
+        // No events is 204 from an FDSN service.
+        if res.StatusCode == http.StatusNoContent {
+                return nil, nil
+        }
+        if res.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("event service returned %s", res.Status)
+        }
+
+        var events []quakeEvent
+
+        // EventID|Time|Latitude|Longitude|Depth/km|Author|Catalog|Contributor|ContributorID|MagType|Magnitude|...
+        scanner := bufio.NewScanner(res.Body)
+        for line := 1; scanner.Scan(); line++ {
+                text := strings.TrimSpace(scanner.Text())
+                if text == "" || strings.HasPrefix(text, "#") {
+                        continue
+                }
+
+                f := strings.Split(text, "|")
+                if len(f) < 11 {
+                        return nil, fmt.Errorf("line %d: expected at least 11 fields, got %d", line, len(f))
+                }
+
+                e := quakeEvent{id: strings.TrimSpace(f[0])}
+                for _, v := range []struct {
+                        s string
+                        f *float64
+                }{{f[2], &e.latitude}, {f[3], &e.longitude}, {f[10], &e.magnitude}} {
+                        if *v.f, err = strconv.ParseFloat(strings.TrimSpace(v.s), 64); err != nil {
+                                return nil, fmt.Errorf("line %d: invalid number %q", line, v.s)
+                        }
+                }
+
+                events = append(events, e)
+        }
+
+        return events, scanner.Err()
+}
+
+// explainedByQuake says whether a row of check for station is explained by an earthquake,
+// keeping it for quakeExplained.csv if so. It's false without -quake-filter.
+func explainedByQuake(check, station, timestamp string) bool {
+        if quakeFilter == "off" {
+                return false
+        }
+
+        quakes.Lock()
+        defer quakes.Unlock()
+
+        e, distance, ok := quakeNear(station, quakes.events)
+        if ok {
+                quakes.explained = append(quakes.explained, []interface{}{timestamp, station, check, e.id, e.magnitude, distance})
+        }
+        return ok
+}
+
+// quakeNear gives the first of events within -quake-radius of station and how far away it
+// is, "" for a station without coordinates, which every event is near.
+func quakeNear(station string, events []quakeEvent) (quakeEvent, string, bool) {
+        m, located := metadata[station]
+        located = located && m.Latitude != nil
+
+        for _, e := range events {
+                if !located {
+                        return e, "", true
+                }
+                km := distanceKm(*m.Latitude, *m.Longitude, e.latitude, e.longitude)
+                if km <= quakeRadius {
+                        return e, strconv.FormatFloat(km, 'f', 0, 64), true
+                }
+        }
+
+        return quakeEvent{}, "", false
+}
+
+// writeQuakeExplained appends the rows explained by earthquakes this run with -quake-filter
+// annotate.
+func writeQuakeExplained() error {
+        quakes.Lock()
+        defer quakes.Unlock()
+
+        if quakeFilter != "annotate" || len(quakes.explained) == 0 {
+                return nil
+        }
+
+        out := newCheckOutput("quakeExplained", quakeExplainedColumns...)
+        defer out.Close()
+
+        for _, fields := range quakes.explained {
+                if err := out.write(fields[1].(string), fields[4].(float64), fields...); err != nil {
+                        return err
+                }
+        }
+
+        return out.flush()
+}
+
+// distanceKm is the great circle distance between two points.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+        const earthRadiusKm = 6371
+
+        rad := func(d float64) float64 { return d * math.Pi / 180 }
+
+        dLat := rad(lat2 - lat1)
+        dLon := rad(lon2 - lon1)
+        a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+        return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
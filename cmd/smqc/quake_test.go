@@ -0,0 +1,91 @@
+package main
+
+import (
+        "net/http"
+        "net/http/httptest"
+        "reflect"
+        "strings"
+        "testing"
+        "time"
+)
+
+func TestFetchQuakes(t *testing.T) {
+        for _, c := range []struct {
+                name string
+                status int
+                body string
+                events []quakeEvent
+                err string
+        }{
+                {"events", http.StatusOK, `#EventID|Time|Latitude|Longitude|Depth/km|Author|Catalog|Contributor|ContributorID|MagType|Magnitude|MagAuthor|EventLocationName|EventType
+2024p001234|2024-01-02T14:12:03.123Z|-41.51|174.03|12.3|||||ML|5.1|||earthquake
+
+2024p001240|2024-01-02T14:40:00Z|-38.9|175.9|5|||||ML|4.0|||earthquake
+`, []quakeEvent{{"2024p001234", -41.51, 174.03, 5.1}, {"2024p001240", -38.9, 175.9, 4.0}}, ""},
+                {"none", http.StatusNoContent, "", nil, ""},
+                {"error", http.StatusServiceUnavailable, "", nil, "event service returned 503"},
+                {"short line", http.StatusOK, "2024p001234|2024-01-02T14:12:03Z|-41.51|174.03\n", nil, "line 1: expected at least 11 fields, got 4"},
+                {"bad magnitude", http.StatusOK, "2024p001234|2024-01-02T14:12:03Z|-41.51|174.03|12|||||ML|big\n", nil, `line 1: invalid number "big"`},
+        } {
+                t.Run(c.name, func(t *testing.T) {
+                        var query string
+                        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                                query = r.URL.RawQuery
+                                w.WriteHeader(c.status)
+                                w.Write([]byte(c.body))
+                        }))
+                        defer server.Close()
+
+                        url := quakeURL
+                        quakeURL = server.URL
+                        t.Cleanup(func() { quakeURL = url })
+
+                        end := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+                        events, err := fetchQuakes(end.Add(-time.Hour), end, 4)
+                        if c.err != "" {
+                                if err == nil || !strings.Contains(err.Error(), c.err) {
+                                        t.Errorf("expected an error with %q, got %v", c.err, err)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if !reflect.DeepEqual(events, c.events) {
+                                t.Errorf("expected %+v, got %+v", c.events, events)
+                        }
+                        if expected := "endtime=2024-01-02T15%3A00%3A00&format=text&minmagnitude=4&starttime=2024-01-02T14%3A00%3A00"; query != expected {
+                                t.Errorf("expected the query %s, got %s", expected, query)
+                        }
+                })
+        }
+}
+
+func TestQuakeNear(t *testing.T) {
+        lat, lon := -41.28, 174.78
+        stations, radius := metadata, quakeRadius
+        metadata, quakeRadius = map[string]stationMetadata{"WEL": {Station: "WEL", Latitude: &lat, Longitude: &lon}}, 200
+        t.Cleanup(func() { metadata, quakeRadius = stations, radius })
+
+        far := quakeEvent{"far", -36.85, 174.76, 5}
+        near := quakeEvent{"near", -41.51, 174.03, 5}
+
+        for _, c := range []struct {
+                station string
+                events []quakeEvent
+                event string
+                distance string
+                ok bool
+        }{
+                {"WEL", []quakeEvent{far, near}, "near", "68", true},
+                {"WEL", []quakeEvent{far}, "", "", false},
+                // Without coordinates every event is near.
+                {"TFSS", []quakeEvent{far}, "far", "", true},
+                {"TFSS", nil, "", "", false},
+        } {
+                e, distance, ok := quakeNear(c.station, c.events)
+                if e.id != c.event || distance != c.distance || ok != c.ok {
+                        t.Errorf("%s %v: expected %q %q %v, got %q %q %v", c.station, c.events, c.event, c.distance, c.ok, e.id, distance, ok)
+                }
+        }
+}
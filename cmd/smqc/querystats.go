@@ -0,0 +1,90 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "sync"
+        "time"
+)
+
+/*
+With -query-stats each check's query is run through EXPLAIN and the planner's estimated
+cost and rows are appended to queryStats.csv, so over time we can see when a check needs
+an index or the tables need vacuuming.
+
+        timestamp,check,total_cost,plan_rows,actual_rows,execution_ms
+
+Actual rows and execution time need EXPLAIN ANALYZE, which runs the query a second time,
+so they're only filled in with -query-stats-analyze.
+*/
+
+var queryStatsMu sync.Mutex
+
+var queryStatsColumns = []column{
+        {"timestamp", textColumn},
+        {"check", textColumn},
+        {"total_cost", floatColumn},
+        {"plan_rows", intColumn},
+        {"actual_rows", textColumn},
+        {"execution_ms", textColumn},
+}
+
+type explainPlan struct {
+        Plan struct {
+                TotalCost float64 `json:"Total Cost"`
+                PlanRows float64 `json:"Plan Rows"`
+                ActualRows float64 `json:"Actual Rows"`
+        } `json:"Plan"`
+        ExecutionTime float64 `json:"Execution Time"`
+}
+
+// recordQueryStats is best effort, a failure is logged but doesn't fail the check.
+func recordQueryStats(db querier, check string, query string, args ...interface{}) {
+        if !queryStats {
+                return
+        }
+
+        explain := "EXPLAIN (FORMAT JSON) "
+        if queryStatsAnalyze {
+                explain = "EXPLAIN (ANALYZE, FORMAT JSON) "
+        }
+
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        var b []byte
+        err := queryError(ctx, db.QueryRowContext(ctx, explain + query, args...).Scan(&b))
+        if err != nil {
+                trace.Printf("WARNING: explaining %s query: %s", check, err)
+                return
+        }
+
+        var plans []explainPlan
+        if err := json.Unmarshal(b, &plans); err != nil || len(plans) == 0 {
+                trace.Printf("WARNING: reading %s query plan: %v", check, err)
+                return
+        }
+        p := plans[0]
+
+        // Every check appends to the one file, and they run at the same time.
+        queryStatsMu.Lock()
+        defer queryStatsMu.Unlock()
+
+        out := newCheckOutput("queryStats", queryStatsColumns...)
+        defer out.Close()
+
+        actualRows, executionMs := "", ""
+        if queryStatsAnalyze {
+                actualRows = fmt.Sprintf("%.0f", p.Plan.ActualRows)
+                executionMs = formatFloat(p.ExecutionTime)
+        }
+
+        err = out.write("", p.Plan.TotalCost, time.Now().UTC().Format(time.RFC3339), check,
+                p.Plan.TotalCost, int(p.Plan.PlanRows), actualRows, executionMs)
+        if err == nil {
+                err = out.flush()
+        }
+        if err != nil {
+                trace.Printf("WARNING: writing query stats: %s", err)
+        }
+}
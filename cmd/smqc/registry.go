@@ -0,0 +1,101 @@
+package main
+
+import (
+        "bufio"
+        "os"
+        "path/filepath"
+        "strings"
+        "time"
+)
+
+/*
+The run registry remembers which hourly windows have already been processed so a scheduler
+misfire running twice in the same hour doesn't append the same window to the files twice.
+It's a text file in the output directory with one RFC3339 window start per line.
+*/
+
+const registryFile = "runRegistry.txt"
+
+// runWindow is the hour the hazard database summaries currently cover.
+func runWindow(now time.Time) time.Time {
+        return now.UTC().Truncate(time.Hour)
+}
+
+func readRegistry() ([]time.Time, error) {
+        f, err := os.Open(filepath.Join(dir, registryFile))
+        if os.IsNotExist(err) {
+                return nil, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        var windows []time.Time
+
+        scanner := bufio.NewScanner(f)
+        for scanner.Scan() {
+                line := strings.TrimSpace(scanner.Text())
+                if line == "" {
+                        continue
+                }
+
+                w, err := time.Parse(time.RFC3339, line)
+                if err != nil {
+                        trace.Printf("WARNING: ignoring bad run registry entry %q", line)
+                        continue
+                }
+                windows = append(windows, w)
+        }
+
+        return windows, scanner.Err()
+}
+
+// skipWindow logs a window that's already been processed and says whether -duplicate-run
+// means the run should be skipped.
+func skipWindow(windows []time.Time, window time.Time) bool {
+        if !processed(windows, window) {
+                return false
+        }
+
+        if duplicateRun == "skip" {
+                trace.Printf("Window %s has already been processed, skipping run", window.Format(time.RFC3339))
+                return true
+        }
+        trace.Printf("WARNING: window %s has already been processed, output will contain duplicate rows", window.Format(time.RFC3339))
+        return false
+}
+
+func processed(windows []time.Time, window time.Time) bool {
+        for _, w := range windows {
+                if w.Equal(window) {
+                        return true
+                }
+        }
+        return false
+}
+
+// recordWindow adds window to the registry, dropping entries older than
+// -registry-retention when it's set.
+func recordWindow(windows []time.Time, window time.Time) error {
+        if !processed(windows, window) {
+                windows = append(windows, window)
+        }
+
+        var keep []string
+
+        for _, w := range windows {
+                if registryRetention > 0 && window.Sub(w) > registryRetention {
+                        continue
+                }
+                keep = append(keep, w.Format(time.RFC3339))
+        }
+
+        path := filepath.Join(dir, registryFile)
+        tmp := path + ".tmp"
+
+        if err := os.WriteFile(tmp, []byte(strings.Join(keep, "\n") + "\n"), 0666); err != nil {
+                return err
+        }
+        return os.Rename(tmp, path)
+}
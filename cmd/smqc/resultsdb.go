@@ -0,0 +1,147 @@
+package main
+
+import (
+        "database/sql"
+        "fmt"
+        "strings"
+        "time"
+
+        "github.com/lib/pq"
+)
+
+/*
+With -results-db every row the checks write is also saved to a smqc.results table, so the
+history can be queried for weekly patterns instead of grepping the appended files. It's
+either a Postgres connection string or sqlite: and the path of a SQLite file.
+
+A row is keyed by the hour it was run for, the check, the station and the row's other text
+columns (component, blacklist and so on), so a second run in the same hour updates the
+rows of the first instead of adding to them. fields is the row as a -format jsonl object.
+*/
+
+const postgresResultsSQL = `
+CREATE SCHEMA IF NOT EXISTS smqc;
+CREATE TABLE IF NOT EXISTS smqc.results (
+        run_window TIMESTAMPTZ NOT NULL,
+        check_name TEXT NOT NULL,
+        station TEXT NOT NULL,
+        row_key TEXT NOT NULL,
+        value DOUBLE PRECISION NOT NULL,
+        fields JSONB NOT NULL,
+        written_at TIMESTAMPTZ NOT NULL,
+        PRIMARY KEY (run_window, check_name, station, row_key)
+)`
+
+const sqliteResultsSQL = `
+CREATE TABLE IF NOT EXISTS smqc.results (
+        run_window TEXT NOT NULL,
+        check_name TEXT NOT NULL,
+        station TEXT NOT NULL,
+        row_key TEXT NOT NULL,
+        value REAL NOT NULL,
+        fields TEXT NOT NULL,
+        written_at TEXT NOT NULL,
+        PRIMARY KEY (run_window, check_name, station, row_key)
+)`
+
+const upsertResultSQL = `
+INSERT INTO smqc.results (run_window, check_name, station, row_key, value, fields, written_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (run_window, check_name, station, row_key) DO UPDATE SET
+        value = excluded.value,
+        fields = excluded.fields,
+        written_at = excluded.written_at`
+
+var resultsDB *sql.DB
+
+// openResultsDB connects to -results-db and creates the table if it isn't there.
+func openResultsDB(dsn string) (*sql.DB, error) {
+        var (
+                db *sql.DB
+                ddl string
+        )
+
+        if path, ok := strings.CutPrefix(dsn, "sqlite:"); ok {
+                var err error
+                db, err = sql.Open("sqlite", ":memory:")
+                if err != nil {
+                        return nil, err
+                }
+
+                // The file is attached so the table can be smqc.results the same as in
+                // Postgres, it's only attached on the one connection.
+                db.SetMaxOpenConns(1)
+                db.SetMaxIdleConns(1)
+
+                if _, err := db.Exec(`ATTACH DATABASE $1 AS smqc`, path); err != nil {
+                        db.Close()
+                        return nil, err
+                }
+                ddl = sqliteResultsSQL
+        } else {
+                connector, err := pq.NewConnector(dsn)
+                if err != nil {
+                        return nil, err
+                }
+                db = sql.OpenDB(connector)
+                ddl = postgresResultsSQL
+        }
+
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        if _, err := db.ExecContext(ctx, ddl); err != nil {
+                db.Close()
+                return nil, queryError(ctx, err)
+        }
+
+        return db, nil
+}
+
+// resultKey is what tells a check's rows for a station apart, its text columns other than
+// the timestamp and station.
+func resultKey(columns []column, fields []interface{}) string {
+        var key []string
+        for i, c := range columns {
+                if c.kind != textColumn || c.name == "timestamp" || c.name == "station" || i >= len(fields) {
+                        continue
+                }
+                key = append(key, c.name + "=" + formatField(fields[i]))
+        }
+        return strings.Join(key, ",")
+}
+
+// writeResults saves a check's rows to -results-db in one transaction.
+func writeResults(o *checkOutput) error {
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        tx, err := resultsDB.BeginTx(ctx, nil)
+        if err != nil {
+                return queryError(ctx, err)
+        }
+        defer tx.Rollback()
+
+        stmt, err := tx.PrepareContext(ctx, upsertResultSQL)
+        if err != nil {
+                return queryError(ctx, err)
+        }
+        defer stmt.Close()
+
+        window := runWindow(runStart).Format(time.RFC3339)
+        now := time.Now().UTC().Format(time.RFC3339)
+
+        for _, r := range o.rows {
+                fields, err := o.marshalJSON(r)
+                if err != nil {
+                        return err
+                }
+
+                key := resultKey(o.columns, r.fields)
+                if _, err := stmt.ExecContext(ctx, window, o.name, r.station, key, r.value, strings.TrimSpace(string(fields)), now); err != nil {
+                        return fmt.Errorf("saving %s row for %s: %w", o.name, r.station, queryError(ctx, err))
+                }
+        }
+
+        return queryError(ctx, tx.Commit())
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+        "context"
+        "database/sql"
+        "database/sql/driver"
+        "errors"
+        "io"
+        "math/rand"
+        "net"
+        "syscall"
+        "time"
+
+        "github.com/lib/pq"
+)
+
+/*
+Retries for the failures an RDS failover or a replica catching up causes, which go away on
+their own a few seconds later. Contacting the database is retried -connect-attempts times
+and each check's query -query-attempts times, waiting -connect-backoff and -query-backoff
+doubling each time with up to half as long again of random jitter, so the checks that all
+failed together don't all retry together. A query is only retried for a connection error
+or a conflict with recovery on the replica, not for a bad query, and the retries are
+within the same -query-timeout.
+*/
+
+// withJitter is d plus a random wait of up to half of d.
+func withJitter(d time.Duration) time.Duration {
+        if d <= 0 {
+                return d
+        }
+        return d + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// transient says whether err is worth running the query again for.
+func transient(err error) bool {
+        var pqErr *pq.Error
+        if errors.As(err, &pqErr) {
+                switch pqErr.Code {
+                // admin_shutdown, crash_shutdown and cannot_connect_now during a failover,
+                // serialization_failure for a query cancelled by a conflict with recovery.
+                case "57P01", "57P02", "57P03", "40001":
+                        return true
+                }
+                // connection_exception
+                return pqErr.Code.Class() == "08"
+        }
+
+        var netErr net.Error
+        if errors.As(err, &netErr) {
+                return true
+        }
+
+        return errors.Is(err, driver.ErrBadConn) ||
+                errors.Is(err, io.EOF) ||
+                errors.Is(err, io.ErrUnexpectedEOF) ||
+                errors.Is(err, syscall.ECONNRESET) ||
+                errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// queryRetry runs a check's query, retrying it when it fails for a transient reason.
+func queryRetry(ctx context.Context, db querier, check, query string, args ...interface{}) (*sql.Rows, error) {
+        if dryRun {
+                printDryRunQuery(ctx, db, check, query, args)
+        }
+
+        backoff := queryBackoff
+        for attempt := 1; ; attempt++ {
+                rows, err := db.QueryContext(ctx, query, args...)
+                if err == nil || attempt >= queryAttempts || !transient(err) || ctx.Err() != nil {
+                        return rows, err
+                }
+
+                wait := withJitter(backoff)
+                trace.Printf("WARNING: %s: query attempt %d of %d: %s, retrying in %s", check, attempt, queryAttempts, err, wait)
+
+                select {
+                case <-ctx.Done():
+                        return nil, err
+                case <-time.After(wait):
+                }
+                backoff *= 2
+        }
+}
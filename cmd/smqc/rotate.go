@@ -0,0 +1,198 @@
+package main
+
+import (
+        "bufio"
+        "compress/gzip"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+Rotation of the files the checks append to, so they don't grow forever. With -rotate
+monthly or daily the first write of a new month or day moves the last one's file aside as
+e.g. noiseCount.2024-01.csv, and with -rotate-size once a file is past that many MB it's
+moved aside as e.g. noiseCount.20240102T150405.csv. With -rotate-gzip the rotated file is
+compressed to noiseCount.2024-01.csv.gz.
+
+A CSV file whose header isn't the check's current columns, from before a column was added,
+is always moved aside the same way so a file never mixes two layouts. Every row also ends
+with the schema_version it was written with, outputSchemaVersion, for parsers reading
+files from different versions.
+
+The history the other checks read, for -blacklist-flapping and -new-stations, includes the
+rotated files.
+*/
+
+// outputSchemaVersion is bumped whenever a check's columns change.
+const outputSchemaVersion = 1
+
+// rotatePeriod is the -rotate period a time is in, "" without -rotate.
+func rotatePeriod(t time.Time) string {
+        switch rotateEvery {
+        case "monthly":
+                return t.UTC().Format("2006-01")
+        case "daily":
+                return t.UTC().Format("2006-01-02")
+        }
+        return ""
+}
+
+// rotateOutput moves path aside if it's from an earlier -rotate period, past -rotate-size
+// or has a different header to the check's columns.
+func rotateOutput(path string, header []string) error {
+        info, err := os.Stat(path)
+        if os.IsNotExist(err) {
+                return nil
+        }
+        if err != nil {
+                return err
+        }
+        if info.Size() == 0 {
+                return nil
+        }
+
+        // By when the file was written rather than the run's window, a -backfill of last
+        // month's hours goes on this month's file.
+        now := time.Now().UTC()
+
+        var suffix string
+        switch period := rotatePeriod(info.ModTime()); {
+        case period != "" && period != rotatePeriod(now):
+                suffix = period
+        case rotateSize > 0 && info.Size() >= int64(rotateSize) << 20:
+                suffix = now.Format("20060102T150405")
+        case header != nil && strings.HasSuffix(path, ".csv"):
+                old, err := readHeader(path)
+                if err != nil {
+                        return err
+                }
+                if old == strings.Join(header, ",") {
+                        return nil
+                }
+                trace.Printf("%s has columns %s, moving it aside for %s", path, old, strings.Join(header, ","))
+                suffix = "schema-" + now.Format("20060102T150405")
+        default:
+                return nil
+        }
+
+        ext := filepath.Ext(path)
+        base := strings.TrimSuffix(path, ext) + "." + suffix
+
+        rotated := base + ext
+        for i := 1; exists(rotated) || exists(rotated + ".gz"); i++ {
+                rotated = base + "-" + strconv.Itoa(i) + ext
+        }
+
+        if err := os.Rename(path, rotated); err != nil {
+                return err
+        }
+        trace.Printf("Rotated %s to %s", path, rotated)
+
+        if !rotateGzip {
+                return nil
+        }
+        // The file's already been moved aside so a failure here only leaves it uncompressed.
+        if err := gzipFile(rotated); err != nil {
+                trace.Printf("WARNING: compressing %s: %s", rotated, err)
+        }
+        return nil
+}
+
+func exists(path string) bool {
+        _, err := os.Stat(path)
+        return err == nil
+}
+
+// readHeader is the first line of a CSV file.
+func readHeader(path string) (string, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return "", err
+        }
+        defer f.Close()
+
+        line, err := bufio.NewReader(f).ReadString('\n')
+        if err != nil && err != io.EOF {
+                return "", err
+        }
+        return strings.TrimRight(line, "\r\n"), nil
+}
+
+// gzipFile compresses path to path.gz and removes it.
+func gzipFile(path string) error {
+        in, err := os.Open(path)
+        if err != nil {
+                return err
+        }
+        defer in.Close()
+
+        tmp := path + ".gz.tmp"
+        out, err := os.Create(tmp)
+        if err != nil {
+                return err
+        }
+
+        zw := gzip.NewWriter(out)
+        _, err = io.Copy(zw, in)
+        if err == nil {
+                err = zw.Close()
+        }
+        if cerr := out.Close(); err == nil {
+                err = cerr
+        }
+        if err == nil {
+                err = os.Rename(tmp, path + ".gz")
+        }
+        if err != nil {
+                os.Remove(tmp)
+                return err
+        }
+
+        return os.Remove(path)
+}
+
+// historyFiles are a check's files and its rotated ones, for one of csv or jsonl, in the
+// directories matching dir.
+func historyFiles(dir, check, ext string) ([]string, error) {
+        var paths []string
+        for _, pattern := range []string{check + ext, check + ".*" + ext, check + ".*" + ext + ".gz"} {
+                p, err := filepath.Glob(filepath.Join(dir, pattern))
+                if err != nil {
+                        return nil, err
+                }
+                paths = append(paths, p...)
+        }
+        return paths, nil
+}
+
+// openHistory opens a history file, decompressing a rotated .gz one.
+func openHistory(path string) (io.ReadCloser, error) {
+        f, err := os.Open(path)
+        if err != nil || !strings.HasSuffix(path, ".gz") {
+                return f, err
+        }
+
+        zr, err := gzip.NewReader(f)
+        if err != nil {
+                f.Close()
+                return nil, fmt.Errorf("%s: %w", path, err)
+        }
+        return struct {
+                io.Reader
+                io.Closer
+        }{zr, f}, nil
+}
+
+// csvHeader is the header row for columns.
+func csvHeader(columns []column) []string {
+        names := make([]string, len(columns))
+        for i, c := range columns {
+                names[i] = c.name
+        }
+        return names
+}
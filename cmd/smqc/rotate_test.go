@@ -0,0 +1,93 @@
+package main
+
+import (
+        "os"
+        "path/filepath"
+        "sort"
+        "testing"
+        "time"
+)
+
+func TestRotatePeriod(t *testing.T) {
+        at := time.Date(2024, 1, 31, 23, 30, 0, 0, time.FixedZone("NZDT", 13*3600))
+
+        for _, c := range []struct {
+                rotate string
+                expected string
+        }{
+                {"monthly", "2024-01"},
+                {"daily", "2024-01-31"},
+                {"", ""},
+        } {
+                rotateEvery = c.rotate
+                if got := rotatePeriod(at); got != c.expected {
+                        t.Errorf("%q: expected %q, got %q", c.rotate, c.expected, got)
+                }
+        }
+        rotateEvery = ""
+}
+
+func TestRotateOutput(t *testing.T) {
+        lastMonth := time.Now().UTC().AddDate(0, -1, 0)
+        header := []string{"timestamp", "station", "schema_version"}
+
+        for _, c := range []struct {
+                name string
+                rotate string
+                gzip bool
+                content string
+                modified time.Time
+                existing []string
+                expected []string
+        }{
+                {"same period", "monthly", false, "timestamp,station,schema_version\n", time.Now(), nil, []string{"spike.csv"}},
+                {"last month", "monthly", false, "timestamp,station,schema_version\n", lastMonth, nil, []string{"spike." + lastMonth.Format("2006-01") + ".csv"}},
+                {"taken", "monthly", false, "timestamp,station,schema_version\n", lastMonth, []string{"spike." + lastMonth.Format("2006-01") + ".csv"}, []string{"spike." + lastMonth.Format("2006-01") + "-1.csv", "spike." + lastMonth.Format("2006-01") + ".csv"}},
+                {"gzip", "monthly", true, "timestamp,station,schema_version\n", lastMonth, nil, []string{"spike." + lastMonth.Format("2006-01") + ".csv.gz"}},
+                {"old header", "", false, "timestamp,station\n", time.Now(), nil, []string{"spike.schema-*.csv"}},
+                {"empty", "monthly", false, "", lastMonth, nil, []string{"spike.csv"}},
+        } {
+                t.Run(c.name, func(t *testing.T) {
+                        every, gz := rotateEvery, rotateGzip
+                        rotateEvery, rotateGzip = c.rotate, c.gzip
+                        t.Cleanup(func() { rotateEvery, rotateGzip = every, gz })
+
+                        d := t.TempDir()
+                        path := filepath.Join(d, "spike.csv")
+                        if err := os.WriteFile(path, []byte(c.content), 0o644); err != nil {
+                                t.Fatal(err)
+                        }
+                        if err := os.Chtimes(path, c.modified, c.modified); err != nil {
+                                t.Fatal(err)
+                        }
+                        for _, e := range c.existing {
+                                if err := os.WriteFile(filepath.Join(d, e), nil, 0o644); err != nil {
+                                        t.Fatal(err)
+                                }
+                        }
+
+                        if err := rotateOutput(path, header); err != nil {
+                                t.Fatal(err)
+                        }
+
+                        entries, err := os.ReadDir(d)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        var files []string
+                        for _, e := range entries {
+                                files = append(files, e.Name())
+                        }
+                        sort.Strings(files)
+
+                        if len(files) != len(c.expected) {
+                                t.Fatalf("expected %v, got %v", c.expected, files)
+                        }
+                        for i, pattern := range c.expected {
+                                if ok, _ := filepath.Match(pattern, files[i]); !ok {
+                                        t.Errorf("expected %v, got %v", c.expected, files)
+                                }
+                        }
+                })
+        }
+}
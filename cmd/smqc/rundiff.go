@@ -0,0 +1,201 @@
+package main
+
+import (
+        "encoding/json"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+        "time"
+)
+
+/*
+What changed since the last run, so a brand new problem doesn't get lost among the stations
+everyone already knows about. With -run-diff the stations each check flagged this run are
+compared with those it flagged last run and written to runDiff.csv as
+
+        timestamp,check,station,status,runs
+
+where status is new for a station the check didn't flag last run, persistent for one it
+did, with runs the consecutive runs it's been flagged for, and recovered for one it flagged
+last run but not this one. -alert-webhook alerts say whether their station is new or
+persistent as well.
+
+The last run's offenders are kept in runDiff.json. Without it, on the first run, they're
+the stations in the latest run in the history of the historyChecks, noiseCount.csv,
+ratioDiff.csv and so on, all counted as flagged for one run. A check that fails keeps its
+last run's offenders for the next run to compare with.
+*/
+
+const runDiffStateFile = "runDiff.json"
+
+var runDiffColumns = []column{
+        {"timestamp", textColumn},
+        {"check", textColumn},
+        {"station", textColumn},
+        {"status", textColumn},
+        {"runs", intColumn},
+}
+
+// runDiffState is the last run's offenders, check to station to consecutive runs.
+type runDiffState struct {
+        Run string `json:"run"`
+        Offenders map[string]map[string]int `json:"offenders"`
+}
+
+// lastOffenders is the last run's, read as the run starts.
+var lastOffenders map[string]map[string]int
+
+// loadLastOffenders reads runDiff.json, or the history for the first run.
+func loadLastOffenders() {
+        lastOffenders = map[string]map[string]int{}
+
+        path := filepath.Join(dir, runDiffStateFile)
+        b, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                seedLastOffenders()
+                return
+        }
+        if err == nil {
+                var state runDiffState
+                if err = json.Unmarshal(b, &state); err == nil && state.Offenders != nil {
+                        lastOffenders = state.Offenders
+                }
+        }
+        if err != nil {
+                trace.Printf("WARNING: reading %s: %s", path, err)
+        }
+}
+
+// seedLastOffenders takes the stations in each history check's latest run.
+func seedLastOffenders() {
+        for _, check := range historyChecks {
+                history, err := readHistory(check, time.Time{})
+                if err != nil {
+                        trace.Printf("WARNING: -run-diff reading the %s history: %s", check, err)
+                        continue
+                }
+
+                var latest time.Time
+                for _, h := range history {
+                        if h.at.After(latest) {
+                                latest = h.at
+                        }
+                }
+
+                for _, h := range history {
+                        if !h.at.Equal(latest) || h.blacklist == "true" {
+                                continue
+                        }
+                        if lastOffenders[check] == nil {
+                                lastOffenders[check] = map[string]int{}
+                        }
+                        lastOffenders[check][h.station] = 1
+                }
+        }
+}
+
+// offenderStatus is new or persistent for a station check flagged this run, "" without
+// -run-diff.
+func offenderStatus(check, station string) string {
+        if !runDiff {
+                return ""
+        }
+        if lastOffenders[check][station] > 0 {
+                return "persistent"
+        }
+        return "new"
+}
+
+type runDiffRow struct {
+        check string
+        station string
+        status string
+        runs int
+}
+
+// diffRuns compares the checks that ran this run with the last run's offenders, writing
+// runDiff.csv and the state for the next run.
+func diffRuns(checks []string) {
+        findings.Lock()
+        flagged := map[string]map[string]bool{}
+        for check, stations := range findings.byCheck {
+                flagged[check] = stations
+        }
+        findings.Unlock()
+
+        offenders := map[string]map[string]int{}
+        for check, stations := range lastOffenders {
+                offenders[check] = stations
+        }
+
+        var diff []runDiffRow
+        var fresh []string
+        for _, check := range checks {
+                now := map[string]int{}
+                for station := range flagged[check] {
+                        runs := lastOffenders[check][station] + 1
+                        now[station] = runs
+
+                        status := "persistent"
+                        if runs == 1 {
+                                status = "new"
+                                fresh = append(fresh, station + " (" + check + ")")
+                        }
+                        diff = append(diff, runDiffRow{check, station, status, runs})
+                }
+                for station := range lastOffenders[check] {
+                        if !flagged[check][station] {
+                                diff = append(diff, runDiffRow{check, station, "recovered", 0})
+                        }
+                }
+
+                offenders[check] = now
+        }
+
+        // New first, they're what needs looking at.
+        order := map[string]int{"new": 0, "persistent": 1, "recovered": 2}
+        sort.Slice(diff, func(i, j int) bool {
+                a, b := diff[i], diff[j]
+                if a.status != b.status {
+                        return order[a.status] < order[b.status]
+                }
+                if a.check != b.check {
+                        return a.check < b.check
+                }
+                return a.station < b.station
+        })
+
+        out := newCheckOutput("runDiff", runDiffColumns...)
+        defer out.Close()
+
+        timestamp := runStart.Format(time.RFC3339)
+
+        for _, r := range diff {
+                if err := out.write(r.station, float64(r.runs), timestamp, r.check, r.station, r.status, r.runs); err != nil {
+                        trace.Printf("ERROR: writing runDiff: %s", err)
+                        return
+                }
+        }
+        if err := out.flush(); err != nil {
+                trace.Printf("ERROR: writing runDiff: %s", err)
+                return
+        }
+
+        if len(fresh) > 0 {
+                sort.Strings(fresh)
+                trace.Printf("%d new offenders since the last run: %s", len(fresh), strings.Join(fresh, ", "))
+        }
+
+        statePath := filepath.Join(dir, runDiffStateFile)
+        b, err := json.Marshal(runDiffState{Run: timestamp, Offenders: offenders})
+        if err == nil {
+                tmp := statePath + ".tmp"
+                if err = os.WriteFile(tmp, b, 0666); err == nil {
+                        err = os.Rename(tmp, statePath)
+                }
+        }
+        if err != nil {
+                trace.Printf("WARNING: writing %s: %s", statePath, err)
+        }
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+        "bytes"
+        "encoding/csv"
+        "fmt"
+        "io"
+        "net/http"
+        "os"
+        "strings"
+        "sync"
+        "time"
+)
+
+/*
+S3 upload of the results, as the check host doesn't keep its files.
+
+With -s3-bucket each check's rows for the run are put in the bucket as one object in the
+check's -format, a CSV one with its header, at
+
+        <-s3-prefix>/<partitions>/<check>-<run time>.<format>
+
+where -s3-partition date adds date=2024-01-02/, check adds check=noiseCount/ and
+date,check both, in the order given. Objects are encrypted with -s3-sse, AES256 by default
+or aws:kms with -s3-kms-key. The local files are still written unless -s3-only is set.
+
+Credentials and the region come from the same place as for -db-secret, see aws.go, and
+AWS_ENDPOINT_URL_S3 overrides the endpoint, addressed path style, e.g. for a VPC endpoint
+or a local S3 compatible store. A failed upload fails the check like any other write.
+*/
+
+var s3Partitions []string
+
+// parseS3Partition reads -s3-partition.
+func parseS3Partition(s string) ([]string, error) {
+        var partitions []string
+        for _, p := range strings.Split(s, ",") {
+                switch p = strings.TrimSpace(p); p {
+                case "":
+                case "date", "check":
+                        partitions = append(partitions, p)
+                default:
+                        return nil, fmt.Errorf("unknown -s3-partition %q, expected date, check or both", p)
+                }
+        }
+        return partitions, nil
+}
+
+// s3Key is where a check's rows for the run go.
+func s3Key(check, format string, at time.Time) string {
+        parts := []string{}
+        if p := strings.Trim(s3Prefix, "/"); p != "" {
+                parts = append(parts, p)
+        }
+        if currentSource != "" {
+                parts = append(parts, "source=" + currentSource)
+        }
+        for _, p := range s3Partitions {
+                switch p {
+                case "date":
+                        parts = append(parts, "date=" + at.UTC().Format("2006-01-02"))
+                case "check":
+                        parts = append(parts, "check=" + check)
+                }
+        }
+        parts = append(parts, check + "-" + at.UTC().Format("20060102T150405Z") + "." + format)
+
+        return strings.Join(parts, "/")
+}
+
+// uploadS3 puts the check's rows for the run in -s3-bucket.
+func uploadS3(o *checkOutput) error {
+        body, err := o.render()
+        if err != nil {
+                return err
+        }
+
+        contentType := map[string]string{
+                "csv": "text/csv",
+                "jsonl": "application/x-ndjson",
+                "geojson": "application/geo+json",
+        }[o.format]
+
+        return putS3Object(s3Key(o.name, o.format, runStart), contentType, body)
+}
+
+// render is the run's rows as a whole file in the check's format.
+func (o *checkOutput) render() ([]byte, error) {
+        var b bytes.Buffer
+
+        switch o.format {
+        case "geojson":
+                return marshalGeoJSON(o.name, o.columns, o.rows)
+        case "csv":
+                w := csv.NewWriter(&b)
+                w.Write(csvHeader(o.columns))
+                for _, r := range o.rows {
+                        line := make([]string, len(r.fields))
+                        for i, v := range r.fields {
+                                line[i] = formatField(v)
+                        }
+                        w.Write(line)
+                }
+                w.Flush()
+                return b.Bytes(), w.Error()
+        }
+
+        for _, r := range o.rows {
+                line, err := o.marshalJSON(r)
+                if err != nil {
+                        return nil, err
+                }
+                b.Write(line)
+        }
+        return b.Bytes(), nil
+}
+
+// s3Creds are cached between uploads, the checks all upload at about the same time.
+var s3Creds struct {
+        sync.Mutex
+        creds awsCredentials
+        at time.Time
+}
+
+func s3Credentials() (awsCredentials, error) {
+        s3Creds.Lock()
+        defer s3Creds.Unlock()
+
+        // Instance and container credentials last hours, refetching every few minutes keeps
+        // well inside that.
+        if s3Creds.creds.AccessKeyID != "" && time.Since(s3Creds.at) < 5*time.Minute {
+                return s3Creds.creds, nil
+        }
+
+        c, err := loadAWSCredentials()
+        if err != nil {
+                return c, err
+        }
+        s3Creds.creds, s3Creds.at = c, time.Now()
+        return c, nil
+}
+
+func putS3Object(key, contentType string, body []byte) error {
+        creds, err := s3Credentials()
+        if err != nil {
+                return err
+        }
+        region, err := awsRegionFor("")
+        if err != nil {
+                return err
+        }
+
+        var escaped []string
+        for _, segment := range strings.Split(key, "/") {
+                escaped = append(escaped, awsEscape(segment))
+        }
+        path := strings.Join(escaped, "/")
+
+        u := "https://" + s3Bucket + ".s3." + region + ".amazonaws.com/" + path
+        if endpoint := os.Getenv("AWS_ENDPOINT_URL_S3"); endpoint != "" {
+                u = strings.TrimSuffix(endpoint, "/") + "/" + awsEscape(s3Bucket) + "/" + path
+        }
+
+        req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+        if err != nil {
+                return err
+        }
+        req.Header.Set("Content-Type", contentType)
+        req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+        req.Header.Set("X-Amz-Server-Side-Encryption", s3SSE)
+        if s3KMSKey != "" {
+                req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s3KMSKey)
+        }
+
+        signRequest(req, body, creds, region, "s3", time.Now())
+
+        res, err := s3Client.Do(req)
+        if err != nil {
+                return err
+        }
+        defer res.Body.Close()
+
+        if res.StatusCode != http.StatusOK {
+                b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+                return fmt.Errorf("putting s3://%s/%s: %s: %s", s3Bucket, key, res.Status, strings.TrimSpace(string(b)))
+        }
+        return nil
+}
+
+// s3Client has longer than awsClient's timeout, the objects can be a few MB.
+var s3Client = &http.Client{Timeout: time.Minute}
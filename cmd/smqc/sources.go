@@ -0,0 +1,241 @@
+package main
+
+import (
+        "database/sql"
+        "encoding/csv"
+        "encoding/json"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "strings"
+        "time"
+)
+
+/*
+More than one hazard database from a single deployment, e.g. the primary and a test or
+legacy instance. -sources is a JSON array of objects or a CSV with a header row, both using
+these field names
+
+        name,dsn_file,dump_dir
+        primary,,
+        legacy,/run/secrets/hazard-legacy-dsn,
+
+and each run checks every source in turn, in the file's order. dsn_file is a -dsn-file for
+the source and dump_dir a -dump-dir, a source with neither is the database the -db-* flags
+describe. Each source's output goes to its own subdirectory of -output-dir named after it,
+so its history, run registry and other state are its own and the checks that read them
+compare a source only with itself, and every row gets a source column, the metrics a
+source label and -s3-bucket keys a source= part. A source that can't be reached fails its
+checks for the run, the others go ahead, and it's tried again on the next run.
+*/
+
+type dataSource struct {
+        Name string `json:"name"`
+        DSNFile string `json:"dsn_file"`
+        DumpDir string `json:"dump_dir"`
+
+        db *sql.DB
+        networks map[string]string
+}
+
+var (
+        sources []*dataSource
+        // currentSource is the source being checked, "" without -sources.
+        currentSource string
+)
+
+func (s *dataSource) validate() error {
+        if s.Name == "" {
+                return fmt.Errorf("missing name")
+        }
+        if s.Name != filepath.Base(s.Name) || s.Name == "." || s.Name == ".." || strings.ContainsAny(s.Name, `\/`) {
+                return fmt.Errorf("source %q: the name has to be usable as a directory name", s.Name)
+        }
+        if s.DSNFile != "" && s.DumpDir != "" {
+                return fmt.Errorf("source %s: dsn_file and dump_dir can't both be set", s.Name)
+        }
+        return nil
+}
+
+// loadSources reads -sources.
+func loadSources(path string) ([]*dataSource, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        var list []*dataSource
+
+        switch strings.ToLower(filepath.Ext(path)) {
+        case ".json":
+                err = json.NewDecoder(f).Decode(&list)
+        case ".csv":
+                list, err = readSourcesCSV(f)
+        default:
+                err = fmt.Errorf("unknown sources file type %q, expected .json or .csv", filepath.Ext(path))
+        }
+        if err != nil {
+                return nil, fmt.Errorf("reading sources file %s: %w", path, err)
+        }
+        if len(list) == 0 {
+                return nil, fmt.Errorf("sources file %s has no sources", path)
+        }
+
+        names := map[string]bool{}
+        for i, s := range list {
+                if err := s.validate(); err != nil {
+                        return nil, fmt.Errorf("sources file %s source %d: %w", path, i+1, err)
+                }
+                if names[s.Name] {
+                        return nil, fmt.Errorf("sources file %s: source %s is there twice", path, s.Name)
+                }
+                names[s.Name] = true
+        }
+
+        return list, nil
+}
+
+func readSourcesCSV(r io.Reader) ([]*dataSource, error) {
+        cr := csv.NewReader(r)
+        cr.FieldsPerRecord = -1
+
+        header, err := cr.Read()
+        if err != nil {
+                return nil, err
+        }
+
+        col := map[string]int{}
+        for i, h := range header {
+                col[strings.TrimSpace(h)] = i
+        }
+        if _, ok := col["name"]; !ok {
+                return nil, fmt.Errorf("no name column in header")
+        }
+
+        var list []*dataSource
+
+        for {
+                rec, err := cr.Read()
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        return nil, err
+                }
+
+                field := func(name string) string {
+                        if i, ok := col[name]; ok && i < len(rec) {
+                                return strings.TrimSpace(rec[i])
+                        }
+                        return ""
+                }
+
+                list = append(list, &dataSource{Name: field("name"), DSNFile: field("dsn_file"), DumpDir: field("dump_dir")})
+        }
+
+        return list, nil
+}
+
+// open connects to the source, or returns the connection from an earlier run.
+func (s *dataSource) open() error {
+        if s.db != nil {
+                return nil
+        }
+
+        var (
+                db *sql.DB
+                err error
+        )
+        switch {
+        case s.DumpDir != "":
+                db, err = openDump(s.DumpDir)
+        case s.DSNFile != "":
+                db, err = openDSNFile(s.DSNFile)
+        case dumpDir != "":
+                db, err = openDump(dumpDir)
+        default:
+                db, err = openHazardDB()
+        }
+        if err != nil {
+                return err
+        }
+
+        if needNetworks() {
+                networks, err := loadStationNetworks(db)
+                if err != nil {
+                        trace.Printf("WARNING: source %s: loading station networks: %s", s.Name, err)
+                }
+                s.networks = mergeNetworks(networks)
+        }
+
+        s.db = db
+        return nil
+}
+
+func openDSNFile(path string) (*sql.DB, error) {
+        dsn, err := readSecretFile(path)
+        if err != nil {
+                return nil, err
+        }
+        c, err := dsnConnector(dsn)
+        if err != nil {
+                return nil, fmt.Errorf("problem with DB config: %w", err)
+        }
+        return openConnector(c)
+}
+
+// runSources is runOnce for each source, with its own output directory.
+func runSources(checks []check, at time.Time) (int, int) {
+        outputDir, mainDump := dir, dumpDir
+        defer func() {
+                dir, dumpDir, currentSource = outputDir, mainDump, ""
+        }()
+
+        var failed, concerns int
+        for _, s := range sources {
+                dir, currentSource, dumpDir = filepath.Join(outputDir, s.Name), s.Name, mainDump
+                if s.DumpDir != "" {
+                        dumpDir = s.DumpDir
+                }
+
+                trace.Printf("Checking source %s", s.Name)
+
+                err := s.open()
+                if err == nil {
+                        stationNetworks = s.networks
+                        err = os.MkdirAll(dir, 0777)
+                }
+                if err != nil {
+                        trace.Printf("ERROR: source %s: %s", s.Name, err)
+                        failed += len(checks)
+                        continue
+                }
+
+                f, c := runOnce(s.db, checks, at)
+                failed, concerns = failed + f, concerns + c
+        }
+
+        return failed, concerns
+}
+
+// runAll is a run for at, of the database or of every -sources.
+func runAll(db *sql.DB, checks []check, at time.Time) (int, int) {
+        if len(sources) > 0 {
+                return runSources(checks, at)
+        }
+        return runOnce(db, checks, at)
+}
+
+// closeDatabases closes db, if there is one, and the connections runSources opened.
+func closeDatabases(db *sql.DB) {
+        if db != nil {
+                db.Close()
+        }
+        for _, s := range sources {
+                if s.db != nil {
+                        s.db.Close()
+                }
+        }
+}
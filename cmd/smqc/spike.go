@@ -0,0 +1,167 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+
+        "github.com/mabznz/smqc/smqc"
+)
+
+/*
+An electrical glitch shows up as a single huge value, which barely moves a station's noise
+count. This looks at each station's largest PGA and PGV in the window and writes those
+that can't be real shaking to spike.csv as
+
+        timestamp,station,blacklist,problem,max_pga,max_pgv
+
+largest PGA first. The problem is implausible-pga for a PGA over -spike-pga, in
+impact.pga's %g so the default 200 is 2g, or pga-pgv-mismatch for a PGV in cm/s more than
+-spike-pgv-ratio times the PGA in %g or a PGA that many times the PGV, when the larger is
+over -spike-floor. Real shaking keeps the two within a small factor of each other, the
+floor leaves out the noise on quiet stations. With -quake-filter a station near a
+catalogued earthquake isn't flagged, as for the other checks.
+*/
+const spikeSQL = `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        COALESCE(pga.max_pga, 0) AS max_pga,
+        COALESCE(pgv.max_pgv, 0) AS max_pgv
+FROM
+	impact.source loc
+	LEFT OUTER JOIN (
+		SELECT pga.sourcepk, MAX(pga.pga) AS max_pga
+		FROM impact.pga pga
+		WHERE CAST($3 AS INTEGER) = 0 OR (pga.time >= $4 AND pga.time < $5)
+		GROUP BY pga.sourcepk
+	) pga ON pga.sourcepk = loc.sourcepk
+	LEFT OUTER JOIN (
+		SELECT pgv.sourcepk, MAX(pgv.pgv) AS max_pgv
+		FROM impact.pgv pgv
+		WHERE CAST($3 AS INTEGER) = 0 OR (pgv.time >= $4 AND pgv.time < $5)
+		GROUP BY pgv.sourcepk
+	) pgv ON pgv.sourcepk = loc.sourcepk
+WHERE
+	(CAST($1 AS TEXT) = '' OR ',' || CAST($1 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `)
+	AND NOT ',' || CAST($2 AS TEXT) || ',' LIKE ` + smqc.StationPattern + `
+	AND (pga.max_pga IS NOT NULL OR pgv.max_pgv IS NOT NULL)`
+
+var spikeColumns = []column{
+        {"timestamp", textColumn},
+        {"station", textColumn},
+        {"blacklist", textColumn},
+        {"problem", textColumn},
+        {"max_pga", floatColumn},
+        {"max_pgv", floatColumn},
+}
+
+type spikeRow struct {
+        timestamp string
+        station string
+        blacklist string
+        problem string
+        maxPGA float64
+        maxPGV float64
+        quake bool
+}
+
+// spikeProblem says what's implausible about a station's largest values, "" for nothing.
+func spikeProblem(maxPGA, maxPGV float64) string {
+        if maxPGA > spikePGA {
+                return "implausible-pga"
+        }
+        if maxPGA < spikeFloor && maxPGV < spikeFloor {
+                return ""
+        }
+        if maxPGV > spikePGVRatio * maxPGA || maxPGA > spikePGVRatio * maxPGV {
+                return "pga-pgv-mismatch"
+        }
+        return ""
+}
+
+func spike(db querier, tr *checkTrace) (checkResult, error) {
+        recordQueryStats(db, "spike", spikeSQL, withWindow(includeStations, excludeFor("spike"))...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        tr.querying()
+        rows, err := queryRetry(ctx, db, "spike", spikeSQL, withWindow(includeStations, excludeFor("spike"))...)
+        tr.executed()
+        if err != nil {
+                return checkResult{}, queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        var (
+                timestamp dbTimestamp
+                station string
+                blacklist string
+                maxPGA float64
+                maxPGV float64
+        )
+
+        scan := &rowScanner{check: "spike"}
+        defer scan.report()
+
+        var spikes []spikeRow
+        for rows.Next() {
+                err := scan.scan(rows, &timestamp, &station, &blacklist, &maxPGA, &maxPGV)
+                if err == errSkipRow {
+                        continue
+                }
+                if err != nil {
+                        return checkResult{}, err
+                }
+                blacklist = overrideBlacklist(station, blacklist)
+                tr.row()
+
+                problem := spikeProblem(maxPGA, maxPGV)
+                if problem == "" {
+                        continue
+                }
+
+                quake := explainedByQuake("spike", station, timestamp.String())
+                if quake && quakeFilter == "exclude" {
+                        continue
+                }
+                spikes = append(spikes, spikeRow{timestamp.String(), station, blacklist, problem, maxPGA, maxPGV, quake})
+        }
+        if err := scan.end(ctx, rows); err != nil {
+                return checkResult{}, err
+        }
+
+        sort.SliceStable(spikes, func(i, j int) bool {
+                return spikes[i].maxPGA > spikes[j].maxPGA
+        })
+        if len(spikes) > limit {
+                spikes = spikes[:limit]
+        }
+
+        out := newCheckOutput("spike", spikeColumns...)
+        defer out.Close()
+
+        done := tr.writing()
+        defer done()
+
+        var concerns int
+        for _, s := range spikes {
+                if !s.quake {
+                        flagStation("spike", s.station, s.blacklist)
+                }
+                if !s.quake && s.blacklist != "true" {
+                        concerns++
+                        overThreshold("spike", s.station)
+                }
+                if err := out.write(s.station, s.maxPGA, s.timestamp, s.station, s.blacklist, s.problem, s.maxPGA, s.maxPGV); err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
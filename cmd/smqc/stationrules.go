@@ -0,0 +1,235 @@
+package main
+
+import (
+        "encoding/csv"
+        "encoding/json"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+Per station thresholds and suppressions from -station-rules, for the stations the network
+wide flags suit badly. The file is a JSON array of objects or a CSV with a header row, both
+using these field names
+
+        station,check,threshold,from,until,reason
+        WEL,noiseCount,40,,,urban site
+        TFSS,ratioDiff,15,,,
+        WEL,,,2024-05-01,2024-07-01,construction next door
+
+A rule with a threshold replaces the check's for that station: -noise-count-min for
+noiseCount, -ratio-alert-threshold for ratioDiff and pgvRatio, -latency in seconds for
+latency. A rule without one suppresses the station, it's left out of the check, or every
+check when check is empty, as with -exclude-stations. from and until, a date or RFC3339
+time in UTC, limit a rule to the runs in between, until itself not included, either can be
+left out. reason is only for people.
+*/
+
+type stationRule struct {
+        Station string `json:"station"`
+        Check string `json:"check"`
+        Threshold *float64 `json:"threshold"`
+        From string `json:"from"`
+        Until string `json:"until"`
+        Reason string `json:"reason"`
+
+        from, until time.Time
+}
+
+// thresholdChecks are the checks a rule can give a threshold for.
+var thresholdChecks = map[string]bool{
+        "noiseCount": true,
+        "ratioDiff": true,
+        "pgvRatio": true,
+        "latency": true,
+}
+
+var stationRules []stationRule
+
+func (r *stationRule) validate() error {
+        if r.Station == "" {
+                return fmt.Errorf("missing station")
+        }
+        if r.Check != "" {
+                known := false
+                for _, c := range checkRegistry {
+                        known = known || c.check.Name() == r.Check
+                }
+                if !known {
+                        return fmt.Errorf("station %s: unknown check %q", r.Station, r.Check)
+                }
+        }
+        if r.Threshold != nil && !thresholdChecks[r.Check] {
+                return fmt.Errorf("station %s: a threshold is only for noiseCount, ratioDiff, pgvRatio or latency", r.Station)
+        }
+
+        var err error
+        if r.From != "" {
+                if r.from, err = parseWindowTime("from", r.From); err != nil {
+                        return fmt.Errorf("station %s: %w", r.Station, err)
+                }
+        }
+        if r.Until != "" {
+                if r.until, err = parseWindowTime("until", r.Until); err != nil {
+                        return fmt.Errorf("station %s: %w", r.Station, err)
+                }
+        }
+        if !r.from.IsZero() && !r.until.IsZero() && !r.until.After(r.from) {
+                return fmt.Errorf("station %s: until %s isn't after from %s", r.Station, r.Until, r.From)
+        }
+        return nil
+}
+
+// applies says whether the rule is for check in the run at.
+func (r stationRule) applies(check string, at time.Time) bool {
+        if r.Check != "" && r.Check != check {
+                return false
+        }
+        if !r.from.IsZero() && at.Before(r.from) {
+                return false
+        }
+        return r.until.IsZero() || at.Before(r.until)
+}
+
+// loadStationRules reads -station-rules. Unlike -metadata-file a bad rule is an error, a
+// skipped suppression would start alerting on the station.
+func loadStationRules(path string) ([]stationRule, error) {
+        f, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        var rules []stationRule
+
+        switch strings.ToLower(filepath.Ext(path)) {
+        case ".json":
+                err = json.NewDecoder(f).Decode(&rules)
+        case ".csv":
+                rules, err = readStationRulesCSV(f)
+        default:
+                err = fmt.Errorf("unknown station rules file type %q, expected .json or .csv", filepath.Ext(path))
+        }
+        if err != nil {
+                return nil, fmt.Errorf("reading station rules file %s: %w", path, err)
+        }
+
+        for i := range rules {
+                if err := rules[i].validate(); err != nil {
+                        return nil, fmt.Errorf("station rules file %s rule %d: %w", path, i+1, err)
+                }
+        }
+
+        return rules, nil
+}
+
+func readStationRulesCSV(r io.Reader) ([]stationRule, error) {
+        cr := csv.NewReader(r)
+        cr.FieldsPerRecord = -1
+
+        header, err := cr.Read()
+        if err != nil {
+                return nil, err
+        }
+
+        col := map[string]int{}
+        for i, h := range header {
+                col[strings.TrimSpace(h)] = i
+        }
+        if _, ok := col["station"]; !ok {
+                return nil, fmt.Errorf("no station column in header")
+        }
+
+        var rules []stationRule
+
+        for line := 2; ; line++ {
+                rec, err := cr.Read()
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        return nil, err
+                }
+
+                field := func(name string) string {
+                        if i, ok := col[name]; ok && i < len(rec) {
+                                return strings.TrimSpace(rec[i])
+                        }
+                        return ""
+                }
+
+                r := stationRule{
+                        Station: field("station"),
+                        Check: field("check"),
+                        From: field("from"),
+                        Until: field("until"),
+                        Reason: field("reason"),
+                }
+                if s := field("threshold"); s != "" {
+                        v, err := strconv.ParseFloat(s, 64)
+                        if err != nil {
+                                return nil, fmt.Errorf("line %d: invalid threshold %q", line, s)
+                        }
+                        r.Threshold = &v
+                }
+
+                rules = append(rules, r)
+        }
+
+        return rules, nil
+}
+
+// excludeFor is -exclude-stations with the stations suppressed for check this run.
+func excludeFor(check string) string {
+        stations := []string{excludeStations}
+        for _, r := range stationRules {
+                if r.Threshold == nil && r.applies(check, runStart) {
+                        stations = append(stations, r.Station)
+                }
+        }
+        return stationList(strings.Join(stations, ","))
+}
+
+// stationThreshold is the station's threshold for check this run, def without a rule. A
+// later rule wins over an earlier one.
+func stationThreshold(check, station string, def float64) float64 {
+        threshold := def
+        for _, r := range stationRules {
+                if r.Threshold != nil && r.Station == station && r.applies(check, runStart) {
+                        threshold = *r.Threshold
+                }
+        }
+        return threshold
+}
+
+// lowestThreshold is the lowest threshold any station has for check this run, the query
+// can't leave out what a station with a lower one would report.
+func lowestThreshold(check string, def float64) float64 {
+        lowest := def
+        for _, r := range stationRules {
+                if r.Threshold != nil && *r.Threshold < lowest && r.applies(check, runStart) {
+                        lowest = *r.Threshold
+                }
+        }
+        return lowest
+}
+
+// noiseCountQueryMin is the count noiseCount's query reports PGA over.
+func noiseCountQueryMin() int {
+        return int(lowestThreshold("noiseCount", float64(noiseCountMin)))
+}
+
+// noiseCountThreshold is the station's -noise-count-min and whether a row is one the query
+// would have reported with it, the query only leaves out PGA under the lowest.
+func noiseCountThreshold(station, component string, count int) (int, bool) {
+        threshold := int(stationThreshold("noiseCount", station, float64(noiseCountMin)))
+        if strings.HasPrefix(component, "pga") && count <= threshold {
+                return threshold, false
+        }
+        return threshold, true
+}
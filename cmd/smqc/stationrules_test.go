@@ -0,0 +1,92 @@
+package main
+
+import (
+        "os"
+        "path/filepath"
+        "strings"
+        "testing"
+        "time"
+)
+
+func TestLoadStationRules(t *testing.T) {
+        for _, c := range []struct {
+                name string
+                file string
+                content string
+                rules int
+                err string
+        }{
+                {"csv", "rules.csv", "station,check,threshold,from,until,reason\nWEL,noiseCount,40,,,urban site\nTFSS,latency,600,,,\nWEL,,,2024-05-01,2024-07-01,construction\n", 3, ""},
+                {"json", "rules.json", `[{"station": "WEL", "check": "ratioDiff", "threshold": 15}, {"station": "TFSS", "until": "2024-07-01T00:00:00Z"}]`, 2, ""},
+                {"no station column", "rules.csv", "check,threshold\nnoiseCount,40\n", 0, "no station column in header"},
+                {"bad threshold", "rules.csv", "station,check,threshold\nWEL,noiseCount,lots\n", 0, `line 2: invalid threshold "lots"`},
+                {"missing station", "rules.csv", "station,check\n,spike\n", 0, "rule 1: missing station"},
+                {"unknown check", "rules.csv", "station,check\nWEL,noiseCounts\n", 0, `rule 1: station WEL: unknown check "noiseCounts"`},
+                {"threshold for spike", "rules.csv", "station,check,threshold\nWEL,spike,4\n", 0, "a threshold is only for noiseCount, ratioDiff, pgvRatio or latency"},
+                {"bad from", "rules.csv", "station,from\nWEL,May\n", 0, `station WEL: invalid from "May"`},
+                {"until before from", "rules.csv", "station,from,until\nWEL,2024-07-01,2024-05-01\n", 0, "until 2024-05-01 isn't after from 2024-07-01"},
+                {"file type", "rules.txt", "", 0, `unknown station rules file type ".txt"`},
+        } {
+                t.Run(c.name, func(t *testing.T) {
+                        path := filepath.Join(t.TempDir(), c.file)
+                        if err := os.WriteFile(path, []byte(c.content), 0o644); err != nil {
+                                t.Fatal(err)
+                        }
+
+                        rules, err := loadStationRules(path)
+                        if c.err != "" {
+                                if err == nil || !strings.Contains(err.Error(), c.err) {
+                                        t.Errorf("expected an error with %q, got %v", c.err, err)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if len(rules) != c.rules {
+                                t.Errorf("expected %d rules, got %+v", c.rules, rules)
+                        }
+                })
+        }
+}
+
+func TestStationRules(t *testing.T) {
+        path := filepath.Join(t.TempDir(), "rules.csv")
+        content := "station,check,threshold,from,until\nWEL,noiseCount,40,,\nWEL,noiseCount,60,2024-01-02,\nTFSS,noiseCount,,,\nSNZO,,,2024-01-01,2024-01-02\nOLD,spike,,,\n"
+        if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+                t.Fatal(err)
+        }
+        rules, err := loadStationRules(path)
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        saved, start, exclude := stationRules, runStart, excludeStations
+        stationRules, excludeStations = rules, "KUZ"
+        t.Cleanup(func() { stationRules, runStart, excludeStations = saved, start, exclude })
+
+        for _, c := range []struct {
+                at time.Time
+                check string
+                exclude string
+                threshold float64
+                lowest float64
+        }{
+                // SNZO is suppressed for every check on the 1st, WEL's later rule wins from the
+                // 2nd though the query still reports down to the earlier one's.
+                {time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), "noiseCount", "KUZ,TFSS,SNZO", 40, 40},
+                {time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), "noiseCount", "KUZ,TFSS", 60, 40},
+                {time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), "spike", "KUZ,SNZO,OLD", 50, 50},
+        } {
+                runStart = c.at
+                if got := excludeFor(c.check); got != c.exclude {
+                        t.Errorf("%s at %s: expected to exclude %s, got %s", c.check, c.at, c.exclude, got)
+                }
+                if got := stationThreshold(c.check, "WEL", 50); got != c.threshold {
+                        t.Errorf("%s at %s: expected WEL's threshold %g, got %g", c.check, c.at, c.threshold, got)
+                }
+                if got := lowestThreshold(c.check, 50); got != c.lowest {
+                        t.Errorf("%s at %s: expected the lowest threshold %g, got %g", c.check, c.at, c.lowest, got)
+                }
+        }
+}
@@ -0,0 +1,1453 @@
+/*
+Strong Motion Noise Check
+
+Queries hazard database for strong motion values and orders by most likely station
+to be non performing with data quality issues.
+
+Please refer for examples
+https://wiki.geonet.org.nz/display/dmcops/Strong+Motion+Noise+checks
+
+As hazard database only stores summarised pga, pgv and mmi data values ranging for an
+hour, script should be set up to run every hour. It will create files
+for each noise check if they do not exist and continually append to these files once they
+do exist. This is to allow data to be collected for longer periods as data quality poor performance
+may be related to a regular weeekly factor for instance.
+
+Dependancies:
+For script to run hazard_r user password must be an environment variable
+HAZARD_PASSWD
+
+Also needs to run in Geonet VPN.
+
+Logs and writes data files to /tmp. Change to appropiate.
+
+*/
+
+package main
+
+import (
+        "context"
+        "database/sql"
+        "database/sql/driver"
+        "errors"
+        "flag"
+        "fmt"
+        "os"
+        "os/signal"
+        "runtime/debug"
+        "github.com/lib/pq"
+        "github.com/mabznz/smqc/smqc"
+        "log/slog"
+        "net"
+        "net/url"
+        "strconv"
+        "strings"
+        "sync"
+        "syscall"
+        "time"
+)
+
+var (
+        noiseCountColumns = []column{
+                {"timestamp", textColumn},
+                {"station", textColumn},
+                {"blacklist", textColumn},
+                {"component", textColumn},
+                {"noise_count", intColumn},
+        }
+
+        ratioDiffColumns = []column{
+                {"timestamp", textColumn},
+                {"station", textColumn},
+                {"blacklist", textColumn},
+                {"ratio", floatColumn},
+                {"max_vertical", floatColumn},
+                {"max_horizontal", floatColumn},
+        }
+)
+
+var (
+    runStart = time.Now().UTC()
+    trace *logger
+    db *sql.DB
+    dir string
+    logLevelFlag string
+    logFormat string
+    logOutput string
+    logMaxSize int
+    logKeep int
+    keepAlive time.Duration
+    connMaxIdle time.Duration
+    queryTimeout time.Duration
+    noiseCountMin int
+    limit int
+    outputFormat string
+    defaultFormat string
+    checkFormats map[string]string
+    includeStations string
+    excludeStations string
+    metricsAddr string
+    interval time.Duration
+    daemonMode bool
+    jitter time.Duration
+    connectAttempts int
+    connectBackoff time.Duration
+    queryAttempts int
+    queryBackoff time.Duration
+    deltaMode bool
+    componentsFlag string
+    components []string
+    skipBlacklisted bool
+    runDiff bool
+    deltaSnapshotEvery int
+    partitionBy string
+    groupBy string
+    stationNetworks map[string]string
+    failFast bool
+    failOnFindings bool
+    summaryPath string
+    colocated string
+    colocatedPairs []colocatedPair
+    colocatedRatio float64
+    metadataFile string
+    stationRulesFile string
+    sourcesFile string
+    dryRun bool
+    dryRunExplain bool
+    metadataPrecedence string
+    metadata map[string]stationMetadata
+    floatPrecision int
+    queryStats bool
+    queryStatsAnalyze bool
+    dumpDir string
+    duplicateRun string
+    dedup bool
+    registryRetention time.Duration
+    traceEvents bool
+    traceFile string
+    eventLog *slog.Logger
+    continueOnScanError bool
+    grafanaURL string
+    grafanaToken string
+    grafanaMinStations int
+    alertWebhook string
+    ratioAlertThreshold float64
+    sortBy string
+    sortField string
+    sortDesc bool
+    flapping bool
+    flappingChanges int
+    flappingWindow time.Duration
+    dsnFile string
+    passwordFile string
+    arrowOut string
+    parquetOut string
+    s3Bucket string
+    s3Prefix string
+    s3Partition string
+    s3SSE string
+    s3KMSKey string
+    s3Only bool
+    influxURL string
+    influxOrg string
+    influxBucket string
+    influxToken string
+    resultsWebhook string
+    resultsWebhookSecret string
+    resultsWebhookAttempts int
+    hazardDB dbConfig
+    newStationsFeed bool
+    seenStations map[string]bool
+    onlyChecks string
+    configFile string
+    resultsDSN string
+    flatlineCheck bool
+    flatlineMinValues int
+    flatlineSpread float64
+    dataGapCheck bool
+    dataGapFraction float64
+    spikeCheck bool
+    spikePGA float64
+    spikePGVRatio float64
+    spikeFloor float64
+    mmiFeltCheck bool
+    mmiFeltLevel int
+    mmiFeltCount int
+    mmiFeltMagnitude float64
+    quakeFilter string
+    quakeURL string
+    quakeMinMagnitude float64
+    quakeRadius float64
+    latencyThreshold time.Duration
+    fdsnStationsCheck bool
+    fdsnStationURL string
+    fdsnChannels string
+    deepCheckStations int
+    deepCheckWindow time.Duration
+    dataselectURL string
+    notifySlack string
+    notifySMTP string
+    notifySMTPUser string
+    notifyFrom string
+    notifyTo string
+    notifyRunsFlag string
+    notifyRuns int
+    notifyRunOverrides map[string]int
+    pagerdutyKey string
+    pagerdutyURL string
+    opsgenieKey string
+    opsgenieURL string
+    escalateRunsFlag string
+    escalateRuns int
+    escalateRunOverrides map[string]int
+    escalateStations string
+    healthScoreReport bool
+    baselineReport bool
+    baselineWindow time.Duration
+    baselineSigma float64
+    baselineMinRuns int
+    healthWeightsFlag string
+    healthWeights map[string]float64
+    skipChecks string
+    dbSecret string
+    dbIAMAuth bool
+    awsRegion string
+    windowFrom string
+    windowTo string
+    windowSize time.Duration
+    backfill bool
+    rotateEvery string
+    rotateSize int
+    rotateGzip bool
+)
+
+// keepAliveDialer enables TCP keepalive on connections to the hazard database so
+// the VPN firewall doesn't silently drop them while they sit idle in the pool.
+type keepAliveDialer struct {
+        net.Dialer
+}
+
+func (d *keepAliveDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+        nd := d.Dialer
+        nd.Timeout = timeout
+        return nd.Dial(network, address)
+}
+
+func init() {
+
+        // Until setupLogging has the -log flags anything logged goes to stderr.
+        trace = &logger{slog.New(newLogHandler(os.Stderr, "text"))}
+
+        flag.StringVar(&configFile, "config", "", "read settings from this file, one flag name = value per line")
+        flag.StringVar(&dir, "output-dir", "/tmp", "directory the checks write their files to")
+        flag.StringVar(&rotateEvery, "rotate", "", "start new output files each \"monthly\" or \"daily\", moving the last period's aside")
+        flag.IntVar(&rotateSize, "rotate-size", 0, "move an output file aside once it's past this many MB, 0 never does")
+        flag.BoolVar(&rotateGzip, "rotate-gzip", false, "gzip output files once they're moved aside")
+        flag.StringVar(&logLevelFlag, "log-level", "info", "least severe log lines to keep, debug, info, warn or error")
+        flag.StringVar(&logFormat, "log-format", "text", "log as key=value \"text\" or \"json\" lines")
+        flag.StringVar(&logOutput, "log-output", defaultLogOutput, "file to log to, or stdout or stderr")
+        flag.IntVar(&logMaxSize, "log-max-size", 10, "rotate the log once it would grow past this many MB, 0 never rotates")
+        flag.IntVar(&logKeep, "log-keep", 5, "number of rotated logs to keep")
+        flag.StringVar(&hazardDB.host, "db-host", envOr("HAZARD_DB_HOST", "geonet-api-ng-read.ccuclj9uvil4.ap-southeast-2.rds.amazonaws.com"), "hazard database host, or HAZARD_DB_HOST")
+        flag.StringVar(&hazardDB.port, "db-port", envOr("HAZARD_DB_PORT", "5432"), "hazard database port, or HAZARD_DB_PORT")
+        flag.StringVar(&hazardDB.name, "db-name", envOr("HAZARD_DB_NAME", "hazard"), "hazard database name, or HAZARD_DB_NAME")
+        flag.StringVar(&hazardDB.user, "db-user", envOr("HAZARD_DB_USER", "hazard_r"), "hazard database user, or HAZARD_DB_USER")
+        flag.StringVar(&hazardDB.sslMode, "sslmode", "disable", "TLS for the hazard database connection, disable, require, verify-ca or verify-full")
+        flag.StringVar(&hazardDB.sslRootCert, "sslrootcert", "", "CA certificate to verify the hazard database against, needed by -sslmode verify-ca and verify-full")
+        flag.StringVar(&dsnFile, "dsn-file", "", "read the hazard database connection string from this file")
+        flag.StringVar(&passwordFile, "password-file", "", "read the database password from this file instead of HAZARD_PASSWD")
+        flag.StringVar(&dbSecret, "db-secret", "", "read the database password, or an RDS secret's JSON, from this AWS Secrets Manager secret name or ARN")
+        flag.BoolVar(&dbIAMAuth, "db-iam-auth", false, "connect with RDS IAM authentication tokens instead of a password, needs -sslmode require or stricter")
+        flag.StringVar(&awsRegion, "aws-region", envOr("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION")), "AWS region for -db-secret, -db-iam-auth and -s3-bucket, or AWS_REGION, otherwise taken from an RDS -db-host")
+        flag.DurationVar(&keepAlive, "tcp-keepalive", 30*time.Second, "TCP keepalive period for database connections")
+        flag.DurationVar(&connMaxIdle, "conn-max-idle", 5*time.Minute, "close pooled database connections idle for longer than this")
+        flag.IntVar(&noiseCountMin, "noise-count-min", 16, "only report a station's PGA, or constant MMI, with more than this many values in the hour")
+        flag.BoolVar(&flatlineCheck, "flatline", false, "look for PGA and PGV channels that stop changing, appending them to flatline.csv")
+        flag.IntVar(&flatlineMinValues, "flatline-min-values", 4, "only report a flatlined channel with at least this many values in the hour")
+        flag.Float64Var(&flatlineSpread, "flatline-spread", 0.000001, "report a channel as flatlined when its values in the hour are all within this of each other")
+        flag.StringVar(&windowFrom, "from", "", "only check values from this time, RFC3339 or a UTC time such as 2024-01-02T15:00")
+        flag.StringVar(&windowTo, "to", "", "only check values before this time, defaults to -from plus -window or now")
+        flag.DurationVar(&windowSize, "window", 0, "only check values from this long before each run, e.g. 1h, 0 checks everything in the summary tables")
+        flag.BoolVar(&backfill, "backfill", false, "run the checks for each hour from -from to -to, e.g. to regenerate history after an outage")
+        flag.BoolVar(&dataGapCheck, "data-gap", false, "look for stations that have gone silent or quiet, appending them to dataGap.csv")
+        flag.Float64Var(&dataGapFraction, "data-gap-fraction", 0.1, "report a station with fewer PGA and PGV values than this fraction of the median station's, as well as silent ones")
+        flag.BoolVar(&spikeCheck, "spike", false, "look for PGA and PGV values that can't be real shaking, appending them to spike.csv")
+        flag.Float64Var(&spikePGA, "spike-pga", 200, "report a PGA over this many %g, 200 is 2g, as an implausible spike")
+        flag.Float64Var(&spikePGVRatio, "spike-pgv-ratio", 20, "report a station whose largest PGV in cm/s and PGA in %g are more than this many times apart")
+        flag.Float64Var(&spikeFloor, "spike-floor", 1, "only compare a station's PGV and PGA when the larger is over this")
+        flag.BoolVar(&mmiFeltCheck, "mmi-felt-check", false, "look for felt MMI without a catalogued earthquake to explain it, appending the stations to mmiFelt.csv, fetching events from -quake-url")
+        flag.IntVar(&mmiFeltLevel, "mmi-felt", 4, "MMI from which a value is felt shaking that should have a catalogued earthquake behind it")
+        flag.IntVar(&mmiFeltCount, "mmi-felt-count", 3, "report a station with at least this many -mmi-felt values and no earthquake to explain them")
+        flag.Float64Var(&mmiFeltMagnitude, "mmi-felt-magnitude", 3, "smallest earthquake within -quake-radius that explains a station's felt MMI")
+        flag.IntVar(&limit, "limit", 10, "report at most this many stations per check")
+        flag.StringVar(&componentsFlag, "components", "", "comma separated noiseCount components to query, pga, pgv, pga-true, pga-false, pgv-true or pgv-false, instead of all of them")
+        flag.BoolVar(&skipBlacklisted, "skip-blacklisted", false, "leave blacklisted stations out of the noiseCount, ratioDiff and pgvRatio queries")
+        flag.DurationVar(&interval, "interval", 0, "keep running and re-run the checks this often, e.g. 1h, instead of running once")
+        flag.BoolVar(&daemonMode, "daemon", false, "keep running and re-run the checks every -interval, an hour unless it's set")
+        flag.DurationVar(&jitter, "jitter", 0, "with -interval or -daemon wait a random time up to this long before each run")
+        flag.StringVar(&metricsAddr, "metrics-addr", "", "serve the latest results as Prometheus metrics on this address, e.g. :9100, and keep serving after the run")
+        flag.StringVar(&includeStations, "include-stations", "", "comma separated stations to check, the rest are left out")
+        flag.StringVar(&excludeStations, "exclude-stations", "", "comma separated stations to leave out, e.g. decommissioned ones")
+        flag.StringVar(&outputFormat, "format", "csv", "output format, csv, jsonl for one JSON object per row or geojson, optionally followed by check=format overrides, e.g. csv,ratioDiff=geojson")
+        flag.IntVar(&connectAttempts, "connect-attempts", 3, "number of times to try contacting the database before giving up")
+        flag.DurationVar(&connectBackoff, "connect-backoff", 2*time.Second, "wait between connection attempts, doubled after each one, plus random jitter")
+        flag.IntVar(&queryAttempts, "query-attempts", 3, "number of times to try a check's query that fails with a connection error or a replica conflict")
+        flag.DurationVar(&queryBackoff, "query-backoff", time.Second, "wait between query attempts, doubled after each one, plus random jitter")
+        flag.DurationVar(&queryTimeout, "query-timeout", 30*time.Second, "give up on a check's query, or contacting the database, after this long, 0 for no limit")
+        flag.BoolVar(&runDiff, "run-diff", false, "write the stations each check flagged as new, persistent or recovered since the previous run to runDiff.csv")
+        flag.BoolVar(&deltaMode, "delta", false, "write noise counts as changes since the previous run to noiseCountDelta.csv")
+        flag.IntVar(&deltaSnapshotEvery, "delta-snapshot-every", 24, "in -delta mode write a full snapshot every this many runs")
+        flag.BoolVar(&failOnFindings, "fail-on-findings", false, "exit with status 2 when a check finds rows over its threshold")
+        flag.StringVar(&summaryPath, "summary", "", "write a JSON summary of each run's checks, rows, durations, errors and exit status to this file, or - for stdout")
+        flag.BoolVar(&failFast, "fail-fast", false, "stop at the first failing check")
+        flag.StringVar(&colocated, "colocated", "", "comma separated colocated station pairs to compare, e.g. WEL:WEL2,TFSS:TFSS2")
+        flag.Float64Var(&colocatedRatio, "colocated-ratio", 2, "flag a colocated pair when one station's noise count is more than this many times the other's")
+        flag.StringVar(&sourcesFile, "sources", "", "JSON or CSV file of named hazard databases to run the checks against in turn, each with its own output subdirectory")
+        flag.StringVar(&stationRulesFile, "station-rules", "", "JSON or CSV file of per station thresholds and suppression windows")
+        flag.StringVar(&metadataFile, "metadata-file", "", "JSON or CSV file of extra station metadata (network, colocation group, coordinates, sensor type, commissioning date)")
+        flag.StringVar(&metadataPrecedence, "metadata-precedence", "db", "which wins when the database and -metadata-file disagree, \"db\" or \"file\"")
+        flag.IntVar(&floatPrecision, "float-precision", 4, "number of decimal places numeric values are written with")
+        flag.BoolVar(&queryStats, "query-stats", false, "append each check's EXPLAIN estimated cost and rows to queryStats.csv")
+        flag.BoolVar(&queryStatsAnalyze, "query-stats-analyze", false, "use EXPLAIN ANALYZE for -query-stats to also record actual rows and execution time, this runs each query twice")
+        flag.StringVar(&dumpDir, "dump-dir", "", "run the checks against pga.csv, pgv.csv and source.csv dumps in this directory instead of the hazard database")
+        flag.BoolVar(&dedup, "dedup", false, "skip rows already written for the same station and component this hour")
+        flag.BoolVar(&dryRun, "dry-run", false, "run the checks once on a read only connection, printing each query and its rows to stdout without writing or alerting")
+        flag.BoolVar(&dryRunExplain, "dry-run-explain", false, "also print each query's plan with -dry-run")
+        flag.StringVar(&duplicateRun, "duplicate-run", "warn", "what to do when this hour's window has already been processed, \"warn\", \"skip\" or \"off\"")
+        flag.DurationVar(&registryRetention, "registry-retention", 0, "drop run registry entries older than this, zero keeps them all")
+        flag.BoolVar(&traceEvents, "trace-events", false, "log a structured event per check with connection, query, row and write timings")
+        flag.StringVar(&traceFile, "trace-file", "", "write -trace-events to this file instead of the log")
+        flag.BoolVar(&continueOnScanError, "continue-on-scan-error", false, "log and skip rows that fail to scan instead of failing the check")
+        flag.StringVar(&grafanaURL, "grafana-url", "", "post an annotation to this Grafana when an incident starts")
+        flag.StringVar(&grafanaToken, "grafana-token", "", "Grafana API token for -grafana-url, defaults to GRAFANA_TOKEN")
+        flag.StringVar(&alertWebhook, "alert-webhook", "", "POST ratioDiff stations above -ratio-alert-threshold, and the checks that failed, to this webhook, e.g. a Slack incoming webhook")
+        flag.Float64Var(&ratioAlertThreshold, "ratio-alert-threshold", 10, "alert on non blacklisted stations with a PGA ratio above this")
+        flag.IntVar(&grafanaMinStations, "grafana-min-stations", 3, "number of stations flagged by more than one check that makes an incident")
+        flag.StringVar(&sortBy, "sort", "", "re-sort each check's rows before writing them, \"station\" or \"value\" optionally followed by :asc or :desc")
+        flag.BoolVar(&flapping, "blacklist-flapping", false, "check the accumulated history for stations whose blacklist status keeps changing")
+        flag.IntVar(&flappingChanges, "flapping-changes", 3, "flag stations whose blacklist status changed more than this many times in -flapping-window")
+        flag.DurationVar(&flappingWindow, "flapping-window", 7*24*time.Hour, "how far back -blacklist-flapping looks")
+        flag.StringVar(&s3Bucket, "s3-bucket", "", "also upload each check's rows for the run to this S3 bucket")
+        flag.StringVar(&s3Prefix, "s3-prefix", "", "key prefix for -s3-bucket objects, e.g. smqc/prod")
+        flag.StringVar(&s3Partition, "s3-partition", "", "partition -s3-bucket keys by \"date\", \"check\" or both, e.g. date,check")
+        flag.StringVar(&s3SSE, "s3-sse", "AES256", "server-side encryption for -s3-bucket objects, AES256 or aws:kms")
+        flag.StringVar(&s3KMSKey, "s3-kms-key", "", "KMS key id or ARN for -s3-sse aws:kms, the bucket's default key otherwise")
+        flag.BoolVar(&s3Only, "s3-only", false, "only upload to -s3-bucket, don't write the output files locally")
+        flag.StringVar(&resultsWebhook, "results-webhook", "", "comma separated URLs to POST each check's rows for the run to as JSON")
+        flag.StringVar(&resultsWebhookSecret, "results-webhook-secret", "", "sign -results-webhook bodies with HMAC-SHA256 using this secret")
+        flag.IntVar(&resultsWebhookAttempts, "results-webhook-attempts", 3, "how many times to try a -results-webhook POST that fails with a network error, 429 or 5xx")
+        flag.StringVar(&influxURL, "influx-url", "", "also write each check's rows as line protocol to this InfluxDB, e.g. http://influxdb:8086")
+        flag.StringVar(&influxOrg, "influx-org", "", "InfluxDB organization for -influx-url")
+        flag.StringVar(&influxBucket, "influx-bucket", "", "InfluxDB bucket for -influx-url")
+        flag.StringVar(&influxToken, "influx-token", "", "InfluxDB API token for -influx-url, or INFLUX_TOKEN")
+        flag.StringVar(&arrowOut, "arrow", "", "also write each check's rows as an Arrow IPC stream to files in this directory, or - for stdout")
+        flag.StringVar(&parquetOut, "parquet", "", "also write each check's rows as Parquet files partitioned by date under this directory")
+        flag.BoolVar(&newStationsFeed, "new-stations", false, "write stations flagged for the first time ever to newStations.csv")
+        flag.StringVar(&onlyChecks, "checks", "", "comma separated checks to run, e.g. noiseCount,ratioDiff, instead of every enabled one")
+        flag.StringVar(&skipChecks, "skip-checks", "", "comma separated checks not to run")
+        flag.StringVar(&resultsDSN, "results-db", "", "also save the rows to a smqc.results table in this Postgres database, or sqlite:PATH for a SQLite file")
+        flag.StringVar(&quakeFilter, "quake-filter", "off", "what to do with rows explained by a catalogued earthquake, \"off\", \"annotate\" or \"exclude\"")
+        flag.StringVar(&quakeURL, "quake-url", "https://service.geonet.org.nz/fdsnws/event/1/query", "FDSN event service for -quake-filter")
+        flag.Float64Var(&quakeMinMagnitude, "quake-min-magnitude", 4, "smallest earthquake that explains elevated values for -quake-filter")
+        flag.Float64Var(&quakeRadius, "quake-radius", 200, "how far in km from a station an earthquake explains its elevated values, for stations with -metadata-file coordinates")
+        flag.DurationVar(&latencyThreshold, "latency", 0, "rank stations by how old their newest PGA and PGV values are in latency.csv, flagging those this far behind or more, e.g. 15m")
+        flag.BoolVar(&fdsnStationsCheck, "fdsn-stations", false, "compare the stations in impact.source with those open in the FDSN station service's metadata")
+        flag.StringVar(&fdsnStationURL, "fdsn-station-url", "https://service.geonet.org.nz/fdsnws/station/1/query", "FDSN station service for -fdsn-stations")
+        flag.StringVar(&fdsnChannels, "fdsn-channels", "HN?,BN?", "the strong motion channels for -fdsn-stations, as an FDSN channel list")
+        flag.IntVar(&deepCheckStations, "deep-check", 0, "fetch waveforms for this many of the highest scoring stations from -dataselect-url and append each channel's RMS noise and offset to deepCheck.csv")
+        flag.DurationVar(&deepCheckWindow, "deep-check-window", 10*time.Minute, "how much waveform data -deep-check fetches for each station")
+        flag.StringVar(&dataselectURL, "dataselect-url", "https://service.geonet.org.nz/fdsnws/dataselect/1/query", "FDSN dataselect service for -deep-check")
+        flag.StringVar(&notifySlack, "notify-slack", "", "Slack incoming webhook to notify of stations flagged for -notify-runs consecutive runs, and their recovery")
+        flag.StringVar(&notifySMTP, "notify-smtp", "", "SMTP server as host:port to email the same notifications through")
+        flag.StringVar(&notifySMTPUser, "notify-smtp-user", "", "user to authenticate to -notify-smtp as, the password is SMTP_PASSWORD")
+        flag.StringVar(&notifyFrom, "notify-from", "", "From address of notification emails")
+        flag.StringVar(&notifyTo, "notify-to", "", "comma separated addresses to email notifications to")
+        flag.StringVar(&notifyRunsFlag, "notify-runs", strconv.Itoa(defaultNotifyRuns), "consecutive runs a station has to be flagged by a check before notifying, optionally followed by check=runs overrides, e.g. 3,ratioDiff=2")
+        flag.StringVar(&pagerdutyKey, "pagerduty-routing-key", "", "PagerDuty Events API v2 routing key to open incidents for stations over a check's threshold for -escalate-runs consecutive runs, or PAGERDUTY_ROUTING_KEY")
+        flag.StringVar(&pagerdutyURL, "pagerduty-url", "https://events.pagerduty.com/v2/enqueue", "PagerDuty Events API v2 endpoint")
+        flag.StringVar(&opsgenieKey, "opsgenie-api-key", "", "Opsgenie API key to open alerts for stations over a check's threshold for -escalate-runs consecutive runs, or OPSGENIE_API_KEY")
+        flag.StringVar(&opsgenieURL, "opsgenie-url", "https://api.opsgenie.com", "Opsgenie API, e.g. https://api.eu.opsgenie.com for the EU instance")
+        flag.StringVar(&escalateRunsFlag, "escalate-runs", strconv.Itoa(defaultEscalateRuns), "consecutive runs a station has to be over a check's threshold before escalating, optionally followed by check=runs overrides, e.g. 6,dataGap=3")
+        flag.StringVar(&escalateStations, "escalate-stations", "", "comma separated stations to escalate, instead of every station")
+        flag.BoolVar(&baselineReport, "baseline", false, "append stations far from their own history's median noise count or ratio to baseline.csv")
+        flag.DurationVar(&baselineWindow, "baseline-window", 14*24*time.Hour, "how much history -baseline compares each station with")
+        flag.Float64Var(&baselineSigma, "baseline-sigma", 3, "flag a station more than this many robust standard deviations from its median for -baseline")
+        flag.IntVar(&baselineMinRuns, "baseline-min-runs", 24, "earlier runs a station needs in the history before -baseline compares it")
+        flag.BoolVar(&healthScoreReport, "health-score", false, "append a score combining every check for each flagged station to healthScore.csv")
+        flag.StringVar(&healthWeightsFlag, "health-weights", "", "comma separated check=weight for -health-score, checks not listed have weight 1")
+        flag.StringVar(&partitionBy, "partition-by", "", "write output into subdirectories of the output directory by \"network\" or \"region\"")
+        flag.StringVar(&groupBy, "group-by", "", "summarise each check's results by \"network\" or \"region\" in groupSummary")
+}
+
+func main() {
+        flag.Parse()
+
+        if err := applySettings(); err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+        if err := setupLogging(); err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+        newRunID()
+
+        // Before the -summary files it names are removed.
+        if sourcesFile != "" {
+                var err error
+                if sources, err = loadSources(sourcesFile); err != nil {
+                        trace.Fatalf("ERROR: %s", err)
+                }
+        }
+
+        // Not for a subcommand, which doesn't write one.
+        if flag.NArg() == 0 {
+                clearSummary()
+        }
+
+        if summaryPath == "-" && (logOutput == "stdout" || dryRun) {
+                trace.Fatalf("ERROR: -summary - can't be used with -log-output stdout or -dry-run, which also write to stdout")
+        }
+        if dryRunExplain && !dryRun {
+                trace.Fatalf("ERROR: -dry-run-explain needs -dry-run")
+        }
+        if dryRun {
+                if daemonMode || interval > 0 || backfill {
+                        trace.Fatalf("ERROR: -dry-run runs the checks once, it can't be used with -daemon, -interval or -backfill")
+                }
+                if logOutput == "stdout" {
+                        trace.Fatalf("ERROR: -log-output stdout can't be used with -dry-run")
+                }
+                disableSideEffects()
+        }
+
+        for _, g := range []struct{ name, value string }{{"partition-by", partitionBy}, {"group-by", groupBy}} {
+                if g.value != "" && g.value != "network" && g.value != "region" {
+                        trace.Fatalf("ERROR: unknown -%s %q, expected network or region", g.name, g.value)
+                }
+                if g.value == "region" && metadataFile == "" {
+                        trace.Fatalf("ERROR: -%s region needs -metadata-file", g.name)
+                }
+        }
+
+        includeStations = stationList(includeStations)
+        excludeStations = stationList(excludeStations)
+
+        var err error
+        defaultFormat, checkFormats, err = parseFormats(outputFormat)
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+        if noiseCountMin < 0 {
+                trace.Fatalf("ERROR: -noise-count-min must not be negative")
+        }
+        // Checked here as well as when connecting so a bad TLS setup fails before anything
+        // else happens.
+        if dumpDir == "" && dsnFile == "" {
+                if err := hazardDB.validateSSL(); err != nil {
+                        trace.Fatalf("ERROR: %s", err)
+                }
+        }
+
+        if interval < 0 {
+                trace.Fatalf("ERROR: -interval must not be negative")
+        }
+        if daemonMode && interval == 0 {
+                interval = time.Hour
+        }
+        if jitter < 0 {
+                trace.Fatalf("ERROR: -jitter must not be negative")
+        }
+        if jitter > 0 && jitter >= interval {
+                trace.Fatalf("ERROR: -jitter must be less than -interval")
+        }
+        if err := parseWindow(); err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+        if rotateEvery != "" && rotateEvery != "monthly" && rotateEvery != "daily" {
+                trace.Fatalf("ERROR: unknown -rotate %q", rotateEvery)
+        }
+        if rotateSize < 0 {
+                trace.Fatalf("ERROR: -rotate-size must not be negative")
+        }
+        if logKeep < 0 {
+                trace.Fatalf("ERROR: -log-keep must not be negative")
+        }
+        if connectAttempts < 1 {
+                trace.Fatalf("ERROR: -connect-attempts must be at least 1")
+        }
+        if queryAttempts < 1 {
+                trace.Fatalf("ERROR: -query-attempts must be at least 1")
+        }
+        if flatlineMinValues < 2 {
+                trace.Fatalf("ERROR: -flatline-min-values must be at least 2")
+        }
+        if flatlineSpread < 0 {
+                trace.Fatalf("ERROR: -flatline-spread must not be negative")
+        }
+        if dataGapFraction < 0 || dataGapFraction > 1 {
+                trace.Fatalf("ERROR: -data-gap-fraction must be between 0 and 1")
+        }
+        if spikePGA <= 0 || spikePGVRatio <= 1 || spikeFloor < 0 {
+                trace.Fatalf("ERROR: -spike-pga must be positive, -spike-pgv-ratio over 1 and -spike-floor not negative")
+        }
+        if mmiFeltLevel < 1 || mmiFeltLevel > 12 || mmiFeltCount < 1 {
+                trace.Fatalf("ERROR: -mmi-felt must be between 1 and 12 and -mmi-felt-count at least 1")
+        }
+        if baselineWindow <= 0 || baselineSigma <= 0 || baselineMinRuns < 1 {
+                trace.Fatalf("ERROR: -baseline-window and -baseline-sigma must be positive and -baseline-min-runs at least 1")
+        }
+
+        if componentsFlag != "" {
+                components = strings.Split(stationList(componentsFlag), ",")
+                if err := smqc.ValidateComponents(components); err != nil {
+                        trace.Fatalf("ERROR: -components: %s", err)
+                }
+        }
+
+        if limit < 1 {
+                trace.Fatalf("ERROR: -limit must be at least 1")
+        }
+        if floatPrecision < 0 {
+                trace.Fatalf("ERROR: -float-precision must not be negative")
+        }
+
+        sortField, sortDesc, err = parseSort(sortBy)
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+
+        if s3Partitions, err = parseS3Partition(s3Partition); err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+        if s3SSE != "AES256" && s3SSE != "aws:kms" {
+                trace.Fatalf("ERROR: unknown -s3-sse %q, expected AES256 or aws:kms", s3SSE)
+        }
+        if s3KMSKey != "" && s3SSE != "aws:kms" {
+                trace.Fatalf("ERROR: -s3-kms-key needs -s3-sse aws:kms")
+        }
+        if s3Bucket != "" && awsRegion == "" {
+                trace.Fatalf("ERROR: -s3-bucket needs -aws-region or AWS_REGION")
+        }
+        if s3Only && s3Bucket == "" {
+                trace.Fatalf("ERROR: -s3-only needs -s3-bucket")
+        }
+        if latencyThreshold < 0 {
+                trace.Fatalf("ERROR: -latency must not be negative")
+        }
+        if deepCheckStations < 0 {
+                trace.Fatalf("ERROR: -deep-check must be 0 or more")
+        }
+        if deepCheckWindow <= 0 {
+                trace.Fatalf("ERROR: -deep-check-window must be positive")
+        }
+        if resultsWebhookAttempts < 1 {
+                trace.Fatalf("ERROR: -results-webhook-attempts must be at least 1")
+        }
+        if influxURL != "" && influxBucket == "" {
+                trace.Fatalf("ERROR: -influx-url needs -influx-bucket")
+        }
+        // These read back the local files.
+        if s3Only && (flapping || newStationsFeed || dedup || baselineReport) {
+                trace.Fatalf("ERROR: -s3-only can't be used with -blacklist-flapping, -new-stations, -dedup or -baseline")
+        }
+
+        if arrowOut != "" && arrowOut != "-" {
+                if err := os.MkdirAll(arrowOut, 0777); err != nil {
+                        trace.Fatalf("ERROR: creating -arrow directory: %s", err)
+                }
+        }
+        if parquetOut != "" {
+                if err := os.MkdirAll(parquetOut, 0777); err != nil {
+                        trace.Fatalf("ERROR: creating -parquet directory: %s", err)
+                }
+        }
+
+        if duplicateRun != "warn" && duplicateRun != "skip" && duplicateRun != "off" {
+                trace.Fatalf("ERROR: unknown -duplicate-run %q", duplicateRun)
+        }
+
+        healthWeights, err = parseHealthWeights(healthWeightsFlag)
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+
+        notifyRuns, notifyRunOverrides, err = parseRuns("notify-runs", notifyRunsFlag, defaultNotifyRuns)
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+        if notifySMTP != "" && (notifyFrom == "" || notifyTo == "") {
+                trace.Fatalf("ERROR: -notify-smtp needs -notify-from and -notify-to")
+        }
+        escalateRuns, escalateRunOverrides, err = parseRuns("escalate-runs", escalateRunsFlag, defaultEscalateRuns)
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+
+        if quakeFilter != "off" && quakeFilter != "annotate" && quakeFilter != "exclude" {
+                trace.Fatalf("ERROR: unknown -quake-filter %q", quakeFilter)
+        }
+
+        if metadataPrecedence != "db" && metadataPrecedence != "file" {
+                trace.Fatalf("ERROR: unknown -metadata-precedence %q", metadataPrecedence)
+        }
+
+        if traceFile != "" {
+                file, err := os.OpenFile(traceFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+                if err != nil {
+                        trace.Fatalf("ERROR: opening trace file: %s", err)
+                }
+                defer file.Close()
+                eventLog = slog.New(newLogHandler(file, logFormat))
+        }
+
+        colocatedPairs, err = parseColocated(colocated)
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+
+        if stationRulesFile != "" {
+                stationRules, err = loadStationRules(stationRulesFile)
+                if err != nil {
+                        trace.Fatalf("ERROR: %s", err)
+                }
+                trace.Printf("Read %d station rules from %s", len(stationRules), stationRulesFile)
+        }
+
+        if metadataFile != "" {
+                metadata, err = loadMetadata(metadataFile)
+                if err != nil {
+                        trace.Fatalf("ERROR: %s", err)
+                }
+                trace.Printf("Read metadata for %d stations from %s", len(metadata), metadataFile)
+
+                seen := map[colocatedPair]bool{}
+                for _, p := range colocatedPairs {
+                        seen[p], seen[colocatedPair{p.b, p.a}] = true, true
+                }
+                for _, p := range metadataColocatedPairs() {
+                        if !seen[p] {
+                                colocatedPairs = append(colocatedPairs, p)
+                        }
+                }
+        }
+
+        // Subcommands that don't run the checks. They're run by hand so errors are shown
+        // as well as logged.
+        subcommands := map[string]func([]string) error{
+                "mark-false-positive": markFalsePositive,
+                "false-positive-report": falsePositiveReport,
+                "weekly-report": weeklyReport,
+                "dashboard": dashboardCommand,
+                "blacklist": blacklistCommand,
+                "test-notify": testNotify,
+        }
+        if flag.NArg() > 0 {
+                sub, ok := subcommands[flag.Arg(0)]
+                if !ok {
+                        fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+                        trace.Fatalf("ERROR: unknown command %q", flag.Arg(0))
+                }
+                if err := sub(flag.Args()[1:]); err != nil {
+                        fmt.Fprintf(os.Stderr, "%s: %s\n", flag.Arg(0), err)
+                        trace.Fatalf("ERROR: %s: %s", flag.Arg(0), err)
+                }
+                return
+        }
+
+        checks, err := selectChecks(onlyChecks, skipChecks)
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+
+        runStart = runAt()
+        setWindow(runStart)
+        window := runWindow(runStart)
+
+        // -backfill reads the registry for each hour, and each of -sources has its own.
+        var windows []time.Time
+        if duplicateRun != "off" && !backfill && len(sources) == 0 {
+                windows, err = readRegistry()
+                if err != nil {
+                        trace.Fatalf("ERROR: reading run registry: %s", err)
+                }
+
+                if interval == 0 && skipWindow(windows, window) {
+                        return
+                }
+        }
+
+        // Each of -sources is opened as it's first checked.
+        var db *sql.DB
+        switch {
+        case len(sources) > 0:
+        case dumpDir != "":
+                db, err = openDump(dumpDir)
+                if err != nil {
+                        trace.Fatalf("ERROR: opening dump: %s", err)
+                }
+        default:
+                db = openHazard()
+        }
+        defer closeDatabases(db) // Pretty cool
+
+        if needNetworks() && db != nil {
+                // Without them the stations are in the unknown network rather than the run
+                // being lost.
+                networks, err := loadStationNetworks(db)
+                if err != nil {
+                        trace.Printf("WARNING: loading station networks: %s", err)
+                }
+                stationNetworks = mergeNetworks(networks)
+        }
+
+        if resultsDSN != "" {
+                resultsDB, err = openResultsDB(resultsDSN)
+                if err != nil {
+                        trace.Fatalf("ERROR: opening -results-db: %s", err)
+                }
+                defer resultsDB.Close()
+        }
+
+
+        if metricsAddr != "" {
+                if err := serveMetrics(metricsAddr); err != nil {
+                        trace.Fatalf("ERROR: serving metrics: %s", err)
+                }
+        }
+
+        if interval > 0 {
+                daemon(db, checks)
+                return
+        }
+
+        cancelOnSignal()
+
+        if backfill {
+                failedHours, concerns := runBackfill(db, checks)
+                if failedHours > 0 {
+                        closeDatabases(db)
+                        trace.Fatalf("ERROR: checks failed for %d hours of the backfill", failedHours)
+                }
+                if failOnFindings && concerns > 0 {
+                        closeDatabases(db)
+                        trace.Printf("%d rows over threshold, exiting with status 2 for -fail-on-findings", concerns)
+                        os.Exit(2)
+                }
+                return
+        }
+
+        var failed, concerns int
+        if len(sources) > 0 {
+                failed, concerns = runSources(checks, runStart)
+        } else {
+                failed, concerns = runChecks(db, setupChecks(checks), windows, window)
+        }
+
+        if metricsAddr != "" {
+                waitForStop()
+        }
+
+        if failed > 0 {
+                closeDatabases(db)
+                total := len(checks)
+                if len(sources) > 0 {
+                        total *= len(sources)
+                }
+                trace.Fatalf("ERROR: %d of %d checks failed", failed, total)
+        }
+
+        // A different status to a failed check so a pipeline can tell them apart.
+        if failOnFindings && concerns > 0 {
+                closeDatabases(db)
+                trace.Printf("%d rows over threshold, exiting with status 2 for -fail-on-findings", concerns)
+                os.Exit(2)
+        }
+}
+
+// runChecks runs the checks once for window, returning how many failed and how many rows
+// they found that are a concern.
+func runChecks(db *sql.DB, checks []check, windows []time.Time, window time.Time) (int, int) {
+        // The checks that don't depend on each other run at the same time, each writing its
+        // own files. A failure is logged but doesn't stop the others, unless -fail-fast.
+        errs := make([]error, len(checks))
+        results := make([]checkResult, len(checks))
+        durations := make([]time.Duration, len(checks))
+        // started is whether each check was run at all, one that ran and found nothing has
+        // a zero result too.
+        started := make([]bool, len(checks))
+        began := time.Now()
+
+        if quakeFilter != "off" {
+                loadQuakes(runStart)
+        }
+        loadBlacklistOverrides()
+        if runDiff {
+                loadLastOffenders()
+        }
+
+        // With -fail-fast the first check to fail cancels the others' queries.
+        ctx, cancel := context.WithCancelCause(runCtx)
+        defer cancel(nil)
+
+        var wg sync.WaitGroup
+        for i, c := range checks {
+                if c.After() {
+                        continue
+                }
+
+                wg.Add(1)
+                started[i] = true
+                go func(i int, c check) {
+                        defer wg.Done()
+
+                        trace.Println(c.Description())
+                        start := time.Now()
+                        if results[i], errs[i] = runCheck(ctx, db, c); errs[i] != nil {
+                                trace.Error("check failed", "check", c.Name(), "error", errs[i])
+                                if failFast {
+                                        cancel(fmt.Errorf("-fail-fast stopped the run after %s failed", c.Name()))
+                                }
+                        }
+                        durations[i] = time.Since(start)
+                }(i, c)
+        }
+        wg.Wait()
+
+        var failed int
+        for _, err := range errs {
+                if err != nil {
+                        failed++
+                }
+        }
+
+        // -fail-fast doesn't go on to the checks that would be working from incomplete
+        // results.
+        for i, c := range checks {
+                if !c.After() || (failFast && failed > 0) {
+                        continue
+                }
+
+                trace.Println(c.Description())
+                started[i] = true
+                start := time.Now()
+                if results[i], errs[i] = runCheck(ctx, db, c); errs[i] != nil {
+                        failed++
+                        trace.Error("check failed", "check", c.Name(), "error", errs[i])
+                }
+                durations[i] = time.Since(start)
+        }
+
+        if err := writeQuakeExplained(); err != nil {
+                trace.Printf("ERROR: writing quakeExplained.csv: %s", err)
+        }
+
+        if grafanaURL != "" {
+                annotateIncident(time.Now())
+        }
+
+        var ran []string
+        for i, c := range checks {
+                if started[i] && errs[i] == nil {
+                        ran = append(ran, c.Name())
+                }
+        }
+        if runDiff {
+                diffRuns(ran)
+        }
+        if notifySlack != "" || notifySMTP != "" {
+                notifyPersistent(ran)
+        }
+        if escalating() {
+                escalatePersistent(ran)
+        }
+
+        // Only a run that wrote something, and wasn't cancelled part way, counts as having
+        // processed the window.
+        if duplicateRun != "off" && failed < len(checks) && runCtx.Err() == nil {
+                if err := recordWindow(windows, window); err != nil {
+                        trace.Printf("ERROR: updating run registry: %s", err)
+                }
+        }
+
+        id, _ := runID.Load().(string)
+        summary := runSummary{RunID: id, Source: currentSource, Start: runStart.Format(time.RFC3339), Window: window.Format(time.RFC3339)}
+
+        // One line per check so the end of the log says how the run went.
+        var (
+                concerns int
+                failures []checkFailure
+        )
+        for i, c := range checks {
+                cs := checkSummary{Check: c.Name(), Duration: seconds(durations[i])}
+                switch {
+                case errs[i] != nil:
+                        trace.Info("check finished", "check", c.Name(), "status", "failed")
+                        cs.Status, cs.Error = "failed", errs[i].Error()
+                        failures = append(failures, checkFailure{c.Name(), errs[i].Error()})
+                case !started[i]:
+                        trace.Info("check finished", "check", c.Name(), "status", "not run")
+                        cs.Status = "not run"
+                default:
+                        trace.Info("check finished", "check", c.Name(), "status", "ok", "rows", results[i].rows, "concerns", results[i].concerns)
+                        concerns += results[i].concerns
+                        cs.Status, cs.Rows, cs.Concerns = "ok", results[i].rows, results[i].concerns
+                }
+                summary.Checks = append(summary.Checks, cs)
+        }
+
+        if summaryPath != "" {
+                summary.Duration = seconds(time.Since(began))
+                writeSummary(summary)
+        }
+
+        if alertWebhook != "" {
+                sendFailureAlert(failures)
+        }
+
+        return failed, concerns
+}
+
+// stationList tidies a comma separated list of stations for binding to a query, matching
+// is on the list wrapped in commas so there can't be any spaces or empty entries.
+func stationList(s string) string {
+        var stations []string
+        for _, station := range strings.Split(s, ",") {
+                if station = strings.TrimSpace(station); station != "" {
+                        stations = append(stations, station)
+                }
+        }
+        return strings.Join(stations, ",")
+}
+
+// queryParams are the flags that build check's query with the smqc query builder, with
+// check's own -exclude-stations and -station-rules suppressions.
+func queryParams(check string) smqc.QueryParams {
+        return smqc.QueryParams{
+                Include: includeStations,
+                Exclude: excludeFor(check),
+                From: queryFrom,
+                To: queryTo,
+                NoiseCountMin: noiseCountQueryMin(),
+                Limit: limit,
+                Components: components,
+                SkipBlacklisted: skipBlacklisted,
+        }
+}
+
+// checkResult is what a check found: the rows it wrote and how many of those are over the
+// check's threshold for being a concern, leaving out blacklisted stations.
+type checkResult struct {
+        rows int
+        concerns int
+}
+
+// runCheck runs c, emitting its trace event with -trace-events and updating its metrics.
+func runCheck(ctx context.Context, db *sql.DB, c check) (result checkResult, err error) {
+        tr := newCheckTrace(c.Name())
+        tr.ctx = ctx
+        defer func() {
+                tr.emit()
+                recordCheckMetrics(tr, result, err)
+        }()
+
+        // A check that panics fails like any other rather than taking the run, and its exit
+        // status, down with it.
+        defer func() {
+                if p := recover(); p != nil {
+                        trace.Printf("ERROR: %s panicked: %v\n%s", c.Name(), p, debug.Stack())
+                        result, err = checkResult{}, fmt.Errorf("panic: %v", p)
+                }
+        }()
+
+        if !traceEvents {
+                return c.Run(db, tr)
+        }
+
+        conn, err := db.Conn(ctx)
+        tr.acquire = time.Since(tr.start)
+        if err != nil {
+                return checkResult{}, fmt.Errorf("acquiring connection: %w", err)
+        }
+        defer conn.Close()
+
+        // On a single connection so that getting it from the pool is timed separately
+        // from running the query.
+        return c.Run(conn, tr)
+}
+
+// runCtx is cancelled by SIGINT or SIGTERM, ending the queries in progress so each check
+// fails instead of the process being killed part way through writing.
+var runCtx, cancelRun = context.WithCancel(context.Background())
+
+// cancelOnSignal cancels runCtx on the first SIGINT or SIGTERM.
+func cancelOnSignal() {
+        sigs := make(chan os.Signal, 1)
+        signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+        go func() {
+                s := <-sigs
+                trace.Printf("Cancelling the checks on %s", s)
+                cancelRun()
+        }()
+}
+
+// queryContext bounds a query by -query-timeout, 0 leaves it unbounded.
+func queryContext() (context.Context, context.CancelFunc) {
+        return boundQuery(runCtx)
+}
+
+// queryContext is queryContext for the check's query, which is also cancelled when
+// -fail-fast stops the run's other checks.
+func (t *checkTrace) queryContext() (context.Context, context.CancelFunc) {
+        return boundQuery(t.ctx)
+}
+
+func boundQuery(parent context.Context) (context.Context, context.CancelFunc) {
+        if queryTimeout <= 0 {
+                return context.WithCancel(parent)
+        }
+        return context.WithTimeout(parent, queryTimeout)
+}
+
+// queryError says when err is because the query ran out of time or the run was
+// cancelled, the driver's own error for a cancelled statement doesn't.
+func queryError(ctx context.Context, err error) error {
+        if err == nil {
+                return nil
+        }
+        switch {
+        case ctx.Err() == context.DeadlineExceeded:
+                return fmt.Errorf("query timed out after %s: %w", queryTimeout, err)
+        case runCtx.Err() != nil:
+                return fmt.Errorf("query cancelled: %w", err)
+        case ctx.Err() != nil:
+                return fmt.Errorf("query cancelled, %s: %w", context.Cause(ctx), err)
+        }
+        return err
+}
+
+// dbConfig is where the hazard database is. The defaults are the production read replica
+// and each can be set by flag or environment variable.
+type dbConfig struct {
+        host string
+        port string
+        name string
+        user string
+        password string
+        sslMode string
+        sslRootCert string
+}
+
+func (c dbConfig) validate() error {
+        for _, f := range []struct{ name, value string }{
+                {"-db-host", c.host},
+                {"-db-port", c.port},
+                {"-db-name", c.name},
+                {"-db-user", c.user},
+                {"password", c.password},
+        } {
+                if f.value == "" {
+                        return fmt.Errorf("%s must not be empty", f.name)
+                }
+        }
+
+        if _, err := strconv.Atoi(c.port); err != nil {
+                return fmt.Errorf("invalid -db-port %q", c.port)
+        }
+
+        return c.validateSSL()
+}
+
+func (c dbConfig) validateSSL() error {
+        switch c.sslMode {
+        case "disable", "require":
+        case "verify-ca", "verify-full":
+                if c.sslRootCert == "" {
+                        return fmt.Errorf("-sslmode %s needs -sslrootcert to verify the server against", c.sslMode)
+                }
+        default:
+                return fmt.Errorf("unknown -sslmode %q, expected disable, require, verify-ca or verify-full", c.sslMode)
+        }
+
+        return nil
+}
+
+func (c dbConfig) dsn() string {
+        q := url.Values{"sslmode": {c.sslMode}}
+        if c.sslRootCert != "" {
+                q.Set("sslrootcert", c.sslRootCert)
+        }
+
+        u := url.URL{
+                Scheme: "postgres",
+                User: url.UserPassword(c.user, c.password),
+                Host: net.JoinHostPort(c.host, c.port),
+                Path: "/" + c.name,
+                RawQuery: q.Encode(),
+        }
+
+        return u.String()
+}
+
+// envOr is the environment variable key, or def when it isn't set.
+func envOr(key, def string) string {
+        if v, ok := os.LookupEnv(key); ok {
+                return v
+        }
+        return def
+}
+
+// hazardDSN gives the connection string for the hazard database. -dsn-file,
+// -password-file and -db-secret take precedence over HAZARD_PASSWD.
+func hazardDSN() (string, error) {
+        if dsnFile != "" {
+                return readSecretFile(dsnFile)
+        }
+
+        c := hazardDB
+
+        if passwordFile != "" {
+                p, err := readSecretFile(passwordFile)
+                if err != nil {
+                        return "", err
+                }
+                c.password = p
+        } else if dbSecret != "" {
+                if err := applySecret(&c, dbSecret); err != nil {
+                        return "", err
+                }
+        } else {
+                p, ok := os.LookupEnv("HAZARD_PASSWD")
+                if !ok {
+                        return "", errors.New("HAZARD_PASSWD not set for environment.")
+                }
+                c.password = p
+        }
+
+        if err := c.validate(); err != nil {
+                return "", err
+        }
+
+        return c.dsn(), nil
+}
+
+// readSecretFile reads a secret mounted as a file, e.g. a Kubernetes secret or Vault agent
+// template, ignoring trailing whitespace and newlines.
+func readSecretFile(path string) (string, error) {
+        b, err := os.ReadFile(path)
+        if err != nil {
+                return "", err
+        }
+
+        secret := strings.TrimRight(string(b), " \t\r\n")
+        if secret == "" {
+                return "", fmt.Errorf("%s is empty", path)
+        }
+
+        return secret, nil
+}
+
+func openHazard() *sql.DB {
+        db, err := openHazardDB()
+        if err != nil {
+                trace.Fatalf("ERROR: %s", err)
+        }
+        return db
+}
+
+func openHazardDB() (*sql.DB, error) {
+        var connector driver.Connector
+
+        if dbIAMAuth {
+                c, err := hazardIAMConnector()
+                if err != nil {
+                        return nil, fmt.Errorf("problem with DB config: %w", err)
+                }
+                connector = c
+        } else {
+                dsn, err := hazardDSN()
+                if err != nil {
+                        return nil, err
+                }
+
+                c, err := dsnConnector(dsn)
+                if err != nil {
+                        return nil, fmt.Errorf("problem with DB config: %w", err)
+                }
+                connector = c
+        }
+
+        return openConnector(connector)
+}
+
+// dsnConnector connects to a Postgres DSN with -keepalive.
+func dsnConnector(dsn string) (driver.Connector, error) {
+        c, err := pq.NewConnector(dsn)
+        if err != nil {
+                return nil, err
+        }
+        c.Dialer(&keepAliveDialer{net.Dialer{KeepAlive: keepAlive}})
+        return c, nil
+}
+
+// openConnector is the pool for a hazard database, once it's answering.
+func openConnector(connector driver.Connector) (*sql.DB, error) {
+        if dryRun {
+                connector = readOnlyConnector{connector}
+        }
+
+        db := sql.OpenDB(connector)
+        db.SetConnMaxIdleTime(connMaxIdle)
+
+        // Straight after the VPN comes up, or during an RDS failover, the first attempt can
+        // fail so back off and try again a few times before giving up on the run.
+        backoff := connectBackoff
+        for attempt := 1; ; attempt++ {
+                err := ping(db)
+                if err == nil {
+                        break
+                }
+                if attempt >= connectAttempts {
+                        db.Close()
+                        return nil, fmt.Errorf("Can't contact DB after %d attempts: %w", attempt, err)
+                }
+
+                wait := withJitter(backoff)
+                trace.Printf("WARNING: contacting DB, attempt %d of %d: %s, retrying in %s", attempt, connectAttempts, err, wait)
+                time.Sleep(wait)
+                backoff *= 2
+        }
+
+        return db, nil
+}
+
+func ping(db *sql.DB) error {
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        return queryError(ctx, db.PingContext(ctx))
+}
+
+// libraryCheck runs one of the smqc package's checks for the command. It's the querier the
+// package queries with, retrying and printing -dry-run queries as queryRetry does and
+// timing the query for the trace, and it sets the package's options from the flags.
+type libraryCheck struct {
+        db querier
+        tr *checkTrace
+        check string
+        scan *rowScanner
+
+        // err is the query's own error, reported with queryError.
+        err error
+}
+
+func newLibraryCheck(db querier, tr *checkTrace, check string) *libraryCheck {
+        return &libraryCheck{db: db, tr: tr, check: check, scan: &rowScanner{check: check}}
+}
+
+func (c *libraryCheck) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+        c.tr.querying()
+        rows, err := queryRetry(ctx, c.db, c.check, query, args...)
+        c.tr.executed()
+
+        c.err = err
+        return rows, err
+}
+
+func (c *libraryCheck) options() smqc.Options {
+        return smqc.Options{
+                To: queryTo,
+                Blacklist: blacklistFlags(),
+                SkipScanError: c.scan.skip,
+                Explained: c.explained,
+        }
+}
+
+// explained is called with each row as it's read, so it's where the trace counts them. A
+// noiseCount row under its threshold isn't written so isn't looked up, which would list
+// it in quakeExplained.csv.
+func (c *libraryCheck) explained(check string, r smqc.Result) bool {
+        c.tr.row()
+        c.scan.row++
+
+        switch r := r.(type) {
+        case smqc.NoiseCountResult:
+                if _, ok := noiseCountThreshold(r.Station, r.Component, r.NoiseCount); !ok {
+                        return false
+                }
+                return explainedByQuake(check, r.Station, r.Timestamp.Format(time.RFC3339))
+        case smqc.RatioResult:
+                return explainedByQuake(check, r.Station, r.Timestamp.Format(time.RFC3339))
+        }
+        return false
+}
+
+// error is the error the check fails with for the package's, as its own row scanning
+// reported them.
+func (c *libraryCheck) error(ctx context.Context, err error) error {
+        var (
+                scanErr *smqc.ScanError
+                rowsErr *smqc.RowsError
+        )
+        switch {
+        case err == nil:
+                return nil
+        case c.err != nil:
+                return queryError(ctx, c.err)
+        case errors.As(err, &scanErr):
+                return fmt.Errorf("scanning row %d: %w", scanErr.Row, scanErr.Err)
+        case errors.As(err, &rowsErr):
+                c.scan.row = rowsErr.Row
+                return c.scan.ended(ctx, rowsErr.Err)
+        }
+        return err
+}
+
+/* https://wiki.geonet.org.nz/display/dmcops/Strong+Motion+Noise+checks#StrongMotionNoisechecks-ConstantReportingCountNoise */
+func noiseCount(db querier, tr *checkTrace) (checkResult, error) {
+        q := smqc.NoiseCountQuery(queryParams("noiseCount"))
+        recordQueryStats(db, "noiseCount", q.SQL, q.Args...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        c := newLibraryCheck(db, tr, "noiseCount")
+        defer c.scan.report()
+
+        // The rows read before an error that ends them are still written.
+        results, err := smqc.NoiseCountFrom(ctx, c, q, c.options())
+        if c.err != nil {
+                return checkResult{}, c.error(ctx, err)
+        }
+
+        resetGauge(noiseCountGauge)
+
+        var (
+                rows []noiseRow
+                concerns int
+        )
+        for _, r := range results {
+                threshold, ok := noiseCountThreshold(r.Station, r.Component, r.NoiseCount)
+                if !ok {
+                        continue
+                }
+                if r.Explained && quakeFilter == "exclude" {
+                        continue
+                }
+                blacklist := strconv.FormatBool(r.Blacklist)
+                if !r.Explained {
+                        flagStation("noiseCount", r.Station, blacklist)
+                }
+                if !r.Explained && !r.Blacklist && r.NoiseCount > threshold {
+                        concerns++
+                        overThreshold("noiseCount", r.Station)
+                }
+                noiseCountGauge.WithLabelValues(r.Station, r.Component, blacklist, currentSource).Set(float64(r.NoiseCount))
+                rows = append(rows, noiseRow{r.Timestamp.Format(time.RFC3339), r.Station, blacklist, r.Component, r.NoiseCount})
+        }
+
+        if deltaMode {
+                if err != nil {
+                        return checkResult{}, c.error(ctx, err)
+                }
+
+                done := tr.writing()
+                defer done()
+                if err := writeNoiseDeltas(rows); err != nil {
+                        return checkResult{}, err
+                }
+                return checkResult{rows: len(rows), concerns: concerns}, nil
+        }
+
+        out := newCheckOutput("noiseCount", noiseCountColumns...)
+        defer out.Close()
+
+        for _, r := range rows {
+                done := tr.writing()
+                err := out.write(r.station, float64(r.count), r.timestamp, r.station, r.blacklist, r.component, r.count)
+                done()
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+        if err != nil {
+                return checkResult{}, c.error(ctx, err)
+        }
+
+        done := tr.writing()
+        defer done()
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
+
+/* https://wiki.geonet.org.nz/display/dmcops/Strong+Motion+Noise+checks#StrongMotionNoisechecks-PGAVerticalversusPGAHorizontalRatioNoise */
+func ratioDiff(db querier, tr *checkTrace) (checkResult, error) {
+        q := smqc.RatioDiffQuery(queryParams("ratioDiff"))
+        recordQueryStats(db, "ratioDiff", q.SQL, q.Args...)
+
+        ctx, cancel := tr.queryContext()
+        defer cancel()
+
+        c := newLibraryCheck(db, tr, "ratioDiff")
+        defer c.scan.report()
+
+        results, err := smqc.RatiosFrom(ctx, c, "ratioDiff", q, c.options())
+        if c.err != nil {
+                return checkResult{}, c.error(ctx, err)
+        }
+
+        out := newCheckOutput("ratioDiff", ratioDiffColumns...)
+        defer out.Close()
+
+        resetGauge(pgaRatioGauge)
+
+        var (
+                alerts []ratioAlert
+                concerns int
+        )
+
+        for _, r := range results {
+                if r.Explained && quakeFilter == "exclude" {
+                        continue
+                }
+                blacklist := strconv.FormatBool(r.Blacklist)
+                if !r.Explained {
+                        flagStation("ratioDiff", r.Station, blacklist)
+                }
+                pgaRatioGauge.WithLabelValues(r.Station, blacklist, currentSource).Set(r.Ratio)
+
+                at := r.Timestamp.Format(time.RFC3339)
+                if !r.Explained && r.Ratio > stationThreshold("ratioDiff", r.Station, ratioAlertThreshold) && !r.Blacklist {
+                        concerns++
+                        overThreshold("ratioDiff", r.Station)
+                        if alertWebhook != "" {
+                                alerts = append(alerts, ratioAlert{r.Station, r.Ratio, r.MaxVertical, r.MaxHorizontal, at, offenderStatus("ratioDiff", r.Station)})
+                        }
+                }
+
+                done := tr.writing()
+                err := out.write(r.Station, r.Ratio, at, r.Station, blacklist, r.Ratio, r.MaxVertical, r.MaxHorizontal)
+                done()
+                if err != nil {
+                        return checkResult{}, fmt.Errorf("writing file: %w", err)
+                }
+        }
+        if err != nil {
+                return checkResult{}, c.error(ctx, err)
+        }
+
+        // Before writing, the alerts are wanted even if the file can't be written.
+        sendRatioAlerts(alerts)
+
+        done := tr.writing()
+        defer done()
+
+        if err := out.flush(); err != nil {
+                return checkResult{}, err
+        }
+
+        return checkResult{rows: out.count, concerns: concerns}, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+        "bytes"
+        "errors"
+        "io"
+        "path/filepath"
+        "strings"
+        "testing"
+        "time"
+
+        "github.com/DATA-DOG/go-sqlmock"
+        "github.com/mabznz/smqc/smqc"
+)
+
+// memorySink keeps each file the checks write in a buffer, keyed by path.
+type memorySink map[string]*bytes.Buffer
+
+func (m memorySink) Open(path string, header []string) (io.Writer, bool, error) {
+        b := &bytes.Buffer{}
+        m[path] = b
+        return b, true, nil
+}
+
+// captureOutput sends every file the checks write to a memorySink.
+func captureOutput(t *testing.T) memorySink {
+        t.Helper()
+
+        files := memorySink{}
+        orig := sink
+        sink = files
+        t.Cleanup(func() { sink = orig })
+
+        return files
+}
+
+// unbounded is what the window parameters are bound to without -from or -window.
+var unbounded = time.Time{}.Format(windowTimeLayout)
+
+func newMock(t *testing.T) (sqlmock.Sqlmock, querier) {
+        t.Helper()
+
+        db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { db.Close() })
+
+        return mock, db
+}
+
+func TestNoiseCount(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        // As Postgres returns CURRENT_TIMESTAMP in a session that isn't in UTC.
+        nzdt := time.Date(2024, 1, 2, 16, 0, 0, 0, time.FixedZone("NZDT", 13*60*60))
+
+        mock.ExpectQuery(smqc.NoiseCountQuery(queryParams("noiseCount")).SQL).
+                WithArgs(16, 10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows([]string{"current_timestamp", "station", "blacklist", "vertical", "noise_count"}).
+                        AddRow(nzdt, "WEL", "false", "pga-true", 40).
+                        AddRow(nzdt, "TFSS", "true", "pgv-false", 12))
+
+        if _, err := noiseCount(db, newCheckTrace("noiseCount")); err != nil {
+                t.Fatal(err)
+        }
+        if err := mock.ExpectationsWereMet(); err != nil {
+                t.Error(err)
+        }
+
+        expected := `timestamp,station,blacklist,component,noise_count,schema_version
+2024-01-02T03:00:00Z,WEL,false,pga-true,40,1
+2024-01-02T03:00:00Z,TFSS,true,pgv-false,12,1
+`
+        if got := files[filepath.Join(dir, "noiseCount.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestNoiseCountScanError(t *testing.T) {
+        captureOutput(t)
+        mock, db := newMock(t)
+
+        mock.ExpectQuery(smqc.NoiseCountQuery(queryParams("noiseCount")).SQL).
+                WithArgs(16, 10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows([]string{"current_timestamp", "station", "blacklist", "vertical", "noise_count"}).
+                        AddRow("2024-01-02 03:00:00", "NEW", "false", "pga-true", "many"))
+
+        _, err := noiseCount(db, newCheckTrace("noiseCount"))
+        if err == nil || !strings.Contains(err.Error(), "scanning row 1") {
+                t.Errorf("expected scanning row 1 error, got %v", err)
+        }
+}
+
+func TestNoiseCountBlacklistOverride(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        blacklistOverrides.stations = map[string]blacklistOverride{"WEL": {Blacklist: true}, "TFSS": {Blacklist: false}}
+        t.Cleanup(func() { blacklistOverrides.stations = nil })
+
+        mock.ExpectQuery(smqc.NoiseCountQuery(queryParams("noiseCount")).SQL).
+                WithArgs(16, 10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows([]string{"current_timestamp", "station", "blacklist", "vertical", "noise_count"}).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", "pga-true", 40).
+                        AddRow("2024-01-02 03:00:00", "TFSS", "true", "pga-true", 30))
+
+        // WEL is over the threshold but blacklisted by its override.
+        result, err := noiseCount(db, newCheckTrace("noiseCount"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if result != (checkResult{rows: 2, concerns: 1}) {
+                t.Errorf("expected 2 rows and 1 concern, got %+v", result)
+        }
+
+        expected := `timestamp,station,blacklist,component,noise_count,schema_version
+2024-01-02T03:00:00Z,WEL,true,pga-true,40,1
+2024-01-02T03:00:00Z,TFSS,false,pga-true,30,1
+`
+        if got := files[filepath.Join(dir, "noiseCount.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestRatioDiff(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        mock.ExpectQuery(smqc.RatioDiffQuery(queryParams("ratioDiff")).SQL).
+                WithArgs(10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows([]string{"current_timestamp", "station", "blacklist", "ratio", "max_vertical", "max_horizontal"}).
+                        AddRow("2024-01-02 03:00:00", "WEL2", "true", 13.22179981, 0.99792, 0.07547).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", 1.0647, 0.9255, 0.9854))
+
+        result, err := ratioDiff(db, newCheckTrace("ratioDiff"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := mock.ExpectationsWereMet(); err != nil {
+                t.Error(err)
+        }
+
+        // WEL2 is over -ratio-alert-threshold but blacklisted.
+        if result != (checkResult{rows: 2, concerns: 0}) {
+                t.Errorf("expected 2 rows and no concerns, got %+v", result)
+        }
+
+        expected := `timestamp,station,blacklist,ratio,max_vertical,max_horizontal,schema_version
+2024-01-02T03:00:00Z,WEL2,true,13.2218,0.9979,0.0755,1
+2024-01-02T03:00:00Z,WEL,false,1.0647,0.9255,0.9854,1
+`
+        if got := files[filepath.Join(dir, "ratioDiff.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestRatioDiffRowError(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        mock.ExpectQuery(smqc.RatioDiffQuery(queryParams("ratioDiff")).SQL).
+                WithArgs(10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows([]string{"current_timestamp", "station", "blacklist", "ratio", "max_vertical", "max_horizontal"}).
+                        AddRow("2024-01-02 03:00:00", "WEL2", "true", 13.2218, 0.9979, 0.0755).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", 1.0647, 0.9255, 0.9854).
+                        RowError(1, errors.New("connection reset")))
+
+        _, err := ratioDiff(db, newCheckTrace("ratioDiff"))
+        if err == nil || !strings.Contains(err.Error(), "connection reset") {
+                t.Errorf("expected connection reset error, got %v", err)
+        }
+
+        expected := `timestamp,station,blacklist,ratio,max_vertical,max_horizontal,schema_version
+2024-01-02T03:00:00Z,WEL2,true,13.2218,0.9979,0.0755,1
+`
+        if got := files[filepath.Join(dir, "ratioDiff.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestNoiseCountQuotesFields(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        mock.ExpectQuery(smqc.NoiseCountQuery(queryParams("noiseCount")).SQL).
+                WithArgs(16, 10, "", "", 0, unbounded, unbounded).
+                WillReturnRows(sqlmock.NewRows([]string{"current_timestamp", "station", "blacklist", "vertical", "noise_count"}).
+                        AddRow("2024-01-02 03:00:00", `WEL,"2"`, "false", "pga-true", 40))
+
+        if _, err := noiseCount(db, newCheckTrace("noiseCount")); err != nil {
+                t.Fatal(err)
+        }
+
+        expected := `timestamp,station,blacklist,component,noise_count,schema_version
+2024-01-02T03:00:00Z,"WEL,""2""",false,pga-true,40,1
+`
+        if got := files[filepath.Join(dir, "noiseCount.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
+
+func TestNoiseCountWindow(t *testing.T) {
+        files := captureOutput(t)
+        mock, db := newMock(t)
+
+        queryFrom = time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC)
+        queryTo = queryFrom.Add(time.Hour)
+        t.Cleanup(func() { queryFrom, queryTo = time.Time{}, time.Time{} })
+
+        // The rows are for the window, not when the query ran.
+        mock.ExpectQuery(smqc.NoiseCountQuery(queryParams("noiseCount")).SQL).
+                WithArgs(16, 10, "", "", 1, "2024-01-02 02:00:00+00", "2024-01-02 03:00:00+00").
+                WillReturnRows(sqlmock.NewRows([]string{"current_timestamp", "station", "blacklist", "vertical", "noise_count"}).
+                        AddRow("2024-10-14 05:00:00", "WEL", "false", "pga-true", 40))
+
+        if _, err := noiseCount(db, newCheckTrace("noiseCount")); err != nil {
+                t.Fatal(err)
+        }
+        if err := mock.ExpectationsWereMet(); err != nil {
+                t.Error(err)
+        }
+
+        expected := `timestamp,station,blacklist,component,noise_count,schema_version
+2024-01-02T03:00:00Z,WEL,false,pga-true,40,1
+`
+        if got := files[filepath.Join(dir, "noiseCount.csv")].String(); got != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, got)
+        }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "os"
+        "path/filepath"
+        "strings"
+        "time"
+)
+
+/*
+A run summary for automation that has to tell a run that found nothing from one that never
+got going. With -summary each run ends by writing
+
+        {"run_id": "5f3a9c1e", "start": "2024-01-02T15:00:03Z", "window": "2024-01-02T15:00:00Z",
+                "duration_seconds": 4.2, "status": "findings", "exit_code": 2,
+                "checks": [{"check": "noiseCount", "status": "ok", "rows": 7, "concerns": 2,
+                        "duration_seconds": 1.3}, ...]}
+
+to the file, replacing the last run's, or as a line to stdout for -summary -. status is
+clean, findings when a check found rows of concern or failed when a check did, and a check's
+own status is ok, failed with its error, or not run when -fail-fast skipped it. exit_code is
+what the run exits with: 0 clean, 1 failed and 2 findings with -fail-on-findings, otherwise
+0. The file is removed as a run starts so a run that dies before the end leaves none, which
+automation should treat as failed. With -sources each source's run has its own, its name
+before the file's extension, and a source that couldn't be reached has none.
+*/
+
+type runSummary struct {
+        RunID string `json:"run_id"`
+        Source string `json:"source,omitempty"`
+        Start string `json:"start"`
+        Window string `json:"window"`
+        Duration float64 `json:"duration_seconds"`
+        Status string `json:"status"`
+        ExitCode int `json:"exit_code"`
+        Checks []checkSummary `json:"checks"`
+}
+
+type checkSummary struct {
+        Check string `json:"check"`
+        Status string `json:"status"`
+        Rows int `json:"rows"`
+        Concerns int `json:"concerns"`
+        Duration float64 `json:"duration_seconds"`
+        Error string `json:"error,omitempty"`
+}
+
+// summaryFile is the -summary file for source, with -sources each has its own with the
+// source's name before the extension, e.g. summary.legacy.json.
+func summaryFile(source string) string {
+        if source == "" {
+                return summaryPath
+        }
+        ext := filepath.Ext(summaryPath)
+        return strings.TrimSuffix(summaryPath, ext) + "." + source + ext
+}
+
+// clearSummary removes the last run's -summary files.
+func clearSummary() {
+        if summaryPath == "" || summaryPath == "-" {
+                return
+        }
+
+        paths := []string{summaryFile("")}
+        if len(sources) > 0 {
+                paths = nil
+                for _, s := range sources {
+                        paths = append(paths, summaryFile(s.Name))
+                }
+        }
+        for _, path := range paths {
+                if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+                        trace.Printf("WARNING: removing the last run's -summary: %s", err)
+                }
+        }
+}
+
+// exitCode is what a run with failed checks and concerns exits with.
+func exitCode(failed, concerns int) int {
+        switch {
+        case failed > 0:
+                return 1
+        case failOnFindings && concerns > 0:
+                return 2
+        }
+        return 0
+}
+
+// writeSummary writes the run's -summary, a failure is logged.
+func writeSummary(s runSummary) {
+        failed, concerns := 0, 0
+        for _, c := range s.Checks {
+                if c.Status == "failed" {
+                        failed++
+                }
+                concerns += c.Concerns
+        }
+
+        s.Status = "clean"
+        switch {
+        case failed > 0:
+                s.Status = "failed"
+        case concerns > 0:
+                s.Status = "findings"
+        }
+        s.ExitCode = exitCode(failed, concerns)
+
+        // Errors often have URLs in them, which are easier to read without & escaped.
+        var buf bytes.Buffer
+        enc := json.NewEncoder(&buf)
+        enc.SetEscapeHTML(false)
+        if err := enc.Encode(s); err != nil {
+                trace.Printf("ERROR: writing -summary: %s", err)
+                return
+        }
+        b := buf.Bytes()
+
+        if summaryPath == "-" {
+                os.Stdout.Write(b)
+                return
+        }
+
+        // Automation polling for the file never sees half of it.
+        path := summaryFile(s.Source)
+        tmp := path + ".tmp"
+        if err := os.WriteFile(tmp, b, 0666); err != nil {
+                trace.Printf("ERROR: writing -summary: %s", err)
+                return
+        }
+        if err := os.Rename(tmp, path); err != nil {
+                trace.Printf("ERROR: writing -summary: %s", err)
+        }
+}
+
+func seconds(d time.Duration) float64 {
+        return float64(d.Round(time.Millisecond)) / float64(time.Second)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+        "encoding/json"
+        "flag"
+        "fmt"
+        "io"
+        "net/url"
+        "os"
+        "strings"
+        "time"
+)
+
+/*
+Checking the notification settings without waiting for a station to go bad.
+
+        smqc [flags] test-notify
+
+sends a message marked as a test through each channel the flags set up, -notify-slack,
+-notify-smtp, -alert-webhook, -pagerduty-routing-key and -opsgenie-api-key, with the
+senders the runs use, and prints whether each one got through. The PagerDuty incident and
+Opsgenie alert have their own key, smqc/test-notify, so they never fold into a station's,
+and are resolved straight after. It fails if any channel does, or if there are none.
+*/
+
+const testNotifyKey = "smqc/test-notify"
+
+type notifyChannel struct {
+        name string
+        send func(text string) error
+}
+
+// notifyChannels are the configured channels.
+func notifyChannels() []notifyChannel {
+        var channels []notifyChannel
+
+        if notifySlack != "" {
+                channels = append(channels, notifyChannel{"slack", postSlack})
+        }
+        if notifySMTP != "" {
+                channels = append(channels, notifyChannel{"email", func(text string) error {
+                        return sendMail(text, text + "\nNo station has been flagged, this can be ignored.")
+                }})
+        }
+        if alertWebhook != "" {
+                channels = append(channels, notifyChannel{"alert-webhook", func(text string) error {
+                        b, err := json.Marshal(struct {
+                                Text string `json:"text"`
+                        }{text})
+                        if err != nil {
+                                return err
+                        }
+                        return postAlert(b)
+                }})
+        }
+        if pagerdutyKey != "" {
+                channels = append(channels, notifyChannel{"pagerduty", testPagerDuty})
+        }
+        if opsgenieKey != "" {
+                channels = append(channels, notifyChannel{"opsgenie", testOpsgenie})
+        }
+
+        return channels
+}
+
+func testNotify(args []string) error {
+        fs := flag.NewFlagSet("test-notify", flag.ContinueOnError)
+        if err := fs.Parse(args); err != nil {
+                return err
+        }
+
+        return sendTestNotifications(os.Stdout)
+}
+
+// sendTestNotifications sends the test through every channel, writing how each went to w.
+func sendTestNotifications(w io.Writer) error {
+        channels := notifyChannels()
+        if len(channels) == 0 {
+                return fmt.Errorf("no notification channels are configured, set -notify-slack, -notify-smtp, -alert-webhook, -pagerduty-routing-key or -opsgenie-api-key")
+        }
+
+        host, _ := os.Hostname()
+        text := fmt.Sprintf("TEST: Strong Motion noise test notification from smqc on %s at %s", host, time.Now().UTC().Format(time.RFC3339))
+
+        var failed []string
+        for _, c := range channels {
+                if err := c.send(text); err != nil {
+                        fmt.Fprintf(w, "%s: failed: %s\n", c.name, err)
+                        trace.Printf("WARNING: test-notify: %s: %s", c.name, err)
+                        failed = append(failed, c.name)
+                        continue
+                }
+                fmt.Fprintf(w, "%s: sent\n", c.name)
+                trace.Printf("test-notify: sent through %s", c.name)
+        }
+
+        if len(failed) > 0 {
+                return fmt.Errorf("%d of %d channels failed: %s", len(failed), len(channels), strings.Join(failed, ", "))
+        }
+        return nil
+}
+
+func testPagerDuty(text string) error {
+        trigger := map[string]any{
+                "routing_key": pagerdutyKey,
+                "event_action": "trigger",
+                "dedup_key": testNotifyKey,
+                "payload": map[string]any{
+                        "summary": text,
+                        "source": "smqc",
+                        "severity": "info",
+                        "group": "smqc",
+                },
+        }
+        if err := postEscalation(pagerdutyURL, "", trigger); err != nil {
+                return err
+        }
+
+        resolve := map[string]any{
+                "routing_key": pagerdutyKey,
+                "event_action": "resolve",
+                "dedup_key": testNotifyKey,
+        }
+        if err := postEscalation(pagerdutyURL, "", resolve); err != nil {
+                return fmt.Errorf("sent but not resolved: %w", err)
+        }
+        return nil
+}
+
+func testOpsgenie(text string) error {
+        alerts := strings.TrimSuffix(opsgenieURL, "/") + "/v2/alerts"
+
+        alert := map[string]any{
+                "message": text,
+                "alias": testNotifyKey,
+                "source": "smqc",
+                "tags": []string{"smqc", "test"},
+                "priority": "P5",
+        }
+        if err := postEscalation(alerts, opsgenieKey, alert); err != nil {
+                return err
+        }
+
+        note := map[string]any{"source": "smqc", "note": "test notification"}
+        if err := postEscalation(alerts + "/" + url.PathEscape(testNotifyKey) + "/close?identifierType=alias", opsgenieKey, note); err != nil {
+                return fmt.Errorf("sent but not closed: %w", err)
+        }
+        return nil
+}
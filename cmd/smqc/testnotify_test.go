@@ -0,0 +1,68 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "testing"
+)
+
+func TestSendTestNotifications(t *testing.T) {
+        var slackText string
+        slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                var body struct {
+                        Text string `json:"text"`
+                }
+                json.NewDecoder(r.Body).Decode(&body)
+                slackText = body.Text
+        }))
+        defer slack.Close()
+
+        var actions []string
+        pagerduty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                var event struct {
+                        Action string `json:"event_action"`
+                        Key string `json:"dedup_key"`
+                }
+                json.NewDecoder(r.Body).Decode(&event)
+                actions = append(actions, event.Action + " " + event.Key)
+                w.WriteHeader(http.StatusAccepted)
+        }))
+        defer pagerduty.Close()
+
+        opsgenie := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.WriteHeader(http.StatusUnauthorized)
+        }))
+        defer opsgenie.Close()
+
+        origSlack, origKey, origURL, origGenie, origGenieURL := notifySlack, pagerdutyKey, pagerdutyURL, opsgenieKey, opsgenieURL
+        notifySlack, pagerdutyKey, pagerdutyURL, opsgenieKey, opsgenieURL = slack.URL, "key", pagerduty.URL, "bad", opsgenie.URL
+        t.Cleanup(func() {
+                notifySlack, pagerdutyKey, pagerdutyURL, opsgenieKey, opsgenieURL = origSlack, origKey, origURL, origGenie, origGenieURL
+        })
+
+        // Opsgenie rejecting the key fails the command but the other channels are still tried.
+        var out bytes.Buffer
+        err := sendTestNotifications(&out)
+        if err == nil || !strings.Contains(err.Error(), "1 of 3 channels failed: opsgenie") {
+                t.Errorf("expected opsgenie to fail, got %v", err)
+        }
+
+        expected := "slack: sent\npagerduty: sent\nopsgenie: failed: service returned 401 Unauthorized\n"
+        if out.String() != expected {
+                t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+        }
+        if !strings.HasPrefix(slackText, "TEST: ") {
+                t.Errorf("expected the slack message marked as a test, got %q", slackText)
+        }
+        if got := strings.Join(actions, ", "); got != "trigger smqc/test-notify, resolve smqc/test-notify" {
+                t.Errorf("expected the test incident triggered and resolved, got %s", got)
+        }
+
+        notifySlack, pagerdutyKey, opsgenieKey = "", "", ""
+        if err := sendTestNotifications(&out); err == nil || !strings.Contains(err.Error(), "no notification channels") {
+                t.Errorf("expected no channels, got %v", err)
+        }
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+        "context"
+        "time"
+)
+
+/*
+With -trace-events every check logs one structured event breaking down where its time
+went, so a slow query can be told apart from slow writes or waiting on the pool:
+
+        msg="check event" check=noiseCount rows=10 acquire=1.2ms execute=230ms first_row=230ms last_row=231ms write=0.4ms total=232ms
+
+acquire is waiting for a database connection, execute is until the query returned,
+first_row and last_row are from the start of the query until the first and last row
+were read, and write is the total time spent writing output. Events go to the log, or
+to -trace-file when it's set, in -log-format.
+*/
+
+// checkTrace collects the sub timings for one run of a check.
+type checkTrace struct {
+        check string
+        // ctx is the check's, cancelled with the run or by -fail-fast.
+        ctx context.Context
+        start time.Time
+        acquire time.Duration
+        queryStart time.Time
+        execute time.Duration
+        firstRow time.Duration
+        lastRow time.Duration
+        write time.Duration
+        rows int
+}
+
+func newCheckTrace(check string) *checkTrace {
+        return &checkTrace{check: check, ctx: runCtx, start: time.Now()}
+}
+
+func (t *checkTrace) querying() {
+        t.queryStart = time.Now()
+}
+
+func (t *checkTrace) executed() {
+        t.execute = time.Since(t.queryStart)
+}
+
+// row is called as each row is read.
+func (t *checkTrace) row() {
+        t.rows++
+        t.lastRow = time.Since(t.queryStart)
+        if t.rows == 1 {
+                t.firstRow = t.lastRow
+        }
+}
+
+// writing times a write, call the returned func once it's done.
+func (t *checkTrace) writing() func() {
+        start := time.Now()
+        return func() {
+                t.write += time.Since(start)
+        }
+}
+
+// attrs are the event's fields.
+func (t *checkTrace) attrs() []interface{} {
+        return []interface{}{
+                "check", t.check,
+                "rows", t.rows,
+                "acquire", t.acquire,
+                "execute", t.execute,
+                "first_row", t.firstRow,
+                "last_row", t.lastRow,
+                "write", t.write,
+                "total", time.Since(t.start),
+        }
+}
+
+func (t *checkTrace) emit() {
+        if !traceEvents {
+                return
+        }
+        if eventLog != nil {
+                eventLog.Info("check event", t.attrs()...)
+                return
+        }
+        trace.Info("check event", t.attrs()...)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+        "bytes"
+        "crypto/hmac"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "io"
+        "net/http"
+        "net/url"
+        "strings"
+        "time"
+)
+
+/*
+A webhook for whatever else wants the results, such as ticketing automation, without smqc
+having to know about it. With -results-webhook each check's rows for the run are POSTed
+to every one of the comma separated URLs as
+
+        {"check": "noiseCount", "run_time": "2024-01-02T15:00:00Z", "run_id": "5f3a9c1e",
+                "rows": [{"timestamp": ..., "station": ..., ...}]}
+
+the rows as in -format jsonl. A check with no rows this run is sent too, with an empty
+rows, so a receiver can tell it ran. With -results-webhook-secret the body is signed with
+HMAC-SHA256 and the signature sent as
+
+        X-Smqc-Signature-256: sha256=<hex>
+
+and every request has an X-Smqc-Delivery id, the same on each attempt, for the receiver to
+drop repeats. A network error, 429 or 5xx is tried again up to -results-webhook-attempts
+times with a backoff from a second. A webhook that still fails is logged and doesn't fail
+the check or stop the others.
+*/
+
+type resultsPayload struct {
+        Check string `json:"check"`
+        RunTime string `json:"run_time"`
+        RunID string `json:"run_id"`
+        Rows []json.RawMessage `json:"rows"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// resultsWebhookURLs reads -results-webhook.
+func resultsWebhookURLs() []string {
+        var urls []string
+        for _, u := range strings.Split(resultsWebhook, ",") {
+                if u = strings.TrimSpace(u); u != "" {
+                        urls = append(urls, u)
+                }
+        }
+        return urls
+}
+
+// sendResultsWebhooks is best effort, failures are logged.
+func sendResultsWebhooks(o *checkOutput) {
+        body, err := resultsBody(o)
+        if err != nil {
+                trace.Printf("WARNING: %s: building -results-webhook payload: %s", o.name, err)
+                return
+        }
+
+        id, _ := runID.Load().(string)
+        delivery := fmt.Sprintf("%s-%s-%d", o.name, id, time.Now().UnixNano())
+
+        for _, u := range resultsWebhookURLs() {
+                if err := postResults(u, delivery, body); err != nil {
+                        trace.Printf("WARNING: %s: sending results to %s: %s", o.name, webhookHost(u), err)
+                }
+        }
+}
+
+func resultsBody(o *checkOutput) ([]byte, error) {
+        id, _ := runID.Load().(string)
+
+        p := resultsPayload{
+                Check: o.name,
+                RunTime: runStart.Format(time.RFC3339),
+                RunID: id,
+                Rows: []json.RawMessage{},
+        }
+        for _, r := range o.rows {
+                line, err := o.marshalJSON(r)
+                if err != nil {
+                        return nil, err
+                }
+                p.Rows = append(p.Rows, bytes.TrimSpace(line))
+        }
+
+        return json.Marshal(p)
+}
+
+// signResults is the X-Smqc-Signature-256 of body.
+func signResults(body []byte, secret string) string {
+        mac := hmac.New(sha256.New, []byte(secret))
+        mac.Write(body)
+        return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postResults(u, delivery string, body []byte) error {
+        backoff := time.Second
+        for attempt := 1; ; attempt++ {
+                retry, err := postResultsOnce(u, delivery, body)
+                if err == nil || !retry || attempt >= resultsWebhookAttempts {
+                        return err
+                }
+
+                wait := withJitter(backoff)
+                trace.Printf("WARNING: results webhook %s attempt %d of %d: %s, retrying in %s", webhookHost(u), attempt, resultsWebhookAttempts, err, wait)
+                time.Sleep(wait)
+                backoff *= 2
+        }
+}
+
+// postResultsOnce says whether a failure is worth trying again.
+func postResultsOnce(u, delivery string, body []byte) (bool, error) {
+        req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+        if err != nil {
+                return false, err
+        }
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("User-Agent", "smqc")
+        req.Header.Set("X-Smqc-Delivery", delivery)
+        if resultsWebhookSecret != "" {
+                req.Header.Set("X-Smqc-Signature-256", signResults(body, resultsWebhookSecret))
+        }
+
+        res, err := webhookClient.Do(req)
+        if err != nil {
+                // Without the URL, which the log is keeping out.
+                if ue, ok := err.(*url.Error); ok {
+                        err = ue.Err
+                }
+                return true, err
+        }
+        defer res.Body.Close()
+        io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+        if res.StatusCode >= 200 && res.StatusCode <= 299 {
+                return false, nil
+        }
+        retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+        return retry, fmt.Errorf("webhook returned %s", res.Status)
+}
+
+// webhookHost is enough of a URL for the log, its path or query may have a token in it.
+func webhookHost(u string) string {
+        p, err := url.Parse(u)
+        if err != nil {
+                return "-results-webhook"
+        }
+        return p.Host
+}
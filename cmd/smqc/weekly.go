@@ -0,0 +1,333 @@
+package main
+
+import (
+        "encoding/csv"
+        "flag"
+        "fmt"
+        "math"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+        _ "time/tzdata"
+)
+
+/*
+The hourly files are kept in case poor performance follows a regular weekly pattern, e.g.
+traffic or machinery near a site on weekdays.
+
+        smqc weekly-report [-window 2160h] [-check noiseCount] [-tz Pacific/Auckland]
+
+reads the check history for the window, from the appended files or -results-db when it's
+set, and counts the hours each station was flagged by day of the week and hour of the day
+in -tz. Each count is compared with a chi-squared test to the spread the check's hours
+would give if the flags fell evenly on them, taking the hours the check flagged any station
+in as the hours it ran, as the history has nothing for a run with no rows. weeklyReport.csv is
+replaced with
+
+        station,check,flagged,peak_day,day_share,day_p,peak_hour,hour_share,hour_p,periodic,day_counts,hour_counts
+
+for stations flagged in at least -min-flagged hours, periodic when either p is below
+-significance. day_counts are Monday to Sunday and hour_counts 0 to 23, space separated.
+*/
+
+type weeklyKey struct {
+        station string
+        check string
+}
+
+func weeklyReport(args []string) error {
+        fs := flag.NewFlagSet("weekly-report", flag.ContinueOnError)
+        window := fs.Duration("window", 90*24*time.Hour, "how far back to look at the check history")
+        only := fs.String("check", "", "only report this check, e.g. noiseCount, instead of each of them")
+        tz := fs.String("tz", "Pacific/Auckland", "time zone to take days and hours in")
+        minFlagged := fs.Int("min-flagged", 10, "leave out stations flagged in fewer hours than this, too few for the test to mean anything")
+        significance := fs.Float64("significance", 0.01, "p value below which a station's flags are reported as periodic")
+        if err := fs.Parse(args); err != nil {
+                return err
+        }
+
+        loc, err := time.LoadLocation(*tz)
+        if err != nil {
+                return fmt.Errorf("invalid -tz: %w", err)
+        }
+        if *significance <= 0 || *significance >= 1 {
+                return fmt.Errorf("-significance must be between 0 and 1")
+        }
+
+        checks := historyChecks
+        if *only != "" {
+                checks = []string{*only}
+        }
+
+        from := time.Now().UTC().Add(-*window)
+
+        // The hours flagged for each station and check, and the hours each check flagged anything in.
+        flagged := map[weeklyKey]map[time.Time]bool{}
+        ran := map[string]map[time.Time]bool{}
+
+        add := func(check string, h historyRow) {
+                hour := h.at.UTC().Truncate(time.Hour)
+                if ran[check] == nil {
+                        ran[check] = map[time.Time]bool{}
+                }
+                ran[check][hour] = true
+
+                k := weeklyKey{h.station, check}
+                if flagged[k] == nil {
+                        flagged[k] = map[time.Time]bool{}
+                }
+                flagged[k][hour] = true
+        }
+
+        if resultsDSN != "" {
+                if err := readResultsHistory(checks, from, add); err != nil {
+                        return fmt.Errorf("reading -results-db: %w", err)
+                }
+        } else {
+                for _, check := range checks {
+                        history, err := readHistory(check, from)
+                        if err != nil {
+                                return err
+                        }
+                        for _, h := range history {
+                                add(check, h)
+                        }
+                }
+        }
+
+        var keys []weeklyKey
+        for k, hours := range flagged {
+                if len(hours) >= *minFlagged {
+                        keys = append(keys, k)
+                }
+        }
+        sort.Slice(keys, func(i, j int) bool {
+                if keys[i].check != keys[j].check {
+                        return keys[i].check < keys[j].check
+                }
+                return keys[i].station < keys[j].station
+        })
+
+        path := filepath.Join(dir, "weeklyReport.csv")
+        f, err := os.Create(path)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+
+        w := csv.NewWriter(f)
+        w.Write([]string{"station", "check", "flagged", "peak_day", "day_share", "day_p", "peak_hour", "hour_share", "hour_p", "periodic", "day_counts", "hour_counts"})
+
+        var periodic int
+        for _, k := range keys {
+                days, hours := make([]float64, 7), make([]float64, 24)
+                for at := range flagged[k] {
+                        local := at.In(loc)
+                        days[(local.Weekday()+6)%7]++
+                        hours[local.Hour()]++
+                }
+
+                ranDays, ranHours := make([]float64, 7), make([]float64, 24)
+                for at := range ran[k.check] {
+                        local := at.In(loc)
+                        ranDays[(local.Weekday()+6)%7]++
+                        ranHours[local.Hour()]++
+                }
+
+                dayP, hourP := chiSquaredP(days, ranDays), chiSquaredP(hours, ranHours)
+                peakDay, peakHour := argmax(days), argmax(hours)
+                n := float64(len(flagged[k]))
+
+                isPeriodic := dayP < *significance || hourP < *significance
+                if isPeriodic {
+                        periodic++
+                }
+
+                w.Write([]string{
+                        k.station,
+                        k.check,
+                        strconv.Itoa(len(flagged[k])),
+                        time.Weekday((peakDay + 1) % 7).String(),
+                        formatFloat(days[peakDay] / n),
+                        strconv.FormatFloat(dayP, 'g', 3, 64),
+                        strconv.Itoa(peakHour),
+                        formatFloat(hours[peakHour] / n),
+                        strconv.FormatFloat(hourP, 'g', 3, 64),
+                        strconv.FormatBool(isPeriodic),
+                        joinCounts(days),
+                        joinCounts(hours),
+                })
+        }
+
+        w.Flush()
+        if err := w.Error(); err != nil {
+                return err
+        }
+
+        trace.Printf("Wrote weekly report for %d station checks, %d periodic, to %s", len(keys), periodic, path)
+        return nil
+}
+
+// readResultsHistory reads the checks' rows from -results-db since from, as readHistory
+// reads them from the csv history, for when that's where the complete history is. The
+// blacklist flag is the one in the row's key.
+func readResultsHistory(checks []string, from time.Time, add func(check string, h historyRow)) error {
+        db, err := openResultsDB(resultsDSN)
+        if err != nil {
+                return err
+        }
+        defer db.Close()
+
+        // The window is bound as RFC3339 like it's written, SQLite compares it as text.
+        args := []interface{}{from.UTC().Format(time.RFC3339)}
+        var params []string
+        for _, c := range checks {
+                args = append(args, c)
+                params = append(params, "$" + strconv.Itoa(len(args)))
+        }
+
+        ctx, cancel := queryContext()
+        defer cancel()
+
+        rows, err := db.QueryContext(ctx, `SELECT run_window, check_name, station, row_key FROM smqc.results
+WHERE run_window >= $1 AND check_name IN (` + strings.Join(params, ", ") + `)`, args...)
+        if err != nil {
+                return queryError(ctx, err)
+        }
+        defer rows.Close()
+
+        for rows.Next() {
+                var (
+                        window interface{}
+                        check string
+                        station string
+                        key string
+                )
+                if err := rows.Scan(&window, &check, &station, &key); err != nil {
+                        return err
+                }
+
+                // Postgres gives a time.Time and SQLite the RFC3339 text it was saved as.
+                var at time.Time
+                switch v := window.(type) {
+                case time.Time:
+                        at = v
+                case string:
+                        at, err = time.Parse(time.RFC3339, v)
+                case []byte:
+                        at, err = time.Parse(time.RFC3339, string(v))
+                default:
+                        err = fmt.Errorf("unsupported run_window type %T", window)
+                }
+                if err != nil {
+                        return err
+                }
+
+                h := historyRow{at: at, station: station}
+                for _, field := range strings.Split(key, ",") {
+                        if name, value, _ := strings.Cut(field, "="); name == "blacklist" {
+                                h.blacklist = value
+                        }
+                }
+                add(check, h)
+        }
+
+        return queryError(ctx, rows.Err())
+}
+
+// chiSquaredP is the p value of the observed counts against the spread the exposure, the
+// hours the check ran in, would give them. Buckets the check never ran in are left out.
+func chiSquaredP(observed, exposure []float64) float64 {
+        var total, exposed float64
+        for i := range observed {
+                total += observed[i]
+                exposed += exposure[i]
+        }
+        if total == 0 || exposed == 0 {
+                return 1
+        }
+
+        var chi2 float64
+        buckets := 0
+        for i := range observed {
+                if exposure[i] == 0 {
+                        continue
+                }
+                expected := total * exposure[i] / exposed
+                chi2 += (observed[i] - expected) * (observed[i] - expected) / expected
+                buckets++
+        }
+        if buckets < 2 {
+                return 1
+        }
+
+        return gammaQ(float64(buckets-1)/2, chi2/2)
+}
+
+// gammaQ is the regularized upper incomplete gamma function, the chi-squared survival
+// function with a the degrees of freedom over two.
+func gammaQ(a, x float64) float64 {
+        if x <= 0 {
+                return 1
+        }
+        lg, _ := math.Lgamma(a)
+
+        // The series converges quickly below a+1, the continued fraction above it.
+        if x < a+1 {
+                sum, term := 1/a, 1/a
+                for n := 1; n < 500; n++ {
+                        term *= x / (a + float64(n))
+                        sum += term
+                        if math.Abs(term) < math.Abs(sum)*1e-15 {
+                                break
+                        }
+                }
+                return 1 - sum*math.Exp(-x+a*math.Log(x)-lg)
+        }
+
+        const tiny = 1e-300
+        b := x + 1 - a
+        c := 1 / tiny
+        d := 1 / b
+        h := d
+        for i := 1; i < 500; i++ {
+                an := -float64(i) * (float64(i) - a)
+                b += 2
+                d = an*d + b
+                if math.Abs(d) < tiny {
+                        d = tiny
+                }
+                c = b + an/c
+                if math.Abs(c) < tiny {
+                        c = tiny
+                }
+                d = 1 / d
+                delta := d * c
+                h *= delta
+                if math.Abs(delta-1) < 1e-15 {
+                        break
+                }
+        }
+        return math.Exp(-x+a*math.Log(x)-lg) * h
+}
+
+func argmax(v []float64) int {
+        best := 0
+        for i := range v {
+                if v[i] > v[best] {
+                        best = i
+                }
+        }
+        return best
+}
+
+func joinCounts(v []float64) string {
+        s := make([]string, len(v))
+        for i, n := range v {
+                s[i] = strconv.Itoa(int(n))
+        }
+        return strings.Join(s, " ")
+}
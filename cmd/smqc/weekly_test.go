@@ -0,0 +1,82 @@
+package main
+
+import (
+        "math"
+        "path/filepath"
+        "testing"
+        "time"
+)
+
+func TestReadResultsHistory(t *testing.T) {
+        dsn := resultsDSN
+        resultsDSN = "sqlite:" + filepath.Join(t.TempDir(), "results.db")
+        t.Cleanup(func() { resultsDSN = dsn })
+
+        db, err := openResultsDB(resultsDSN)
+        if err != nil {
+                t.Fatal(err)
+        }
+        for _, r := range []struct{ window, check, station string }{
+                {"2024-01-01T00:00:00Z", "noiseCount", "OLD"},
+                {"2024-01-02T03:00:00Z", "noiseCount", "WEL"},
+                {"2024-01-02T03:00:00Z", "spike", "TFSS"},
+        } {
+                if _, err := db.Exec(upsertResultSQL, r.window, r.check, r.station, "", 1.0, "{}", r.window); err != nil {
+                        t.Fatal(err)
+                }
+        }
+        db.Close()
+
+        // Only the noiseCount row in the window.
+        var got []string
+        from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+        err = readResultsHistory([]string{"noiseCount"}, from, func(check string, h historyRow) {
+                got = append(got, check + " " + h.station + " " + h.at.Format(time.RFC3339))
+        })
+        if err != nil {
+                t.Fatal(err)
+        }
+        if len(got) != 1 || got[0] != "noiseCount WEL 2024-01-02T03:00:00Z" {
+                t.Errorf("expected WEL's noiseCount row, got %q", got)
+        }
+}
+
+func TestGammaQ(t *testing.T) {
+        for _, c := range []struct {
+                a, x float64
+                expected float64
+        }{
+                // The chi-squared 5% critical values for 1, 6 and 23 degrees of freedom.
+                {0.5, 3.841458820694124 / 2, 0.05},
+                {3, 12.591587243743977 / 2, 0.05},
+                {11.5, 35.17246162690806 / 2, 0.05},
+                // Q(1, x) is e^-x and Q(3, x) e^-x (1 + x + x²/2).
+                {1, 2, 0.1353352832366127},
+                {3, 0.5, 0.9856123220330293},
+                {2, 0, 1},
+        } {
+                if got := gammaQ(c.a, c.x); math.Abs(got - c.expected) > 1e-9 {
+                        t.Errorf("gammaQ(%g, %g): expected %g, got %g", c.a, c.x, c.expected, got)
+                }
+        }
+}
+
+func TestChiSquaredP(t *testing.T) {
+        for _, c := range []struct {
+                name string
+                observed, exposure []float64
+                expected float64
+        }{
+                {"even", []float64{2, 4, 6}, []float64{1, 2, 3}, 1},
+                // Expected 5 and 5, chi-squared 10 on one degree of freedom, erfc(√5).
+                {"one sided", []float64{10, 0}, []float64{1, 1}, 0.0015654022580025488},
+                // The bucket the check never ran in doesn't count.
+                {"unexposed", []float64{10, 0, 0}, []float64{1, 1, 0}, 0.0015654022580025488},
+                {"nothing flagged", []float64{0, 0}, []float64{1, 1}, 1},
+                {"one bucket", []float64{10, 0}, []float64{1, 0}, 1},
+        } {
+                if got := chiSquaredP(c.observed, c.exposure); math.Abs(got - c.expected) > 1e-9 {
+                        t.Errorf("%s: expected %g, got %g", c.name, c.expected, got)
+                }
+        }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+        "database/sql"
+        "fmt"
+        "time"
+)
+
+/*
+Time bounds for the checks' queries. Without them a check covers whatever is in the
+summary tables. With -window it covers that long up to each run and with -from and -to
+that range, -to defaulting to -from plus -window, or now. A bounded run is for the hour
+its range ends in, that's the window in the run registry and the timestamp of its rows
+rather than when the query ran.
+
+-backfill runs the checks for each hour from -from to -to in turn, as the hourly runs
+would have, to regenerate history after the check host has been down. With
+-duplicate-run skip the hours already in the run registry are left alone.
+
+The bounds are bound as text in the form Postgres writes a timestamptz in so a -dump-dir
+with a time column compares them as text. A dump without one has no rows in any window.
+*/
+
+var (
+        queryFrom time.Time
+        queryTo time.Time
+)
+
+// windowTimeLayout is how a UTC timestamptz is written by psql, and so in a dump.
+const windowTimeLayout = "2006-01-02 15:04:05-07"
+
+// windowArgs are the three parameters every check's query ends with: whether it's bounded
+// and the start and end of the window. Unbounded still binds valid timestamps, Postgres
+// parses them whether or not they're used.
+func windowArgs() []interface{} {
+        if queryTo.IsZero() {
+                unbounded := time.Time{}.Format(windowTimeLayout)
+                return []interface{}{0, unbounded, unbounded}
+        }
+        return []interface{}{1, queryFrom.UTC().Format(windowTimeLayout), queryTo.UTC().Format(windowTimeLayout)}
+}
+
+// withWindow appends the window parameters to a query's own.
+func withWindow(args ...interface{}) []interface{} {
+        return append(args, windowArgs()...)
+}
+
+// parseWindowTime reads -from or -to, RFC3339 or a UTC date, hour or minute.
+func parseWindowTime(name, s string) (time.Time, error) {
+        for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02T15", "2006-01-02"} {
+                if t, err := time.Parse(layout, s); err == nil {
+                        return t.UTC(), nil
+                }
+        }
+        return time.Time{}, fmt.Errorf("invalid %s %q, expected RFC3339 or e.g. 2024-01-02T15:00", name, s)
+}
+
+// parseWindow checks -from, -to, -window and -backfill, setting the fixed bounds.
+func parseWindow() error {
+        if windowSize < 0 {
+                return fmt.Errorf("-window must not be negative")
+        }
+        if windowTo != "" && windowFrom == "" {
+                return fmt.Errorf("-to needs -from")
+        }
+        if backfill && (windowFrom == "" || windowTo == "") {
+                return fmt.Errorf("-backfill needs -from and -to")
+        }
+        if backfill && interval > 0 {
+                return fmt.Errorf("-backfill can't be used with -interval or -daemon")
+        }
+        if windowFrom == "" {
+                return nil
+        }
+        if interval > 0 {
+                return fmt.Errorf("-from can't be used with -interval or -daemon, use -window")
+        }
+
+        from, err := parseWindowTime("-from", windowFrom)
+        if err != nil {
+                return err
+        }
+
+        var to time.Time
+        switch {
+        case windowTo != "":
+                if to, err = parseWindowTime("-to", windowTo); err != nil {
+                        return err
+                }
+        case windowSize > 0:
+                to = from.Add(windowSize)
+        default:
+                to = time.Now().UTC()
+        }
+
+        if !to.After(from) {
+                return fmt.Errorf("-to must be after -from")
+        }
+
+        queryFrom, queryTo = from, to
+        return nil
+}
+
+// setWindow bounds the queries for a run at at, with -window the hour up to it.
+func setWindow(at time.Time) {
+        if windowFrom == "" && windowSize > 0 {
+                queryFrom, queryTo = at.Add(-windowSize), at
+        }
+}
+
+// runAt is the time a run is for, the end of its bounds when -from sets them.
+func runAt() time.Time {
+        if windowFrom != "" {
+                return queryTo
+        }
+        return time.Now().UTC()
+}
+
+// runBackfill runs the checks for each hour from -from to -to, returning how many hours
+// had a failed check and the rows of concern across them.
+func runBackfill(db *sql.DB, checks []check) (int, int) {
+        first, last := queryFrom.Truncate(time.Hour), queryTo
+
+        trace.Printf("Backfilling %s to %s", first.Format(time.RFC3339), last.Format(time.RFC3339))
+
+        var failedHours, concerns int
+        for hour := first; hour.Before(last) && runCtx.Err() == nil; hour = hour.Add(time.Hour) {
+                queryFrom, queryTo = hour, hour.Add(time.Hour)
+
+                failed, c := runAll(db, checks, queryTo)
+                if failed > 0 {
+                        failedHours++
+                        trace.Printf("ERROR: %d of %d checks failed backfilling %s", failed, len(checks), hour.Format(time.RFC3339))
+                }
+                concerns += c
+        }
+
+        return failedHours, concerns
+}
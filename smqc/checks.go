@@ -0,0 +1,225 @@
+package smqc
+
+import (
+        "context"
+        "database/sql"
+        "fmt"
+        "time"
+)
+
+// Querier is what the checks run their queries with, a *sql.DB, *sql.Conn or *sql.Tx.
+type Querier interface {
+        QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// NoiseCountResult is a row of noiseCount.csv, a station's count of values for one
+// component, pga-true for vertical PGA, pga-false horizontal, pgv-true or pgv-false.
+type NoiseCountResult struct {
+        Timestamp time.Time
+        Station string
+        Blacklist bool
+        Component string
+        NoiseCount int
+
+        // Explained is whether Options.Explained explained the row by an earthquake.
+        Explained bool
+}
+
+// RatioResult is a row of ratioDiff.csv or pgvRatio.csv, a station's largest vertical and
+// horizontal value and the larger over the smaller.
+type RatioResult struct {
+        Timestamp time.Time
+        Station string
+        Blacklist bool
+        Ratio float64
+        MaxVertical float64
+        MaxHorizontal float64
+
+        // Explained is whether Options.Explained explained the row by an earthquake.
+        Explained bool
+}
+
+// ScanError is a check's row that failed to scan, numbered from 1.
+type ScanError struct {
+        Check string
+        Row int
+        Err error
+}
+
+func (e *ScanError) Error() string {
+        return fmt.Sprintf("%s: scanning row %d: %s", e.Check, e.Row, e.Err)
+}
+
+func (e *ScanError) Unwrap() error {
+        return e.Err
+}
+
+// RowsError is a check's rows ending early, after Row of them were read. The results read
+// before it are returned with it.
+type RowsError struct {
+        Check string
+        Row int
+        Err error
+}
+
+func (e *RowsError) Error() string {
+        return fmt.Sprintf("%s: reading row %d: %s", e.Check, e.Row+1, e.Err)
+}
+
+func (e *RowsError) Unwrap() error {
+        return e.Err
+}
+
+// NoiseCount finds the stations with the most values in the window, a noisy station
+// triggers far more often than its neighbours.
+func NoiseCount(ctx context.Context, db Querier, opts Options) ([]NoiseCountResult, error) {
+        return NoiseCountFrom(ctx, db, NoiseCountQuery(opts.params()), opts)
+}
+
+// NoiseCountFrom is NoiseCount with the query already built from QueryParams, for a caller
+// with its own, such as the command with its -station-rules.
+func NoiseCountFrom(ctx context.Context, db Querier, q Query, opts Options) ([]NoiseCountResult, error) {
+        var results []NoiseCountResult
+
+        err := readRows(ctx, db, "noiseCount", q, opts, func(rows *sql.Rows) error {
+                var (
+                        at = timestamp{window: opts.To}
+                        r NoiseCountResult
+                        blacklist string
+                        component sql.NullString
+                )
+                if err := rows.Scan(&at, &r.Station, &blacklist, &component, &r.NoiseCount); err != nil {
+                        return err
+                }
+                // A station without values in the window has no component.
+                if !component.Valid {
+                        return nil
+                }
+                r.Timestamp, r.Blacklist, r.Component = at.Time, opts.blacklisted(r.Station, blacklist), component.String
+                r.Explained = opts.explained("noiseCount", r)
+                results = append(results, r)
+                return nil
+        })
+
+        return results, err
+}
+
+// RatioDiff finds the stations whose vertical and horizontal PGA differ the most, one
+// component of a working sensor rarely sees several times the shaking of the other.
+func RatioDiff(ctx context.Context, db Querier, opts Options) ([]RatioResult, error) {
+        return RatiosFrom(ctx, db, "ratioDiff", RatioDiffQuery(opts.params()), opts)
+}
+
+// PGVRatio is RatioDiff for PGV.
+func PGVRatio(ctx context.Context, db Querier, opts Options) ([]RatioResult, error) {
+        return RatiosFrom(ctx, db, "pgvRatio", PGVRatioQuery(opts.params()), opts)
+}
+
+// RatiosFrom is RatioDiff or PGVRatio, as check says, with the query already built.
+func RatiosFrom(ctx context.Context, db Querier, check string, q Query, opts Options) ([]RatioResult, error) {
+        var results []RatioResult
+
+        err := readRows(ctx, db, check, q, opts, func(rows *sql.Rows) error {
+                var (
+                        at = timestamp{window: opts.To}
+                        r RatioResult
+                        blacklist string
+                        ratio, vertical, horizontal sql.NullFloat64
+                )
+                if err := rows.Scan(&at, &r.Station, &blacklist, &ratio, &vertical, &horizontal); err != nil {
+                        return err
+                }
+                // A station missing either component, or with a zero maximum, has no ratio.
+                if !ratio.Valid {
+                        return nil
+                }
+                r.Timestamp, r.Blacklist = at.Time, opts.blacklisted(r.Station, blacklist)
+                r.Ratio, r.MaxVertical, r.MaxHorizontal = ratio.Float64, vertical.Float64, horizontal.Float64
+                r.Explained = opts.explained(check, r)
+                results = append(results, r)
+                return nil
+        })
+
+        return results, err
+}
+
+// readRows runs q and calls scan with each row. A row that fails to scan fails the check
+// unless Options.SkipScanError skips it.
+func readRows(ctx context.Context, db Querier, check string, q Query, opts Options, scan func(*sql.Rows) error) error {
+        rows, err := db.QueryContext(ctx, q.SQL, q.Args...)
+        if err != nil {
+                return fmt.Errorf("%s: %w", check, err)
+        }
+        defer rows.Close()
+
+        row := 0
+        for rows.Next() {
+                row++
+                if err := scan(rows); err != nil {
+                        if opts.SkipScanError != nil && opts.SkipScanError(check, row, err) {
+                                continue
+                        }
+                        return &ScanError{Check: check, Row: row, Err: err}
+                }
+        }
+
+        err = rows.Err()
+        if err == nil {
+                // A SQLite query that's cancelled ends its rows without an error.
+                err = ctx.Err()
+        }
+        if err != nil {
+                return &RowsError{Check: check, Row: row, Err: err}
+        }
+        return nil
+}
+
+// isBlacklisted reads impact.source's blacklist as Postgres or a SQLite dump returns it.
+func isBlacklisted(s string) bool {
+        switch s {
+        case "true", "t", "1":
+                return true
+        }
+        return false
+}
+
+// Checks are the checks Run knows by the command's names for them.
+var Checks = []string{"noiseCount", "ratioDiff", "pgvRatio"}
+
+// Run runs the named checks, or all of Checks, and gives each one's results to w. It stops
+// at the first that fails.
+func Run(ctx context.Context, db Querier, opts Options, w Writer, checks ...string) error {
+        if len(checks) == 0 {
+                checks = Checks
+        }
+
+        for _, check := range checks {
+                var results []Result
+                switch check {
+                case "noiseCount":
+                        r, err := NoiseCount(ctx, db, opts)
+                        if err != nil {
+                                return err
+                        }
+                        results = NoiseCountResults(r)
+                case "ratioDiff", "pgvRatio":
+                        run := RatioDiff
+                        if check == "pgvRatio" {
+                                run = PGVRatio
+                        }
+                        r, err := run(ctx, db, opts)
+                        if err != nil {
+                                return err
+                        }
+                        results = RatioResults(r)
+                default:
+                        return fmt.Errorf("unknown check %q", check)
+                }
+
+                if err := w.Write(check, results); err != nil {
+                        return fmt.Errorf("%s: writing results: %w", check, err)
+                }
+        }
+
+        return nil
+}
@@ -0,0 +1,93 @@
+package smqc
+
+import (
+        "context"
+        "errors"
+        "testing"
+
+        "github.com/DATA-DOG/go-sqlmock"
+)
+
+var noiseCountColumns = []string{"current_timestamp", "station", "blacklist", "vertical", "noise_count"}
+
+func TestNoiseCountOptions(t *testing.T) {
+        db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer db.Close()
+
+        mock.ExpectQuery(NoiseCountQuery(Options{}.params()).SQL).
+                WillReturnRows(sqlmock.NewRows(noiseCountColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", "pga-true", 40).
+                        AddRow("2024-01-02 03:00:00", "TFSS", "true", "pgv-false", 12).
+                        AddRow("2024-01-02 03:00:00", "NEW", "false", "pga-true", "many").
+                        AddRow("2024-01-02 03:00:00", "SNZO", "false", nil, 0))
+
+        var skipped []int
+        opts := Options{
+                Blacklist: map[string]bool{"WEL": true, "TFSS": false},
+                Explained: func(check string, r Result) bool {
+                        return check == "noiseCount" && r.(NoiseCountResult).Station == "TFSS"
+                },
+                SkipScanError: func(check string, row int, err error) bool {
+                        skipped = append(skipped, row)
+                        return true
+                },
+        }
+
+        results, err := NoiseCount(context.Background(), db, opts)
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        // The overrides win over impact.source's flag, and SNZO has no values in the window.
+        if len(results) != 2 {
+                t.Fatalf("expected 2 results, got %+v", results)
+        }
+        if r := results[0]; r.Station != "WEL" || !r.Blacklist || r.Explained {
+                t.Errorf("expected WEL blacklisted and not explained, got %+v", r)
+        }
+        if r := results[1]; r.Station != "TFSS" || r.Blacklist || !r.Explained {
+                t.Errorf("expected TFSS not blacklisted and explained, got %+v", r)
+        }
+        if len(skipped) != 1 || skipped[0] != 3 {
+                t.Errorf("expected row 3 skipped, got %v", skipped)
+        }
+}
+
+func TestNoiseCountErrors(t *testing.T) {
+        db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer db.Close()
+
+        q := NoiseCountQuery(Options{}.params()).SQL
+
+        mock.ExpectQuery(q).
+                WillReturnRows(sqlmock.NewRows(noiseCountColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", "pga-true", "many"))
+
+        _, err = NoiseCount(context.Background(), db, Options{})
+        var scanErr *ScanError
+        if !errors.As(err, &scanErr) || scanErr.Row != 1 {
+                t.Errorf("expected a ScanError for row 1, got %v", err)
+        }
+
+        mock.ExpectQuery(q).
+                WillReturnRows(sqlmock.NewRows(noiseCountColumns).
+                        AddRow("2024-01-02 03:00:00", "WEL", "false", "pga-true", 40).
+                        AddRow("2024-01-02 03:00:00", "TFSS", "false", "pga-true", 30).
+                        RowError(1, errors.New("connection reset")))
+
+        // The rows before the error are returned with it.
+        results, err := NoiseCount(context.Background(), db, Options{})
+        var rowsErr *RowsError
+        if !errors.As(err, &rowsErr) || rowsErr.Row != 1 {
+                t.Errorf("expected a RowsError after row 1, got %v", err)
+        }
+        if len(results) != 1 || results[0].Station != "WEL" {
+                t.Errorf("expected WEL's result, got %+v", results)
+        }
+}
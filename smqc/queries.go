@@ -0,0 +1,241 @@
+package smqc
+
+import (
+        "fmt"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/*
+The checks' queries, shared with the command. Rather than a constant per variation each is
+built from QueryParams, the SQL only has the parts the parameters ask for and every value,
+the thresholds and limit as well as the stations and window, is a bind parameter numbered
+in the order the builder adds it. The defaults build the queries the checks have always
+run, with the parameters in the same order.
+*/
+
+// Components are the noise count components, a measure then whether it's vertical.
+var Components = []string{"pga-true", "pga-false", "pgv-true", "pgv-false"}
+
+// QueryParams are what a check's query is built from.
+type QueryParams struct {
+        // Include and Exclude are comma separated stations, all of them when Include is
+        // empty.
+        Include string
+        Exclude string
+
+        // From and To bound the window, the whole of the summary tables when To is zero.
+        From time.Time
+        To time.Time
+
+        // NoiseCountMin is how many PGA values noiseCount needs more than for a component
+        // to be listed, and Limit how many rows a query returns at most.
+        NoiseCountMin int
+        Limit int
+
+        // Components are the noise count components queried, of Components or pga and pgv
+        // for both of a measure's, all of them when empty.
+        Components []string
+
+        // SkipBlacklisted leaves blacklisted stations out of the query.
+        SkipBlacklisted bool
+}
+
+// Query is a check's SQL and the parameters to run it with.
+type Query struct {
+        SQL string
+        Args []interface{}
+}
+
+// ValidateComponents checks components are all known.
+func ValidateComponents(components []string) error {
+        for _, c := range components {
+                switch c {
+                case "pga", "pgv", "pga-true", "pga-false", "pgv-true", "pgv-false":
+                default:
+                        return fmt.Errorf("unknown component %q, expected one of pga, pgv, %s", c, strings.Join(Components, ", "))
+                }
+        }
+        return nil
+}
+
+// orientations is which of measure's vertical and horizontal components are queried.
+func (p QueryParams) orientations(measure string) []string {
+        if len(p.Components) == 0 {
+                return []string{"true", "false"}
+        }
+
+        var orientations []string
+        for _, vertical := range []string{"true", "false"} {
+                for _, c := range p.Components {
+                        if c == measure || c == measure + "-" + vertical {
+                                orientations = append(orientations, vertical)
+                                break
+                        }
+                }
+        }
+        return orientations
+}
+
+// StationPattern is the LIKE pattern that finds loc.station in a comma separated list of
+// stations, with its own % and _ escaped so they don't match other stations.
+const StationPattern = `'%,' || REPLACE(REPLACE(REPLACE(loc.station, '\', '\\'), '%', '\%'), '_', '\_') || ',%' ESCAPE '\'`
+
+// windowTimeLayout is how a UTC timestamptz is written by psql, the bounds are bound in
+// it so a dump loaded into SQLite compares them as text.
+const windowTimeLayout = "2006-01-02 15:04:05-07"
+
+// query numbers the parameters as they're added.
+type query struct {
+        args []interface{}
+}
+
+func (q *query) param(v interface{}) string {
+        q.args = append(q.args, v)
+        return "$" + strconv.Itoa(len(q.args))
+}
+
+// window adds the three parameters every check's query ends with, whether it's bounded
+// and the start and end of the window. Unbounded still binds valid timestamps, Postgres
+// parses them whether or not they're used.
+func (q *query) window(from, to time.Time) (string, string, string) {
+        if to.IsZero() {
+                unbounded := time.Time{}.Format(windowTimeLayout)
+                return q.param(0), q.param(unbounded), q.param(unbounded)
+        }
+        return q.param(1), q.param(from.UTC().Format(windowTimeLayout)), q.param(to.UTC().Format(windowTimeLayout))
+}
+
+// stations is the WHERE for the stations to include and exclude, and with SkipBlacklisted
+// only those that aren't blacklisted. blacklist is cast as a SQLite dump has it as text.
+func stations(p QueryParams, include, exclude string) string {
+        where := `
+	(CAST(` + include + ` AS TEXT) = '' OR ',' || CAST(` + include + ` AS TEXT) || ',' LIKE ` + StationPattern + `)
+	AND NOT ',' || CAST(` + exclude + ` AS TEXT) || ',' LIKE ` + StationPattern
+        if p.SkipBlacklisted {
+                where += `
+	AND CAST(loc.blacklist AS TEXT) <> 'true'`
+        }
+        return where
+}
+
+// NoiseCountQuery counts each station's vertical and horizontal PGA values, those with
+// more than NoiseCountMin, and PGV values, most first and at most Limit rows.
+func NoiseCountQuery(p QueryParams) Query {
+        q := &query{}
+
+        pga, pgv := p.orientations("pga"), p.orientations("pgv")
+
+        var noiseMin string
+        if len(pga) > 0 {
+                noiseMin = q.param(p.NoiseCountMin)
+        }
+        limit := q.param(p.Limit)
+        include, exclude := q.param(p.Include), q.param(p.Exclude)
+        bounded, from, to := q.window(p.From, p.To)
+
+        var parts []string
+        for _, m := range []struct {
+                measure string
+                orientations []string
+        }{{"pga", pga}, {"pgv", pgv}} {
+                if len(m.orientations) == 0 {
+                        continue
+                }
+
+                t := m.measure
+                component := `CASE ` + t + `.vertical WHEN true THEN '` + t + `-true' WHEN false THEN '` + t + `-false' END`
+
+                join := `loc.sourcepk = ` + t + `.sourcepk
+		AND (CAST(` + bounded + ` AS INTEGER) = 0 OR (` + t + `.time >= ` + from + ` AND ` + t + `.time < ` + to + `))`
+                if len(m.orientations) == 1 {
+                        join += `
+		AND ` + t + `.vertical = ` + m.orientations[0]
+                }
+
+                part := `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+        ` + component + ` AS vertical,
+        count(` + t + `.sourcepk) AS noise_count
+FROM
+	impact.` + t + ` ` + t + `
+	RIGHT OUTER JOIN impact.source loc ON ` + join + `
+WHERE` + stations(p, include, exclude) + `
+GROUP BY
+	loc.station, loc.blacklist, ` + component
+                // Only PGA has a threshold, any PGV value is unusual enough.
+                if t == "pga" {
+                        part += `
+HAVING count(pga.sourcepk) > ` + noiseMin
+                }
+                parts = append(parts, part)
+        }
+
+        sql := strings.Join(parts, `
+UNION`) + `
+ORDER BY noise_count desc
+        LIMIT ` + limit
+
+        return Query{SQL: sql, Args: q.args}
+}
+
+// ratioQuery is each station's largest vertical and horizontal value of measure and the
+// ratio of the larger to the smaller, largest ratio first and at most Limit of them. join
+// is how impact.source is joined, with RIGHT OUTER JOIN a station without both components
+// is listed with a NULL ratio.
+func ratioQuery(measure, join string, p QueryParams) Query {
+        q := &query{}
+
+        limit := q.param(p.Limit)
+        include, exclude := q.param(p.Include), q.param(p.Exclude)
+        bounded, from, to := q.window(p.From, p.To)
+
+        largest := func(vertical string) string {
+                return `
+(
+        SELECT
+		sourcepk,
+		MAX(` + measure + `) AS max_` + measure + `
+	FROM
+		impact.` + measure + `
+	WHERE
+		vertical = ` + vertical + `
+		AND (CAST(` + bounded + ` AS INTEGER) = 0 OR (time >= ` + from + ` AND time < ` + to + `))
+	GROUP BY
+		sourcepk
+)`
+        }
+
+        v, h := "max_vert.max_" + measure, "max_hori.max_" + measure
+
+        sql := `
+SELECT
+        CURRENT_TIMESTAMP,
+        loc.station,
+        loc.blacklist,
+	CASE WHEN ` + v + ` > ` + h + ` THEN ` + v + ` / NULLIF(` + h + `, 0) ELSE ` + h + ` / NULLIF(` + v + `, 0) END ratio,
+        ` + v + ` AS max_vertical,
+        ` + h + ` AS max_horizontal
+FROM` + largest("true") + ` max_vert INNER JOIN` + largest("false") + ` max_hori ON max_vert.sourcepk = max_hori.sourcepk
+` + join + ` impact.source loc ON loc.sourcepk = max_hori.sourcepk
+WHERE` + stations(p, include, exclude) + `
+ORDER BY
+	ratio DESC NULLS LAST
+LIMIT ` + limit
+
+        return Query{SQL: sql, Args: q.args}
+}
+
+// RatioDiffQuery is ratioQuery for PGA, listing every station.
+func RatioDiffQuery(p QueryParams) Query {
+        return ratioQuery("pga", "RIGHT OUTER JOIN", p)
+}
+
+// PGVRatioQuery is ratioQuery for PGV, only the stations with values.
+func PGVRatioQuery(p QueryParams) Query {
+        return ratioQuery("pgv", "INNER JOIN", p)
+}
@@ -0,0 +1,168 @@
+package smqc
+
+import (
+        "reflect"
+        "regexp"
+        "sort"
+        "strconv"
+        "strings"
+        "testing"
+        "time"
+)
+
+var unbounded = time.Time{}.Format(windowTimeLayout)
+
+var placeholder = regexp.MustCompile(`\$([0-9]+)`)
+
+// checkParams fails unless the SQL uses every one of q's parameters and no others, Postgres
+// refuses a statement with either.
+func checkParams(t *testing.T, q Query) {
+        t.Helper()
+
+        used := map[int]bool{}
+        for _, m := range placeholder.FindAllStringSubmatch(q.SQL, -1) {
+                n, _ := strconv.Atoi(m[1])
+                used[n] = true
+        }
+
+        var got []int
+        for n := range used {
+                got = append(got, n)
+        }
+        sort.Ints(got)
+
+        var expected []int
+        for i := range q.Args {
+                expected = append(expected, i+1)
+        }
+
+        if !reflect.DeepEqual(got, expected) {
+                t.Errorf("expected parameters %v to be used, got %v in\n%s", expected, got, q.SQL)
+        }
+}
+
+func TestNoiseCountQueryDefault(t *testing.T) {
+        q := NoiseCountQuery(QueryParams{NoiseCountMin: 16, Limit: 10})
+        checkParams(t, q)
+
+        expected := []interface{}{16, 10, "", "", 0, unbounded, unbounded}
+        if !reflect.DeepEqual(q.Args, expected) {
+                t.Errorf("expected args %v, got %v", expected, q.Args)
+        }
+
+        for _, s := range []string{
+                "FROM\n\timpact.pga pga\n",
+                "FROM\n\timpact.pgv pgv\n",
+                "HAVING count(pga.sourcepk) > $1\nUNION",
+                "ORDER BY noise_count desc\n        LIMIT $2",
+                "CAST($3 AS TEXT) = ''",
+                "(CAST($5 AS INTEGER) = 0 OR (pga.time >= $6 AND pga.time < $7))",
+        } {
+                if !strings.Contains(q.SQL, s) {
+                        t.Errorf("expected %q in\n%s", s, q.SQL)
+                }
+        }
+        for _, s := range []string{"vertical = ", "blacklist AS TEXT"} {
+                if strings.Contains(q.SQL, s) {
+                        t.Errorf("didn't expect %q in\n%s", s, q.SQL)
+                }
+        }
+}
+
+func TestNoiseCountQueryPGV(t *testing.T) {
+        q := NoiseCountQuery(QueryParams{NoiseCountMin: 16, Limit: 25, Components: []string{"pgv"}})
+        checkParams(t, q)
+
+        // Without PGA there's no threshold to bind.
+        expected := []interface{}{25, "", "", 0, unbounded, unbounded}
+        if !reflect.DeepEqual(q.Args, expected) {
+                t.Errorf("expected args %v, got %v", expected, q.Args)
+        }
+
+        if strings.Contains(q.SQL, "impact.pga") || strings.Contains(q.SQL, "HAVING") || strings.Contains(q.SQL, "UNION") {
+                t.Errorf("expected only the PGV query, got\n%s", q.SQL)
+        }
+        if !strings.Contains(q.SQL, "LIMIT $1") {
+                t.Errorf("expected LIMIT $1 in\n%s", q.SQL)
+        }
+}
+
+func TestNoiseCountQueryComponent(t *testing.T) {
+        q := NoiseCountQuery(QueryParams{NoiseCountMin: 16, Limit: 10, Components: []string{"pga-true", "pgv-false"}})
+        checkParams(t, q)
+
+        for _, s := range []string{"AND pga.vertical = true\n", "AND pgv.vertical = false\n", "UNION"} {
+                if !strings.Contains(q.SQL, s) {
+                        t.Errorf("expected %q in\n%s", s, q.SQL)
+                }
+        }
+
+        // Both of a measure's components is the same as the measure.
+        both := NoiseCountQuery(QueryParams{Components: []string{"pga-true", "pga-false"}})
+        pga := NoiseCountQuery(QueryParams{Components: []string{"pga"}})
+        if both.SQL != pga.SQL {
+                t.Errorf("expected pga-true,pga-false to build\n%s\ngot\n%s", pga.SQL, both.SQL)
+        }
+}
+
+func TestQuerySkipBlacklisted(t *testing.T) {
+        for name, q := range map[string]Query{
+                "noiseCount": NoiseCountQuery(QueryParams{SkipBlacklisted: true}),
+                "ratioDiff": RatioDiffQuery(QueryParams{SkipBlacklisted: true}),
+                "pgvRatio": PGVRatioQuery(QueryParams{SkipBlacklisted: true}),
+        } {
+                checkParams(t, q)
+
+                // Once for each part of the noiseCount UNION.
+                expected := 1
+                if name == "noiseCount" {
+                        expected = 2
+                }
+                if n := strings.Count(q.SQL, "AND CAST(loc.blacklist AS TEXT) <> 'true'"); n != expected {
+                        t.Errorf("%s: expected the blacklist condition %d times, got %d in\n%s", name, expected, n, q.SQL)
+                }
+        }
+}
+
+func TestRatioQueries(t *testing.T) {
+        from := time.Date(2024, 1, 2, 14, 0, 0, 0, time.UTC)
+        to := from.Add(time.Hour)
+
+        for _, c := range []struct {
+                name string
+                q Query
+                measure string
+                join string
+        }{
+                {"ratioDiff", RatioDiffQuery(QueryParams{Limit: 10, From: from, To: to, Include: "WEL"}), "pga", "RIGHT OUTER JOIN impact.source loc"},
+                {"pgvRatio", PGVRatioQuery(QueryParams{Limit: 10, From: from, To: to, Include: "WEL"}), "pgv", "INNER JOIN impact.source loc"},
+        } {
+                checkParams(t, c.q)
+
+                expected := []interface{}{10, "WEL", "", 1, "2024-01-02 14:00:00+00", "2024-01-02 15:00:00+00"}
+                if !reflect.DeepEqual(c.q.Args, expected) {
+                        t.Errorf("%s: expected args %v, got %v", c.name, expected, c.q.Args)
+                }
+
+                for _, s := range []string{
+                        "MAX(" + c.measure + ") AS max_" + c.measure,
+                        "FROM\n\t\timpact." + c.measure + "\n",
+                        c.join + " ON loc.sourcepk = max_hori.sourcepk",
+                        "ratio DESC NULLS LAST\nLIMIT $1",
+                        "(CAST($4 AS INTEGER) = 0 OR (time >= $5 AND time < $6))",
+                } {
+                        if !strings.Contains(c.q.SQL, s) {
+                                t.Errorf("%s: expected %q in\n%s", c.name, s, c.q.SQL)
+                        }
+                }
+        }
+}
+
+func TestValidateComponents(t *testing.T) {
+        if err := ValidateComponents([]string{"pga", "pgv-true"}); err != nil {
+                t.Error(err)
+        }
+        if err := ValidateComponents([]string{"pga", "mmi"}); err == nil || !strings.Contains(err.Error(), `"mmi"`) {
+                t.Errorf("expected an unknown component error, got %v", err)
+        }
+}
@@ -0,0 +1,143 @@
+/*
+Package smqc runs the strong motion noise checks against the hazard database, for a
+service that wants their results without running the smqc command in cmd/smqc.
+
+        db, _ := sql.Open("postgres", dsn)
+        results, err := smqc.NoiseCount(ctx, db, smqc.Options{From: from, To: to})
+
+Each check returns typed results, which a Writer such as CSVWriter writes out in the
+command's file layout. The command runs noiseCount, ratioDiff and pgvRatio through this
+package, so the results are what it would have found for the window.
+*/
+package smqc
+
+import (
+        "database/sql"
+        "fmt"
+        "strings"
+        "time"
+)
+
+// Options are what the command's flags of the same names set.
+type Options struct {
+        // Include only checks these stations, all of them when empty, and Exclude leaves
+        // these out.
+        Include []string
+        Exclude []string
+
+        // From and To bound the window checked, the whole of the summary tables when To is
+        // zero.
+        From time.Time
+        To time.Time
+
+        // NoiseCountMin is how many values in the window a noisy station has more than,
+        // 16 when zero.
+        NoiseCountMin int
+
+        // Limit is how many stations a check returns at most, 10 when zero.
+        Limit int
+
+        // Components limits NoiseCount to these, see QueryParams.
+        Components []string
+
+        // SkipBlacklisted leaves blacklisted stations out of the results.
+        SkipBlacklisted bool
+
+        // Blacklist overrides impact.source's blacklist flag for these stations, as the
+        // command's blacklist add and remove do.
+        Blacklist map[string]bool
+
+        // Explained says whether a check's result, a NoiseCountResult or RatioResult, is
+        // explained by an earthquake, setting its Explained. The command doesn't count
+        // those as findings, and leaves them out with -quake-filter exclude.
+        Explained func(check string, r Result) bool
+
+        // SkipScanError is given a row that fails to scan, numbered from 1, and says whether
+        // to skip it rather than fail the check. Without it the check fails.
+        SkipScanError func(check string, row int, err error) bool
+}
+
+// blacklisted is a station's blacklist flag, impact.source's unless Blacklist overrides it.
+func (o Options) blacklisted(station, blacklist string) bool {
+        if b, ok := o.Blacklist[station]; ok {
+                return b
+        }
+        return isBlacklisted(blacklist)
+}
+
+func (o Options) explained(check string, r Result) bool {
+        return o.Explained != nil && o.Explained(check, r)
+}
+
+func (o Options) noiseCountMin() int {
+        if o.NoiseCountMin == 0 {
+                return 16
+        }
+        return o.NoiseCountMin
+}
+
+func (o Options) limit() int {
+        if o.Limit == 0 {
+                return 10
+        }
+        return o.Limit
+}
+
+// params are the options as the queries take them.
+func (o Options) params() QueryParams {
+        return QueryParams{
+                Include: strings.Join(o.Include, ","),
+                Exclude: strings.Join(o.Exclude, ","),
+                From: o.From,
+                To: o.To,
+                NoiseCountMin: o.noiseCountMin(),
+                Limit: o.limit(),
+                Components: o.Components,
+                SkipBlacklisted: o.SkipBlacklisted,
+        }
+}
+
+// timeLayouts are the forms CURRENT_TIMESTAMP comes back in as text.
+var timeLayouts = []string{
+        time.RFC3339Nano,
+        "2006-01-02 15:04:05.999999999-07:00",
+        "2006-01-02 15:04:05.999999999Z07:00",
+        "2006-01-02 15:04:05.999999999",
+}
+
+// timestamp scans a row's CURRENT_TIMESTAMP, or is the end of a bounded window as the
+// command's rows are.
+type timestamp struct {
+        time.Time
+        window time.Time
+}
+
+func (t *timestamp) Scan(src interface{}) error {
+        if !t.window.IsZero() {
+                t.Time = t.window.UTC()
+                return nil
+        }
+
+        var s string
+        switch v := src.(type) {
+        case time.Time:
+                t.Time = v.UTC()
+                return nil
+        case string:
+                s = v
+        case []byte:
+                s = string(v)
+        default:
+                return fmt.Errorf("unsupported timestamp type %T", src)
+        }
+
+        for _, layout := range timeLayouts {
+                if at, err := time.Parse(layout, s); err == nil {
+                        t.Time = at.UTC()
+                        return nil
+                }
+        }
+        return fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+var _ sql.Scanner = (*timestamp)(nil)
@@ -0,0 +1,166 @@
+package smqc
+
+import (
+        "bytes"
+        "encoding/csv"
+        "encoding/json"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "strconv"
+        "sync"
+        "time"
+)
+
+// SchemaVersion is the schema_version the command writes with the current columns.
+const SchemaVersion = 1
+
+// Result is a check's result as the command writes it, a row of the check's file.
+type Result interface {
+        Columns() []string
+        Fields() []interface{}
+}
+
+func (NoiseCountResult) Columns() []string {
+        return []string{"timestamp", "station", "blacklist", "component", "noise_count"}
+}
+
+func (r NoiseCountResult) Fields() []interface{} {
+        return []interface{}{r.Timestamp.Format(time.RFC3339), r.Station, strconv.FormatBool(r.Blacklist), r.Component, r.NoiseCount}
+}
+
+func (RatioResult) Columns() []string {
+        return []string{"timestamp", "station", "blacklist", "ratio", "max_vertical", "max_horizontal"}
+}
+
+func (r RatioResult) Fields() []interface{} {
+        return []interface{}{r.Timestamp.Format(time.RFC3339), r.Station, strconv.FormatBool(r.Blacklist), r.Ratio, r.MaxVertical, r.MaxHorizontal}
+}
+
+// Writer is somewhere a check's results go, called once per check with all of them.
+type Writer interface {
+        Write(check string, results []Result) error
+}
+
+// WriterFunc is a function as a Writer.
+type WriterFunc func(check string, results []Result) error
+
+func (f WriterFunc) Write(check string, results []Result) error {
+        return f(check, results)
+}
+
+// NoiseCountResults and RatioResults are a check's results for a Writer.
+func NoiseCountResults(results []NoiseCountResult) []Result {
+        r := make([]Result, len(results))
+        for i := range results {
+                r[i] = results[i]
+        }
+        return r
+}
+
+func RatioResults(results []RatioResult) []Result {
+        r := make([]Result, len(results))
+        for i := range results {
+                r[i] = results[i]
+        }
+        return r
+}
+
+// FloatPrecision is the decimal places the writers here give numbers, as the command's
+// -float-precision default.
+var FloatPrecision = 4
+
+func formatField(v interface{}) string {
+        switch v := v.(type) {
+        case string:
+                return v
+        case int:
+                return strconv.Itoa(v)
+        case float64:
+                return strconv.FormatFloat(v, 'f', FloatPrecision, 64)
+        }
+        return fmt.Sprint(v)
+}
+
+// CSVWriter appends each check's results to <check>.csv in Dir, starting a new file with
+// the header the command writes, so its files and the command's can be read the same way.
+type CSVWriter struct {
+        Dir string
+}
+
+func (w CSVWriter) Write(check string, results []Result) error {
+        if len(results) == 0 {
+                return nil
+        }
+
+        path := filepath.Join(w.Dir, check + ".csv")
+        f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+
+        info, err := f.Stat()
+        if err != nil {
+                return err
+        }
+
+        c := csv.NewWriter(f)
+        if info.Size() == 0 {
+                c.Write(append(results[0].Columns(), "schema_version"))
+        }
+        for _, r := range results {
+                var line []string
+                for _, v := range r.Fields() {
+                        line = append(line, formatField(v))
+                }
+                c.Write(append(line, strconv.Itoa(SchemaVersion)))
+        }
+        c.Flush()
+        if err := c.Error(); err != nil {
+                return fmt.Errorf("writing %s: %w", path, err)
+        }
+
+        return f.Close()
+}
+
+// JSONLWriter writes every check's results to W as JSON lines, the command's -format jsonl
+// fields with the check's name first as check.
+type JSONLWriter struct {
+        W io.Writer
+
+        mu sync.Mutex
+}
+
+func (w *JSONLWriter) Write(check string, results []Result) error {
+        var b bytes.Buffer
+        for _, r := range results {
+                name, _ := json.Marshal(check)
+                b.WriteString(`{"check":`)
+                b.Write(name)
+
+                columns, fields := r.Columns(), r.Fields()
+                for i, v := range fields {
+                        if f, ok := v.(float64); ok {
+                                v = json.Number(formatField(f))
+                        }
+                        key, _ := json.Marshal(columns[i])
+                        value, err := json.Marshal(v)
+                        if err != nil {
+                                return fmt.Errorf("%s column %s: %w", check, columns[i], err)
+                        }
+                        b.WriteByte(',')
+                        b.Write(key)
+                        b.WriteByte(':')
+                        b.Write(value)
+                }
+                b.WriteString(`,"schema_version":` + strconv.Itoa(SchemaVersion) + "}\n")
+        }
+
+        w.mu.Lock()
+        defer w.mu.Unlock()
+
+        _, err := w.W.Write(b.Bytes())
+        return err
+}